@@ -0,0 +1,118 @@
+package casc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+/* =========================
+   BLTE
+   ========================= */
+
+// maxPrealloc caps what decodeBLTE allocates up front from the decoded
+// sizes in a chunk table, which a damaged header can inflate; bigger
+// files grow their buffer chunk by chunk.
+const maxPrealloc = 16 << 20
+
+// decodeBLTE decodes a BLTE stream: a header listing chunks with their
+// sizes and MD5s, or none for a single chunk, then the chunks, each
+// starting with its encoding mode.
+func decodeBLTE(data []byte) ([]byte, error) {
+	if len(data) < 8 || string(data[:4]) != "BLTE" {
+		return nil, corrupt("missing BLTE signature")
+	}
+	hsize := binary.BigEndian.Uint32(data[4:])
+	if hsize == 0 {
+		return decodeChunk(nil, data[8:], -1)
+	}
+
+	if hsize < 12 || int64(hsize) > int64(len(data)) {
+		return nil, corrupt("bad BLTE header size")
+	}
+	if flags := data[8]; flags != 0x0F {
+		return nil, fmt.Errorf("%w: BLTE chunk table flags %#x", ErrUnsupported, flags)
+	}
+	count := int(data[9])<<16 | int(binary.BigEndian.Uint16(data[10:]))
+	if int64(hsize) != 12+int64(count)*24 {
+		return nil, corrupt("bad BLTE chunk count")
+	}
+
+	var total int64
+	for i := 0; i < count; i++ {
+		total += int64(binary.BigEndian.Uint32(data[12+i*24+4:]))
+	}
+	out := make([]byte, 0, min(total, maxPrealloc))
+
+	pos := int64(hsize)
+	for i := 0; i < count; i++ {
+		info := data[12+i*24:]
+		csize := int64(binary.BigEndian.Uint32(info))
+		dsize := int64(binary.BigEndian.Uint32(info[4:]))
+		if csize > int64(len(data))-pos {
+			return nil, corrupt("BLTE chunk %d truncated", i)
+		}
+
+		chunk := data[pos : pos+csize]
+		if sum := md5.Sum(chunk); !bytes.Equal(sum[:], info[8:24]) {
+			return nil, corrupt("BLTE chunk %d checksum mismatch", i)
+		}
+
+		var err error
+		if out, err = decodeChunk(out, chunk, dsize); err != nil {
+			return nil, fmt.Errorf("chunk %d: %w", i, err)
+		}
+		pos += csize
+	}
+	return out, nil
+}
+
+// decodeChunk appends one decoded chunk to out. size is the decoded size
+// from the chunk table, or -1 when there is none.
+func decodeChunk(out, chunk []byte, size int64) ([]byte, error) {
+	if len(chunk) == 0 {
+		return nil, corrupt("empty BLTE chunk")
+	}
+	start := len(out)
+
+	switch mode, body := chunk[0], chunk[1:]; mode {
+	case 'N':
+		out = append(out, body...)
+
+	case 'Z':
+		zr, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, corrupt("zlib: %v", err)
+		}
+		buf := bytes.NewBuffer(out)
+		var r io.Reader = zr
+		if size >= 0 {
+			r = io.LimitReader(zr, size+1)
+		}
+		if _, err := buf.ReadFrom(r); err != nil {
+			return nil, corrupt("zlib: %v", err)
+		}
+		out = buf.Bytes()
+
+	case 'F':
+		inner, err := decodeBLTE(body)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, inner...)
+
+	case 'E':
+		return nil, fmt.Errorf("%w: encrypted BLTE chunk", ErrUnsupported)
+
+	default:
+		return nil, fmt.Errorf("%w: BLTE chunk mode %q", ErrUnsupported, mode)
+	}
+
+	if size >= 0 && int64(len(out)-start) != size {
+		return nil, corrupt("BLTE chunk decoded to %d bytes, want %d", len(out)-start, size)
+	}
+	return out, nil
+}
@@ -0,0 +1,455 @@
+package casc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/* =========================
+   Errors
+   ========================= */
+
+// Storage errors wrap one of these: ErrNotFound for names, IDs and keys
+// the install lacks, ErrCorrupt for damaged indexes, tables and BLTE
+// data, and ErrUnsupported for valid data this reader can't decode,
+// such as encrypted chunks.
+var (
+	ErrNotFound    = errors.New("file not found")
+	ErrCorrupt     = errors.New("corrupt storage")
+	ErrUnsupported = errors.New("unsupported storage feature")
+)
+
+// corrupt returns an ErrCorrupt error describing the damaged structure.
+func corrupt(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrCorrupt, fmt.Sprintf(format, args...))
+}
+
+/* =========================
+   Storage
+   ========================= */
+
+// Storage is a local CASC install, as used by the client since 6.0 and
+// by the modern Classic clients. Files are found by name through a
+// listfile, then through the root file (name to content key), the
+// encoding file (content key to encoded key) and the .idx files
+// (encoded key to a span of a data.### file holding BLTE data).
+//
+// Only data already on disk is read; nothing is fetched from a CDN. A
+// Storage is safe for concurrent use.
+type Storage struct {
+	dataDir string // Data/data
+	version string
+
+	index map[indexKey]indexEntry
+	enc   *encoding
+	root  map[uint32]rootEntry
+
+	names  map[string]uint32 // listfile, by normalized name
+	listed []string          // listfile names present in root, sorted
+
+	mu    sync.Mutex
+	files map[uint32]*os.File // data.### by number
+}
+
+// Options selects what Open loads.
+type Options struct {
+	// Product code from .build.info, e.g. "wow_classic". Empty picks the
+	// product of the folder Open was given (_classic_ and so on), or
+	// else the first active build.
+	Product string
+
+	// Client locale code such as "enUS"; empty means enUS. Files with
+	// several locale versions resolve to this one when present.
+	Locale string
+
+	// Listfile in the community CSV format ("id;path" lines). Without
+	// one, files can only be read by ID.
+	Listfile string
+}
+
+// FileInfo describes one file of the storage.
+type FileInfo struct {
+	Name string
+	ID   uint32 // file data ID
+	CKey [16]byte
+	EKey [16]byte
+	Size int64 // decoded size
+}
+
+// productFolders maps the client folders of a shared install to their
+// .build.info product.
+var productFolders = map[string]string{
+	"_retail_":      "wow",
+	"_classic_":     "wow_classic",
+	"_classic_era_": "wow_classic_era",
+}
+
+// IsStorage reports whether dir is a CASC install: its root, its Data
+// folder or one of its client folders.
+func IsStorage(dir string) bool {
+	_, _, ok := findRoot(dir)
+	return ok
+}
+
+// Root returns the install root of the storage dir belongs to, the
+// folder holding .build.info. See IsStorage.
+func Root(dir string) (string, bool) {
+	root, _, ok := findRoot(dir)
+	return root, ok
+}
+
+// findRoot returns the install root for dir, the folder holding
+// .build.info, and the product dir implies.
+func findRoot(dir string) (root, product string, ok bool) {
+	if fileExists(filepath.Join(dir, ".build.info")) {
+		return dir, "", true
+	}
+	parent := filepath.Dir(dir)
+	if fileExists(filepath.Join(parent, ".build.info")) {
+		return parent, productFolders[strings.ToLower(filepath.Base(dir))], true
+	}
+	return "", "", false
+}
+
+func fileExists(p string) bool {
+	fi, err := os.Stat(p)
+	return err == nil && !fi.IsDir()
+}
+
+// Open loads the storage of the install dir belongs to. See IsStorage.
+func Open(dir string, opts Options) (*Storage, error) {
+	root, product, ok := findRoot(dir)
+	if !ok {
+		return nil, fmt.Errorf("casc: no .build.info in %s or its parent", dir)
+	}
+	if opts.Product != "" {
+		product = opts.Product
+	}
+
+	locale := localeFlags["enUS"]
+	if opts.Locale != "" {
+		if locale, ok = localeFlags[opts.Locale]; !ok {
+			return nil, fmt.Errorf("casc: unknown locale %q", opts.Locale)
+		}
+	}
+
+	build, err := readBuildInfo(filepath.Join(root, ".build.info"), product)
+	if err != nil {
+		return nil, err
+	}
+	key := build["Build Key"]
+	if len(key) < 4 {
+		return nil, corrupt(".build.info: bad build key %q", key)
+	}
+	cfg, err := readConfig(filepath.Join(root, "Data", "config", key[0:2], key[2:4], key))
+	if err != nil {
+		return nil, fmt.Errorf("casc: build config: %w", err)
+	}
+
+	s := &Storage{
+		dataDir: filepath.Join(root, "Data", "data"),
+		version: build["Version"],
+		index:   make(map[indexKey]indexEntry),
+		files:   make(map[uint32]*os.File),
+	}
+	if err := s.load(cfg, locale); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	if opts.Listfile != "" {
+		if err := s.loadListfile(opts.Listfile); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// load reads the indexes, then the encoding and root files the build
+// config names.
+func (s *Storage) load(cfg map[string][]string, locale uint32) error {
+	if err := s.loadIndexes(); err != nil {
+		return err
+	}
+
+	encKeys := cfg["encoding"]
+	if len(encKeys) != 2 {
+		return corrupt("build config: bad encoding entry")
+	}
+	ekey, err := parseKey(encKeys[1])
+	if err != nil {
+		return err
+	}
+	data, err := s.readEncoded(ekey)
+	if err != nil {
+		return fmt.Errorf("casc: encoding: %w", err)
+	}
+	if s.enc, err = parseEncoding(data); err != nil {
+		return fmt.Errorf("casc: encoding: %w", err)
+	}
+
+	rootKeys := cfg["root"]
+	if len(rootKeys) != 1 {
+		return corrupt("build config: bad root entry")
+	}
+	ckey, err := parseKey(rootKeys[0])
+	if err != nil {
+		return err
+	}
+	if data, err = s.readContent(ckey); err != nil {
+		return fmt.Errorf("casc: root: %w", err)
+	}
+	if s.root, err = parseRoot(data, locale); err != nil {
+		return fmt.Errorf("casc: root: %w", err)
+	}
+	return nil
+}
+
+// Close closes the data files.
+func (s *Storage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var first error
+	for _, f := range s.files {
+		if err := f.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	s.files = make(map[uint32]*os.File)
+	return first
+}
+
+// Version returns the client version of the loaded build.
+func (s *Storage) Version() string {
+	return s.version
+}
+
+// ReadFile reads a file by name.
+func (s *Storage) ReadFile(name string) ([]byte, error) {
+	id, ok := s.lookup(name)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return s.ReadFileByID(id)
+}
+
+// ReadFileByID reads a file by file data ID.
+func (s *Storage) ReadFileByID(id uint32) ([]byte, error) {
+	e, ok := s.root[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return s.readContent(e.ckey)
+}
+
+// Open opens a file by name. Files are decoded whole, so this costs the
+// same as ReadFile.
+func (s *Storage) Open(name string) (*File, error) {
+	fi, ok := s.Stat(name)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	data, err := s.readEncoded(fi.EKey)
+	if err != nil {
+		return nil, err
+	}
+	return &File{Reader: bytes.NewReader(data), info: fi}, nil
+}
+
+// Stat describes a file without reading it.
+func (s *Storage) Stat(name string) (FileInfo, bool) {
+	id, ok := s.lookup(name)
+	if !ok {
+		return FileInfo{}, false
+	}
+	e, ok := s.root[id]
+	if !ok {
+		return FileInfo{}, false
+	}
+	ekey, size, ok := s.enc.lookup(e.ckey)
+	if !ok {
+		return FileInfo{}, false
+	}
+	return FileInfo{Name: name, ID: id, CKey: e.ckey, EKey: ekey, Size: size}, true
+}
+
+// List returns the listfile names of every file the root has, sorted.
+func (s *Storage) List() []string {
+	return append([]string(nil), s.listed...)
+}
+
+func (s *Storage) lookup(name string) (uint32, bool) {
+	id, ok := s.names[normalizeName(name)]
+	return id, ok
+}
+
+// readContent reads a file by content key.
+func (s *Storage) readContent(ckey [16]byte) ([]byte, error) {
+	ekey, _, ok := s.enc.lookup(ckey)
+	if !ok {
+		return nil, fmt.Errorf("%w: content key %x not in encoding", ErrNotFound, ckey)
+	}
+	return s.readEncoded(ekey)
+}
+
+// normalizeName makes listfile and lookup names match ignoring case and
+// separator style.
+func normalizeName(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimPrefix(name, "/"), "\\", "/"))
+}
+
+// File is an open file of a Storage.
+type File struct {
+	*bytes.Reader
+	info FileInfo
+}
+
+// Info describes the file, as Stat does.
+func (f *File) Info() FileInfo {
+	return f.info
+}
+
+// Close implements io.Closer.
+func (f *File) Close() error {
+	return nil
+}
+
+/* =========================
+   Config files
+   ========================= */
+
+// readBuildInfo returns the .build.info row of product, or of the first
+// active build when product is empty, by column name.
+func readBuildInfo(path, product string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cols []string
+	var rows []map[string]string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "|")
+
+		// The header names columns as "Name!TYPE:size"
+		if cols == nil {
+			for _, f := range fields {
+				name, _, _ := strings.Cut(f, "!")
+				cols = append(cols, name)
+			}
+			continue
+		}
+
+		row := make(map[string]string, len(cols))
+		for i, f := range fields {
+			if i < len(cols) {
+				row[cols[i]] = f
+			}
+		}
+		rows = append(rows, row)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		if product != "" && row["Product"] == product {
+			return row, nil
+		}
+		if product == "" && row["Active"] == "1" {
+			return row, nil
+		}
+	}
+	if product != "" {
+		return nil, fmt.Errorf("casc: product %q not installed", product)
+	}
+	return nil, fmt.Errorf("casc: no active build in %s", path)
+}
+
+// readConfig parses a "key = value ..." config file.
+func readConfig(path string) (map[string][]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := make(map[string][]string)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		cfg[strings.TrimSpace(k)] = strings.Fields(v)
+	}
+	return cfg, sc.Err()
+}
+
+func parseKey(s string) ([16]byte, error) {
+	var k [16]byte
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(k) {
+		return k, corrupt("bad key %q", s)
+	}
+	copy(k[:], b)
+	return k, nil
+}
+
+/* =========================
+   Listfile
+   ========================= */
+
+// loadListfile reads "id;path" lines. Names of IDs the root lacks are
+// kept, so lookups fail with ErrNotFound, but are not listed.
+func (s *Storage) loadListfile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s.names = make(map[string]uint32)
+	s.listed = nil
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		idStr, name, ok := strings.Cut(strings.TrimSpace(sc.Text()), ";")
+		if !ok || name == "" {
+			continue
+		}
+		var id uint32
+		if _, err := fmt.Sscan(idStr, &id); err != nil {
+			continue
+		}
+
+		s.names[normalizeName(name)] = id
+		if _, ok := s.root[id]; ok {
+			s.listed = append(s.listed, name)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("casc: listfile: %w", err)
+	}
+
+	sort.Strings(s.listed)
+	return nil
+}
@@ -0,0 +1,327 @@
+package casc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// The fixture install in testdata/wow and its listfile are written by
+// testdata/gen.go.
+var (
+	fixtureDir      = filepath.Join("testdata", "wow")
+	fixtureListfile = filepath.Join("testdata", "listfile.csv")
+)
+
+func openFixture(t *testing.T, opts Options) *Storage {
+	t.Helper()
+	if opts.Listfile == "" {
+		opts.Listfile = fixtureListfile
+	}
+	s, err := Open(fixtureDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// genText is the content testdata/gen.go stores for text files.
+func genText(name string, lines int) []byte {
+	var b strings.Builder
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&b, "%s line %04d\n", name, i)
+	}
+	return []byte(b.String())
+}
+
+func TestReadFile(t *testing.T) {
+	s := openFixture(t, Options{})
+
+	tests := []struct {
+		name string
+		want []byte
+	}{
+		{"Interface/Readme.txt", []byte("readme")},         // plain, no chunk table
+		{"DBFilesClient/Map.db2", genText("Map.db2", 400)}, // zlib and plain chunks
+		{"Textures/Nested.txt", []byte("nested frames")},   // 'F' chunk
+		{"interface\\readme.TXT", []byte("readme")},        // any case or separator
+		{"World/Minimaps/Azeroth/map30_31.blp", []byte("tile 30 31")},
+	}
+	for _, tt := range tests {
+		got, err := s.ReadFile(tt.name)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got %q", tt.name, got)
+		}
+
+		fi, ok := s.Stat(tt.name)
+		if !ok || fi.Size != int64(len(tt.want)) {
+			t.Errorf("Stat(%s) = %+v, %v", tt.name, fi, ok)
+		}
+		if f, err := s.Open(tt.name); err != nil || f.Info() != fi || f.Size() != fi.Size {
+			t.Errorf("Open(%s): %v", tt.name, err)
+		}
+	}
+
+	// Files can be read by ID, named or not
+	if got, err := s.ReadFileByID(130); err != nil || string(got) != "unnamed" {
+		t.Errorf("ReadFileByID(130) = %q, %v", got, err)
+	}
+
+	for _, name := range []string{"Interface/Missing.txt", "Interface/Removed.txt"} {
+		if _, err := s.ReadFile(name); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: got %v, want ErrNotFound", name, err)
+		}
+		if _, ok := s.Stat(name); ok {
+			t.Errorf("Stat(%s): found", name)
+		}
+		if _, err := s.Open(name); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Open(%s): got %v, want ErrNotFound", name, err)
+		}
+	}
+	if _, err := s.ReadFileByID(12345); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ReadFileByID(12345): got %v, want ErrNotFound", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	s := openFixture(t, Options{})
+	want := []string{
+		"Creature/Blood.m2",
+		"DBFilesClient/Map.db2",
+		"Interface/GlueXML/Strings.lua",
+		"Interface/Readme.txt",
+		"Textures/Nested.txt",
+		"World/Minimaps/Azeroth/map30_31.blp",
+		"World/Minimaps/Azeroth/map31_31.blp",
+		"World/Minimaps/Kalimdor/map40_12.blp",
+	}
+	if got := s.List(); !slices.Equal(got, want) {
+		t.Errorf("List = %q", got)
+	}
+}
+
+func TestLocales(t *testing.T) {
+	for _, tt := range []struct {
+		locale string
+		want   string
+	}{
+		{"", "english"},
+		{"enUS", "english"},
+		{"deDE", "deutsch"},
+		{"frFR", "english"}, // not present: first listed
+	} {
+		s := openFixture(t, Options{Locale: tt.locale})
+		if got, err := s.ReadFile("Interface/GlueXML/Strings.lua"); err != nil || string(got) != tt.want {
+			t.Errorf("locale %q: got %q, %v", tt.locale, got, err)
+		}
+		// Full-violence content wins in every locale
+		if got, err := s.ReadFile("Creature/Blood.m2"); err != nil || string(got) != "red" {
+			t.Errorf("locale %q: Blood.m2 = %q, %v", tt.locale, got, err)
+		}
+	}
+
+	if _, err := Open(fixtureDir, Options{Locale: "xxXX"}); err == nil {
+		t.Error("unknown locale: expected an error")
+	}
+}
+
+func TestProducts(t *testing.T) {
+	// A shared install and the client folders in it
+	root := t.TempDir()
+	if err := os.CopyFS(root, os.DirFS(fixtureDir)); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{"_classic_", "_classic_era_", "_retail_", "Cache"} {
+		os.Mkdir(filepath.Join(root, d), 0o755)
+	}
+
+	tests := []struct {
+		dir, product string
+		want         string
+	}{
+		{"", "", "4.4.1.57294"}, // first active build
+		{"", "wow_classic_era", "1.15.4.56738"},
+		{"", "wow_classic_era_ptr", "1.15.3.55646"},
+		{"_classic_era_", "", "1.15.4.56738"},
+		{"_classic_", "", "4.4.1.57294"},
+		{"_classic_", "wow_classic_era", "1.15.4.56738"},
+	}
+	for _, tt := range tests {
+		s, err := Open(filepath.Join(root, tt.dir), Options{Product: tt.product, Listfile: fixtureListfile})
+		if err != nil {
+			t.Errorf("%s %s: %v", tt.dir, tt.product, err)
+			continue
+		}
+		if got := s.Version(); got != tt.want {
+			t.Errorf("%s %s: version %s, want %s", tt.dir, tt.product, got, tt.want)
+		}
+		s.Close()
+	}
+
+	// _retail_ names a product the install lacks
+	if _, err := Open(filepath.Join(root, "_retail_"), Options{}); err == nil {
+		t.Error("_retail_: expected an error")
+	}
+
+	for dir, want := range map[string]bool{
+		root:                                true,
+		filepath.Join(root, "_classic_"):    true,
+		filepath.Join(root, "Data"):         true,
+		filepath.Join(root, "Data", "data"): false,
+		t.TempDir():                         false,
+	} {
+		if got := IsStorage(dir); got != want {
+			t.Errorf("IsStorage(%s) = %v, want %v", dir, got, want)
+		}
+	}
+}
+
+func TestOpenWithoutListfile(t *testing.T) {
+	s, err := Open(fixtureDir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if got := s.List(); len(got) != 0 {
+		t.Errorf("List = %q, want none", got)
+	}
+	if _, err := s.ReadFile("Interface/Readme.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ReadFile by name: got %v, want ErrNotFound", err)
+	}
+	if got, err := s.ReadFileByID(100); err != nil || string(got) != "readme" {
+		t.Errorf("ReadFileByID(100) = %q, %v", got, err)
+	}
+}
+
+// copyFixture copies the fixture install to a temporary dir and returns
+// the path of its data.000.
+func copyFixture(t *testing.T) (root, data string) {
+	t.Helper()
+	root = t.TempDir()
+	if err := os.CopyFS(root, os.DirFS(fixtureDir)); err != nil {
+		t.Fatal(err)
+	}
+	return root, filepath.Join(root, "Data", "data", "data.000")
+}
+
+func TestCorruptData(t *testing.T) {
+	root, dataPath := copyFixture(t)
+	data, err := os.ReadFile(dataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Damage the plain chunks of two chunked streams, which their
+	// checksums catch
+	for _, text := range []string{"Map.db2 line 0399", " frames"} {
+		i := bytes.Index(data, []byte(text))
+		if i < 0 {
+			t.Fatalf("%q not stored plain", text)
+		}
+		data[i] ^= 0xFF
+	}
+	if err := os.WriteFile(dataPath, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Open(root, Options{Listfile: fixtureListfile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, name := range []string{"DBFilesClient/Map.db2", "Textures/Nested.txt"} {
+		if _, err := s.ReadFile(name); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: got %v, want ErrCorrupt", name, err)
+		}
+	}
+	if got, err := s.ReadFile("Interface/Readme.txt"); err != nil || string(got) != "readme" {
+		t.Errorf("undamaged file: %q, %v", got, err)
+	}
+
+	// A data file cut short fails every read past the cut
+	if err := os.Truncate(dataPath, 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(root, Options{}); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("truncated data: got %v, want ErrCorrupt", err)
+	}
+}
+
+func TestDecodeBLTE(t *testing.T) {
+	single := []byte("BLTE\x00\x00\x00\x00Nhello")
+	if got, err := decodeBLTE(single); err != nil || string(got) != "hello" {
+		t.Errorf("single chunk: %q, %v", got, err)
+	}
+
+	for name, tc := range map[string]struct {
+		data []byte
+		want error
+	}{
+		"signature": {[]byte("BLTF\x00\x00\x00\x00Nx"), ErrCorrupt},
+		"short":     {[]byte("BLTE"), ErrCorrupt},
+		"empty":     {[]byte("BLTE\x00\x00\x00\x00"), ErrCorrupt},
+		"encrypted": {[]byte("BLTE\x00\x00\x00\x00E..."), ErrUnsupported},
+		"mode":      {[]byte("BLTE\x00\x00\x00\x00Q..."), ErrUnsupported},
+		"header":    {[]byte("BLTE\x00\x00\x00\x40\x0F\x00\x00\x01"), ErrCorrupt},
+		"flags":     {[]byte("BLTE\x00\x00\x00\x24\x10\x00\x00\x01" + strings.Repeat("\x00", 24)), ErrUnsupported},
+		"checksum":  {[]byte("BLTE\x00\x00\x00\x24\x0F\x00\x00\x01\x00\x00\x00\x02\x00\x00\x00\x01" + strings.Repeat("\x00", 16) + "Nx"), ErrCorrupt},
+	} {
+		if _, err := decodeBLTE(tc.data); !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, want %v", name, err, tc.want)
+		}
+	}
+}
+
+func TestParseIndex(t *testing.T) {
+	s := &Storage{index: make(map[indexKey]indexEntry)}
+	for name, data := range map[string][]byte{
+		"short":   []byte("stale"),
+		"header":  []byte("\xff\x00\x00\x00\x00\x00\x00\x00"),
+		"entries": append([]byte("\x10\x00\x00\x00\x00\x00\x00\x00\x07\x00\x00\x00\x04\x05\x09\x1e"+strings.Repeat("\x00", 16)), "\xff\x00\x00\x00\x00\x00\x00\x00"...),
+	} {
+		if err := s.parseIndex(data); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: got %v, want ErrCorrupt", name, err)
+		}
+	}
+
+	v6 := []byte("\x10\x00\x00\x00\x00\x00\x00\x00\x06\x00")
+	v6 = append(v6, make([]byte, 30)...)
+	if err := s.parseIndex(v6); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("version 6: got %v, want ErrUnsupported", err)
+	}
+}
+
+func TestParseRootLegacy(t *testing.T) {
+	// One block of two files, IDs 5 and 7, with interleaved content keys
+	// and name hashes
+	var b bytes.Buffer
+	b.Write([]byte{2, 0, 0, 0, 0, 0, 0, 0, 0x02, 0, 0, 0})
+	b.Write([]byte{5, 0, 0, 0, 1, 0, 0, 0})
+	for _, c := range []byte{0xAA, 0xBB} {
+		b.Write(bytes.Repeat([]byte{c}, 16))
+		b.Write(make([]byte, 8))
+	}
+
+	root, err := parseRoot(b.Bytes(), localeFlags["enUS"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(root) != 2 || root[5].ckey[0] != 0xAA || root[7].ckey[0] != 0xBB {
+		t.Errorf("root = %v", root)
+	}
+
+	if _, err := parseRoot(b.Bytes()[:30], 0); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("truncated: got %v, want ErrCorrupt", err)
+	}
+}
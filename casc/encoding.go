@@ -0,0 +1,85 @@
+package casc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+/* =========================
+   Encoding file
+   ========================= */
+
+// encoding maps content keys (MD5 of the decoded file) to encoded keys
+// (MD5 of the BLTE data). Its entries are kept as the sorted pages of the
+// decoded file and searched on demand, since there are millions of them.
+type encoding struct {
+	firstKeys [][16]byte // first content key of each page
+	pages     [][]byte
+}
+
+const encodingHeaderSize = 22
+
+func parseEncoding(data []byte) (*encoding, error) {
+	if len(data) < encodingHeaderSize || string(data[:2]) != "EN" {
+		return nil, corrupt("missing encoding signature")
+	}
+	if data[2] != 1 {
+		return nil, fmt.Errorf("%w: encoding version %d", ErrUnsupported, data[2])
+	}
+	if data[3] != 16 || data[4] != 16 {
+		return nil, fmt.Errorf("%w: encoding key sizes %d/%d", ErrUnsupported, data[3], data[4])
+	}
+
+	pageSize := int64(binary.BigEndian.Uint16(data[5:])) * 1024
+	pageCount := int64(binary.BigEndian.Uint32(data[9:]))
+	especSize := int64(binary.BigEndian.Uint32(data[18:]))
+
+	// ESpec strings, then the page index, then the content key pages
+	pos := encodingHeaderSize + especSize
+	if pageSize == 0 || pos+pageCount*(32+pageSize) > int64(len(data)) {
+		return nil, corrupt("encoding pages past the end of the file")
+	}
+
+	e := &encoding{
+		firstKeys: make([][16]byte, pageCount),
+		pages:     make([][]byte, pageCount),
+	}
+	pages := pos + pageCount*32
+	for i := int64(0); i < pageCount; i++ {
+		copy(e.firstKeys[i][:], data[pos+i*32:])
+		e.pages[i] = data[pages+i*pageSize : pages+(i+1)*pageSize]
+	}
+	return e, nil
+}
+
+// lookup returns the first encoded key and the decoded size for a
+// content key.
+func (e *encoding) lookup(ckey [16]byte) ([16]byte, int64, bool) {
+	var ekey [16]byte
+
+	i := sort.Search(len(e.firstKeys), func(i int) bool {
+		return bytes.Compare(e.firstKeys[i][:], ckey[:]) > 0
+	}) - 1
+	if i < 0 {
+		return ekey, 0, false
+	}
+
+	// Entries: key count, 40-bit size, content key, encoded keys. Pages
+	// are zero padded.
+	p := e.pages[i]
+	for len(p) >= 22 && p[0] != 0 {
+		n := 22 + int(p[0])*16
+		if n > len(p) {
+			break
+		}
+		if bytes.Equal(p[6:22], ckey[:]) {
+			size := int64(p[1])<<32 | int64(binary.BigEndian.Uint32(p[2:]))
+			copy(ekey[:], p[22:])
+			return ekey, size, true
+		}
+		p = p[n:]
+	}
+	return ekey, 0, false
+}
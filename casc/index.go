@@ -0,0 +1,165 @@
+package casc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/* =========================
+   Local indexes
+   ========================= */
+
+// The .idx files map the first 9 bytes of an encoded key to a span of a
+// data.### file. There are 16 buckets, each rewritten under a new
+// version number as the client updates; only the newest counts.
+
+type indexKey [9]byte
+
+type indexEntry struct {
+	archive uint32
+	offset  int64
+	size    uint32
+}
+
+// dataHeaderSize is the header before the BLTE data of every span: the
+// reversed encoded key, the span size, flags and two checksums.
+const dataHeaderSize = 30
+
+func (s *Storage) loadIndexes() error {
+	paths, err := filepath.Glob(filepath.Join(s.dataDir, "*.idx"))
+	if err != nil {
+		return err
+	}
+
+	// Names are 2 hex digits of bucket and 8 of version
+	newest := make(map[string]string)
+	for _, p := range paths {
+		base := strings.ToLower(filepath.Base(p))
+		if len(base) != 14 {
+			continue
+		}
+		if cur, ok := newest[base[:2]]; !ok || base > strings.ToLower(filepath.Base(cur)) {
+			newest[base[:2]] = p
+		}
+	}
+	if len(newest) == 0 {
+		return fmt.Errorf("casc: no index files in %s", s.dataDir)
+	}
+
+	for _, p := range newest {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if err := s.parseIndex(data); err != nil {
+			return fmt.Errorf("casc: %s: %w", filepath.Base(p), err)
+		}
+	}
+	return nil
+}
+
+// parseIndex reads a version 7 index: a header behind its size and hash,
+// then, 16-byte aligned, the size and hash of the entry block.
+func (s *Storage) parseIndex(data []byte) error {
+	if len(data) < 8 {
+		return corrupt("short index")
+	}
+	hsize := int(binary.LittleEndian.Uint32(data))
+	if hsize < 16 || hsize > len(data)-8 {
+		return corrupt("bad index header size")
+	}
+	h := data[8 : 8+hsize]
+
+	if v := binary.LittleEndian.Uint16(h); v != 7 {
+		return fmt.Errorf("%w: index version %d", ErrUnsupported, v)
+	}
+	sizeLen, offLen, keyLen, offBits := h[4], h[5], h[6], h[7]
+	if sizeLen != 4 || offLen != 5 || keyLen != 9 || offBits > 32 {
+		return fmt.Errorf("%w: index entry layout %d/%d/%d", ErrUnsupported, sizeLen, offLen, keyLen)
+	}
+
+	pos := (8 + hsize + 15) &^ 15
+	if pos+8 > len(data) {
+		return corrupt("short index")
+	}
+	n := int(binary.LittleEndian.Uint32(data[pos:]))
+	pos += 8
+	if n > len(data)-pos {
+		return corrupt("bad index entry block size")
+	}
+
+	const entrySize = 18
+	mask := uint64(1)<<offBits - 1
+	for e := data[pos : pos+n]; len(e) >= entrySize; e = e[entrySize:] {
+		var k indexKey
+		copy(k[:], e)
+		if _, ok := s.index[k]; ok {
+			continue
+		}
+
+		// 40-bit big-endian archive number and offset
+		v := uint64(e[9])<<32 | uint64(binary.BigEndian.Uint32(e[10:]))
+		s.index[k] = indexEntry{
+			archive: uint32(v >> offBits),
+			offset:  int64(v & mask),
+			size:    binary.LittleEndian.Uint32(e[14:]),
+		}
+	}
+	return nil
+}
+
+// readEncoded reads and decodes a file by encoded key.
+func (s *Storage) readEncoded(ekey [16]byte) ([]byte, error) {
+	var k indexKey
+	copy(k[:], ekey[:])
+	e, ok := s.index[k]
+	if !ok {
+		return nil, fmt.Errorf("%w: encoded key %x not in local storage", ErrNotFound, ekey)
+	}
+	if e.size < dataHeaderSize {
+		return nil, corrupt("span of %x too short", ekey)
+	}
+
+	f, size, err := s.dataFile(e.archive)
+	if err != nil {
+		return nil, err
+	}
+	if e.offset+int64(e.size) > size {
+		return nil, corrupt("span of %x past the end of data.%03d", ekey, e.archive)
+	}
+
+	buf := make([]byte, e.size)
+	if _, err := f.ReadAt(buf, e.offset); err != nil {
+		if err == io.EOF {
+			err = corrupt("span of %x truncated", ekey)
+		}
+		return nil, err
+	}
+	return decodeBLTE(buf[dataHeaderSize:])
+}
+
+// dataFile returns an open data.### file and its size.
+func (s *Storage) dataFile(n uint32) (*os.File, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.files[n]
+	if !ok {
+		var err error
+		f, err = os.Open(filepath.Join(s.dataDir, fmt.Sprintf("data.%03d", n)))
+		if err != nil {
+			return nil, 0, err
+		}
+		s.files[n] = f
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	return f, fi.Size(), nil
+}
@@ -0,0 +1,133 @@
+package casc
+
+import (
+	"encoding/binary"
+)
+
+/* =========================
+   Root file
+   ========================= */
+
+// Root locale flags, one bit per client locale.
+var localeFlags = map[string]uint32{
+	"enUS": 0x00002,
+	"koKR": 0x00004,
+	"frFR": 0x00010,
+	"deDE": 0x00020,
+	"zhCN": 0x00040,
+	"esES": 0x00080,
+	"zhTW": 0x00100,
+	"enGB": 0x00200,
+	"esMX": 0x01000,
+	"ruRU": 0x02000,
+	"ptBR": 0x04000,
+	"itIT": 0x08000,
+	"ptPT": 0x10000,
+}
+
+// Root content flags
+const (
+	contentLowViolence = 0x00000080
+	contentNoNameHash  = 0x10000000
+)
+
+// rootMagic is "MFST", the manifest format used since 8.2.
+const rootMagic = 0x4D465354
+
+// rootEntry is the version of a file data ID picked for the locale.
+type rootEntry struct {
+	ckey [16]byte
+	rank uint8
+}
+
+// parseRoot maps file data IDs to content keys. Files listed in several
+// blocks resolve to the one tagged with locale, preferring full-violence
+// content, or else the first listed.
+//
+// The file is a series of blocks, each a record count, content and locale
+// flags, ID deltas, then content keys and name hashes: interleaved in
+// the legacy format, as separate arrays in MFST.
+func parseRoot(data []byte, locale uint32) (map[uint32]rootEntry, error) {
+	pos, version := 0, uint32(0)
+	mfst, allowUnnamed := false, false
+
+	if len(data) >= 12 && binary.LittleEndian.Uint32(data) == rootMagic {
+		mfst = true
+		total, named := binary.LittleEndian.Uint32(data[4:]), binary.LittleEndian.Uint32(data[8:])
+		pos = 12
+
+		// Since 10.1.7 a header size and version come first
+		if total == 24 && (named == 1 || named == 2) && len(data) >= 24 {
+			version = named
+			total, named = binary.LittleEndian.Uint32(data[12:]), binary.LittleEndian.Uint32(data[16:])
+			pos = 24
+		}
+		allowUnnamed = total != named
+	}
+
+	blockHeader := 12
+	if version == 2 {
+		blockHeader = 17
+	}
+
+	root := make(map[uint32]rootEntry)
+	for pos < len(data) {
+		if len(data)-pos < blockHeader {
+			return nil, corrupt("root block header truncated")
+		}
+		b := data[pos:]
+		n := int64(binary.LittleEndian.Uint32(b))
+
+		var content, locales uint32
+		if version == 2 {
+			locales = binary.LittleEndian.Uint32(b[4:])
+			content = binary.LittleEndian.Uint32(b[8:]) | binary.LittleEndian.Uint32(b[12:]) | uint32(b[16])<<17
+		} else {
+			content = binary.LittleEndian.Uint32(b[4:])
+			locales = binary.LittleEndian.Uint32(b[8:])
+		}
+		pos += blockHeader
+
+		hashSize := int64(8)
+		if mfst && allowUnnamed && content&contentNoNameHash != 0 {
+			hashSize = 0
+		}
+		if n*(4+16+hashSize) > int64(len(data)-pos) {
+			return nil, corrupt("root block past the end of the file")
+		}
+
+		var rank uint8
+		if locales&locale != 0 {
+			rank += 2
+		}
+		if content&contentLowViolence == 0 {
+			rank++
+		}
+
+		deltas := data[pos:]
+		keys := data[pos+int(n)*4:]
+		keyStride := 16
+		if !mfst {
+			keyStride = 24
+		}
+
+		id := int64(0)
+		for i := 0; i < int(n); i++ {
+			id += int64(int32(binary.LittleEndian.Uint32(deltas[i*4:])))
+			if id < 0 || id > 0xFFFFFFFF {
+				return nil, corrupt("root file ID %d out of range", id)
+			}
+
+			if cur, ok := root[uint32(id)]; !ok || rank > cur.rank {
+				var e rootEntry
+				copy(e.ckey[:], keys[i*keyStride:])
+				e.rank = rank
+				root[uint32(id)] = e
+			}
+			id++
+		}
+
+		pos += int(n) * (4 + 16 + int(hashSize))
+	}
+	return root, nil
+}
@@ -0,0 +1,341 @@
+//go:build ignore
+
+// gen builds the synthetic CASC install in wow/ and its listfile:
+//
+//	go run gen.go
+//
+// There is no CascLib build to write a fixture with, so this follows the
+// layouts on wowdev.wiki (CASC, BLTE, Encoding, Root, TACT) and shares
+// no code with the reader. The install has two products on one build,
+// an MFST root with locale and low-violence variants, files stored
+// plain, zlib-chunked and nested, and a stale index the reader must
+// skip.
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Root flags
+const (
+	enUS         = 0x2
+	deDE         = 0x20
+	lowViolence  = 0x80
+	noNameHash   = 0x10000000
+	allLocales   = enUS | deDE
+	indexOffBits = 30
+)
+
+// encodeFunc turns file content into a BLTE stream.
+type encodeFunc func([]byte) []byte
+
+type file struct {
+	id      uint32
+	name    string // "" leaves the file out of the listfile
+	data    string
+	locales uint32
+	content uint32
+	encode  encodeFunc
+}
+
+var files = []file{
+	{id: 100, name: "Interface/Readme.txt", data: "readme", locales: allLocales, encode: plain},
+	{id: 101, name: "World/Minimaps/Azeroth/map30_31.blp", data: "tile 30 31", locales: allLocales, encode: plain},
+	{id: 102, name: "World/Minimaps/Azeroth/map31_31.blp", data: "tile 31 31", locales: allLocales, encode: plain},
+	{id: 103, name: "World/Minimaps/Kalimdor/map40_12.blp", data: "tile 40 12", locales: allLocales, encode: plain},
+	{id: 110, name: "DBFilesClient/Map.db2", data: text("Map.db2", 400), locales: allLocales, encode: chunked},
+	{id: 111, name: "Textures/Nested.txt", data: "nested frames", locales: allLocales, encode: nested},
+	{id: 120, name: "Interface/GlueXML/Strings.lua", data: "english", locales: enUS, encode: plain},
+	{id: 120, data: "deutsch", locales: deDE, encode: plain},
+	{id: 121, name: "Creature/Blood.m2", data: "red", locales: allLocales, encode: plain},
+	{id: 121, data: "green", locales: allLocales, content: lowViolence, encode: plain},
+	{id: 130, data: "unnamed", locales: allLocales, content: noNameHash, encode: plain},
+}
+
+// listOnly are listfile names of IDs the root doesn't have.
+var listOnly = map[uint32]string{999: "Interface/Removed.txt"}
+
+func text(name string, lines int) string {
+	var b bytes.Buffer
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&b, "%s line %04d\n", name, i)
+	}
+	return b.String()
+}
+
+func plain(data []byte) []byte {
+	return append([]byte("BLTE\x00\x00\x00\x00N"), data...)
+}
+
+func zlibChunk(data []byte) []byte {
+	var b bytes.Buffer
+	b.WriteByte('Z')
+	zw := zlib.NewWriter(&b)
+	zw.Write(data)
+	zw.Close()
+	return b.Bytes()
+}
+
+// blte writes a chunk table stream from encoded chunks and their
+// decoded sizes.
+func blte(chunks [][]byte, sizes []int) []byte {
+	var b bytes.Buffer
+	b.WriteString("BLTE")
+	binary.Write(&b, binary.BigEndian, uint32(12+24*len(chunks)))
+	b.Write([]byte{0x0F, 0, 0, byte(len(chunks))})
+	for i, c := range chunks {
+		binary.Write(&b, binary.BigEndian, uint32(len(c)))
+		binary.Write(&b, binary.BigEndian, uint32(sizes[i]))
+		sum := md5.Sum(c)
+		b.Write(sum[:])
+	}
+	for _, c := range chunks {
+		b.Write(c)
+	}
+	return b.Bytes()
+}
+
+// chunked splits data into a zlib chunk and a plain one.
+func chunked(data []byte) []byte {
+	half := len(data) / 2
+	return blte(
+		[][]byte{zlibChunk(data[:half]), append([]byte("N"), data[half:]...)},
+		[]int{half, len(data) - half},
+	)
+}
+
+// nested wraps a chunked stream in an 'F' chunk.
+func nested(data []byte) []byte {
+	inner := chunked(data)
+	return blte([][]byte{append([]byte("F"), inner...)}, []int{len(data)})
+}
+
+// storage collects the data.000 spans and their index entries.
+type storage struct {
+	data  bytes.Buffer
+	spans map[[16]byte][2]int // ekey -> offset, size
+}
+
+// add stores a BLTE stream and returns its encoded key.
+func (s *storage) add(stream []byte) [16]byte {
+	ekey := md5.Sum(stream)
+	if _, ok := s.spans[ekey]; ok {
+		return ekey
+	}
+	off := s.data.Len()
+
+	// Header: reversed encoded key, span size, flags, checksums
+	for i := 15; i >= 0; i-- {
+		s.data.WriteByte(ekey[i])
+	}
+	binary.Write(&s.data, binary.LittleEndian, uint32(30+len(stream)))
+	s.data.Write(make([]byte, 10))
+	s.data.Write(stream)
+
+	s.spans[ekey] = [2]int{off, 30 + len(stream)}
+	return ekey
+}
+
+// index writes a version 7 .idx file for the spans, sorted by key.
+func (s *storage) index() []byte {
+	keys := make([][16]byte, 0, len(s.spans))
+	for k := range s.spans {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i][:], keys[j][:]) < 0 })
+
+	var entries bytes.Buffer
+	for _, k := range keys {
+		span := s.spans[k]
+		entries.Write(k[:9])
+		v := uint64(0)<<indexOffBits | uint64(span[0]) // all in data.000
+		entries.WriteByte(byte(v >> 32))
+		binary.Write(&entries, binary.BigEndian, uint32(v))
+		binary.Write(&entries, binary.LittleEndian, uint32(span[1]))
+	}
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, uint32(16)) // header size
+	binary.Write(&b, binary.LittleEndian, uint32(0))  // header hash
+	binary.Write(&b, binary.LittleEndian, uint16(7))  // version
+	b.Write([]byte{0, 0, 4, 5, 9, indexOffBits})
+	binary.Write(&b, binary.LittleEndian, uint64(0x4000000000)) // max file size
+	b.Write(make([]byte, 8))                                    // pad to 16
+	binary.Write(&b, binary.LittleEndian, uint32(entries.Len()))
+	binary.Write(&b, binary.LittleEndian, uint32(0))
+	b.Write(entries.Bytes())
+	return b.Bytes()
+}
+
+type encEntry struct {
+	ckey, ekey [16]byte
+	size       int
+}
+
+// encoding writes a version 1 encoding file with 1 KiB content key
+// pages and no ESpec strings.
+func encoding(entries []encEntry) []byte {
+	sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i].ckey[:], entries[j].ckey[:]) < 0 })
+
+	const pageSize = 1024
+	var pages [][]byte
+	var firstKeys [][16]byte
+	var page bytes.Buffer
+	for _, e := range entries {
+		if page.Len()+38 > pageSize {
+			pages = append(pages, page.Bytes())
+			page = bytes.Buffer{}
+		}
+		if page.Len() == 0 {
+			firstKeys = append(firstKeys, e.ckey)
+		}
+		page.WriteByte(1)
+		page.WriteByte(byte(uint64(e.size) >> 32))
+		binary.Write(&page, binary.BigEndian, uint32(e.size))
+		page.Write(e.ckey[:])
+		page.Write(e.ekey[:])
+	}
+	pages = append(pages, page.Bytes())
+
+	var b bytes.Buffer
+	b.WriteString("EN")
+	b.Write([]byte{1, 16, 16})
+	binary.Write(&b, binary.BigEndian, uint16(pageSize/1024))
+	binary.Write(&b, binary.BigEndian, uint16(1))
+	binary.Write(&b, binary.BigEndian, uint32(len(pages)))
+	binary.Write(&b, binary.BigEndian, uint32(0))
+	b.WriteByte(0)
+	binary.Write(&b, binary.BigEndian, uint32(0)) // ESpec size
+	for i, p := range pages {
+		padded := append(p, make([]byte, pageSize-len(p))...)
+		pages[i] = padded
+		sum := md5.Sum(padded)
+		b.Write(firstKeys[i][:])
+		b.Write(sum[:])
+	}
+	for _, p := range pages {
+		b.Write(p)
+	}
+	return b.Bytes()
+}
+
+// root writes an MFST root with the 10.1.7 header, one block per
+// locale and content flag combination.
+func root(ckeys map[int][16]byte) []byte {
+	type blockKey struct{ locales, content uint32 }
+	var order []blockKey
+	blocks := make(map[blockKey][]int)
+	for i, f := range files {
+		k := blockKey{f.locales, f.content}
+		if _, ok := blocks[k]; !ok {
+			order = append(order, k)
+		}
+		blocks[k] = append(blocks[k], i)
+	}
+
+	var b bytes.Buffer
+	total, named := 0, 0
+	for _, f := range files {
+		total++
+		if f.content&noNameHash == 0 {
+			named++
+		}
+	}
+	b.WriteString("TSFM") // "MFST" as a little-endian magic
+	binary.Write(&b, binary.LittleEndian, uint32(24))
+	binary.Write(&b, binary.LittleEndian, uint32(1))
+	binary.Write(&b, binary.LittleEndian, uint32(total))
+	binary.Write(&b, binary.LittleEndian, uint32(named))
+	b.Write(make([]byte, 4))
+
+	for _, k := range order {
+		idx := blocks[k]
+		binary.Write(&b, binary.LittleEndian, uint32(len(idx)))
+		binary.Write(&b, binary.LittleEndian, k.content)
+		binary.Write(&b, binary.LittleEndian, k.locales)
+
+		prev := int64(-1)
+		for _, i := range idx {
+			binary.Write(&b, binary.LittleEndian, int32(int64(files[i].id)-prev-1))
+			prev = int64(files[i].id)
+		}
+		for _, i := range idx {
+			ck := ckeys[i]
+			b.Write(ck[:])
+		}
+		if k.content&noNameHash == 0 {
+			for _, i := range idx {
+				h := md5.Sum([]byte(files[i].name)) // any 8 bytes will do
+				b.Write(h[:8])
+			}
+		}
+	}
+	return b.Bytes()
+}
+
+func write(name string, data []byte) {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(name, data, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func main() {
+	s := &storage{spans: make(map[[16]byte][2]int)}
+
+	var enc []encEntry
+	ckeys := make(map[int][16]byte)
+	for i, f := range files {
+		data := []byte(f.data)
+		ckey := md5.Sum(data)
+		ckeys[i] = ckey
+		enc = append(enc, encEntry{ckey, s.add(f.encode(data)), len(data)})
+	}
+
+	rootData := root(ckeys)
+	rootCKey := md5.Sum(rootData)
+	enc = append(enc, encEntry{rootCKey, s.add(chunked(rootData)), len(rootData)})
+
+	encData := encoding(enc)
+	encCKey := md5.Sum(encData)
+	encEKey := s.add(chunked(encData))
+
+	config := fmt.Sprintf("# Build Configuration\n\nroot = %x\nencoding = %x %x\nbuild-name = WOW-56738patch1.15.4_ClassicEra\n",
+		rootCKey, encCKey, encEKey)
+	sum := md5.Sum([]byte(config))
+	buildKey := hex.EncodeToString(sum[:])
+
+	dir := "wow"
+	write(filepath.Join(dir, "Data", "data", "data.000"), s.data.Bytes())
+	write(filepath.Join(dir, "Data", "data", "0000000002.idx"), s.index())
+	// An older version of the same bucket, not valid as an index
+	write(filepath.Join(dir, "Data", "data", "0000000001.idx"), []byte("stale"))
+	write(filepath.Join(dir, "Data", "config", buildKey[0:2], buildKey[2:4], buildKey), []byte(config))
+	write(filepath.Join(dir, ".build.info"), []byte(
+		"Branch!STRING:0|Active!DEC:1|Build Key!HEX:16|Version!STRING:0|Product!STRING:0\n"+
+			"eu|0|"+buildKey+"|1.15.3.55646|wow_classic_era_ptr\n"+
+			"eu|1|"+buildKey+"|4.4.1.57294|wow_classic\n"+
+			"eu|1|"+buildKey+"|1.15.4.56738|wow_classic_era\n"))
+
+	var list bytes.Buffer
+	for _, f := range files {
+		if f.name != "" {
+			fmt.Fprintf(&list, "%d;%s\n", f.id, f.name)
+		}
+	}
+	for id, name := range listOnly {
+		fmt.Fprintf(&list, "%d;%s\n", id, name)
+	}
+	write("listfile.csv", list.Bytes())
+}
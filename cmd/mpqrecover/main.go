@@ -0,0 +1,144 @@
+// Command mpqrecover names unlisted files in an MPQ archive by hashing
+// candidate names and writes the matches as a listfile.
+//
+//	mpqrecover [-dict words.txt,...] [-maps Azeroth,Kalimdor] [-md5translate md5translate.trs] [-o out.txt] [-export dir] archive.mpq
+//
+// With -export, files still unnamed afterwards are written to dir as
+// FileNNNNNNNN.ext, decrypting them by recovered key where needed.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"wowmap/mpq"
+)
+
+func main() {
+	dicts := flag.String("dict", "", "comma-separated dictionary/listfile paths")
+	maps := flag.String("maps", "", "comma-separated map directory names to expand path templates for")
+	md5Path := flag.String("md5translate", "", "md5translate.trs on disk (the archive's own copy is always tried)")
+	out := flag.String("o", "", "output listfile (default stdout)")
+	export := flag.String("export", "", "directory to extract still-unnamed files into")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	m, err := mpq.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer m.Close()
+
+	r := m.NewRecovery()
+	log.Printf("%d unnamed entries", r.Remaining())
+
+	for _, p := range splitList(*dicts) {
+		f, err := os.Open(p)
+		if err != nil {
+			log.Fatal(err)
+		}
+		n, err := r.TryReader(f)
+		f.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("%s: %d recovered", p, n)
+	}
+
+	if *md5Path != "" {
+		data, err := os.ReadFile(*md5Path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("%s: %d recovered", *md5Path, r.TryMD5Translate(data))
+	}
+	if data, err := m.ReadFile("Textures\\Minimap\\md5translate.trs"); err == nil {
+		log.Printf("archive md5translate.trs: %d recovered", r.TryMD5Translate(data))
+	}
+
+	mapNames := append(splitList(*maps), r.MapNames()...)
+	for _, name := range mapNames {
+		if n := r.TryMap(name); n > 0 {
+			log.Printf("map %s: %d recovered", name, n)
+		}
+	}
+
+	log.Printf("%d recovered, %d still unnamed", len(r.Found()), r.Remaining())
+
+	if *export != "" {
+		exportUnnamed(m, *export)
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := r.WriteListfile(w); err != nil {
+		log.Fatal(fmt.Errorf("writing listfile: %w", err))
+	}
+}
+
+func exportUnnamed(m *mpq.MPQ, dir string) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	for _, idx := range m.UnnamedBlocks() {
+		data, err := m.ReadBlock(idx)
+		if err != nil {
+			log.Printf("block %d: %v", idx, err)
+			continue
+		}
+		name := filepath.Join(dir, fmt.Sprintf("File%08d%s", idx, guessExt(data)))
+		if err := os.WriteFile(name, data, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// guessExt picks a file extension from well-known magic numbers.
+func guessExt(data []byte) string {
+	magics := []struct {
+		magic string
+		ext   string
+	}{
+		{"BLP2", ".blp"},
+		{"BLP1", ".blp"},
+		{"WDBC", ".dbc"},
+		{"MD20", ".m2"},
+		{"RIFF", ".wav"},
+		{"ID3", ".mp3"},
+		{"REVM", ".adt"},
+		{"MPQ\x1A", ".mpq"},
+	}
+	for _, m := range magics {
+		if bytes.HasPrefix(data, []byte(m.magic)) {
+			return m.ext
+		}
+	}
+	return ".xxx"
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
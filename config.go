@@ -14,6 +14,53 @@ import (
 // Config is the root config.json structure
 type Config struct {
     WowDataPath     string     `json:"wow_data_path"`
+
+    // Client version whose data layout to use: "1.12", "2.4.3",
+    // "3.3.5", or "casc" for modern clients such as Classic. Detected
+    // from the data dir when empty.
+    ClientVersion string `json:"client_version,omitempty"`
+
+    // Locale folder in the data dir, e.g. "enUS". Detected when empty.
+    Locale string `json:"locale,omitempty"`
+    // Archives in load order, lowest priority first, replacing the
+    // built-in order of the base and locale archives. Names are relative
+    // to the data dir; {locale} is replaced by the locale and patterns
+    // such as "common-*.MPQ" load every match.
+    LoadOrder []string `json:"load_order,omitempty"`
+    // Patch archives, loaded after the load order in the same way and
+    // replacing the built-in patch order. Empty files in a patch delete
+    // the file from the archives below it.
+    PatchOrder []string `json:"patch_order,omitempty"`
+    // Patch archives or patterns loaded last, e.g. "patch-x-custom.MPQ"
+    ExtraArchives []string `json:"extra_archives,omitempty"`
+
+    // CASC only: the product to load from a shared install, e.g.
+    // "wow_classic_era", and the community listfile (CSV of "id;path"
+    // lines) naming its files
+    Product  string `json:"product,omitempty"`
+    Listfile string `json:"listfile,omitempty"`
+
+    // Seconds between checks of the data dir and loose-file dirs for
+    // changes, which reload the data. 0 means 2; -1 turns reloading off.
+    ReloadPollSeconds int `json:"reload_poll_seconds,omitempty"`
+
+    // Check archive signatures at startup (hashes every archive, slow)
+    VerifySignatures    bool        `json:"verify_signatures,omitempty"`
+    // PEM files with public keys for strong ("NGIS") signatures
+    StrongSignatureKeys []string    `json:"strong_signature_keys,omitempty"`
+
+    // Directories of loose files (e.g. a Noggit project) layered over
+    // the archives
+    LooseFiles []LooseFileDir `json:"loose_files,omitempty"`
+}
+
+// LooseFileDir places a directory of loose files in the archive stack
+type LooseFileDir struct {
+    Path string `json:"path"`
+
+    // Archive the directory sits directly above, e.g. "patch-3.MPQ".
+    // Empty puts it above every archive.
+    After string `json:"after,omitempty"`
 }
 
 // loadOrInitConfig loads config.json, or generates a template if missing
@@ -1,15 +1,19 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
     "io/fs"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/hajimehoshi/ebiten/v2"
 
+	"wowmap/casc"
 	"wowmap/mpq"
 	"wowmap/vfs"
 )
@@ -18,6 +22,13 @@ type AppContext struct {
 	Cfg      *Config
     FS       fs.FS
 	Minimaps map[string][]TileRef
+	Profile  *ClientProfile
+
+	// Archives whose signature check failed
+	Tampered []string
+
+	// Rebuilt after the data changed; signatures are not checked again
+	Reload bool
     
     
 }
@@ -34,6 +45,9 @@ func main() {
     }
 
     game := NewGame(ctx, bootErr)
+    if bootErr == nil {
+        go watchData(ctx.Cfg, ctx.Profile, game.reloads)
+    }
 
     ebiten.SetWindowSize(1920, 1080)
     ebiten.SetWindowResizable(true)
@@ -55,6 +69,12 @@ func initAppContext() (*AppContext, error) {
 		return nil, fmt.Errorf("config.json created, please edit it and restart")
 	}
 
+	if cfg.VerifySignatures {
+		if err := loadSignatureKeys(cfg.StrongSignatureKeys); err != nil {
+			return nil, err
+		}
+	}
+
 	ctx := &AppContext{
 		Cfg: cfg,
 	}
@@ -63,95 +83,309 @@ func initAppContext() (*AppContext, error) {
 }
 
 func initVFS(ctx *AppContext) error {
-	stack := vfs.New()
+	profile, err := selectProfile(ctx.Cfg)
+	if err != nil {
+		return err
+	}
+	log.Printf("client profile %s", profile.Name)
+	ctx.Profile = profile
 
-	wowDataDir := ctx.Cfg.WowDataPath
-	if err := LoadMPQs(stack, wowDataDir); err != nil {
+	if profile.CASC {
+		err = initCASC(ctx)
+	} else {
+		err = initMPQs(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	var maps map[string][]TileRef
+	if profile.MD5Translate != "" {
+		maps, err = ParseMD5TranslateFromFS(ctx.FS, profile.MD5Translate)
+	} else {
+		maps, err = ScanMinimapsFromFS(ctx.FS, profile.MinimapDir)
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx.Minimaps = maps
+	return nil
+}
+
+// initMPQs stacks the archives of the data dir.
+func initMPQs(ctx *AppContext) error {
+	stack := vfs.New()
+	if err := LoadMPQs(stack, ctx.Cfg, ctx.Profile); err != nil {
 		return err
 	}
 
 	ctx.FS = vfs.NewFS(stack)
 
-	md5Path := "Textures/Minimap/md5translate.trs"
-	maps, err := ParseMD5TranslateFromFS(ctx.FS, md5Path)
+	if ctx.Cfg.VerifySignatures && !ctx.Reload {
+		ctx.Tampered = checkSignatures(stack)
+	}
+	return nil
+}
+
+// initCASC opens the CASC storage of the data dir.
+func initCASC(ctx *AppContext) error {
+	if ctx.Cfg.Listfile == "" {
+		return fmt.Errorf("CASC storage needs a listfile, set \"listfile\" in config.json")
+	}
+
+	// NOTE: the storage stays open for the lifetime of the app
+	storage, err := casc.Open(ctx.Cfg.WowDataPath, casc.Options{
+		Product:  ctx.Cfg.Product,
+		Locale:   ctx.Cfg.Locale,
+		Listfile: ctx.Cfg.Listfile,
+	})
 	if err != nil {
 		return err
 	}
+	log.Printf("CASC build %s", storage.Version())
 
-	ctx.Minimaps = maps
+	ctx.FS = vfs.NewCASCFS(storage)
+	return nil
+}
+
+// loadSignatureKeys registers the strong signature keys named in
+// config.json.
+func loadSignatureKeys(keyFiles []string) error {
+	for _, path := range keyFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := mpq.AddStrongSignatureKey(data); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
 	return nil
 }
 
-// LoadMPQs loads MPQs in Blizzard's static patch order.
-// Missing MPQs are skipped (normal for some installs).
-func LoadMPQs(stack *vfs.MPQStack, dataDir string) error {
-	// Case-insensitive filename lookup in Data dir
-	findInDir := func(want string) (string, bool) {
-		want = strings.ToLower(want)
+// checkSignatures verifies every loaded archive and returns the names of
+// those whose signature no longer matches. Unsigned archives (custom
+// patches) are normal and only logged.
+func checkSignatures(stack *vfs.MPQStack) []string {
+	var tampered []string
+	for _, src := range stack.Sources() {
+		if src.Archive == nil {
+			continue
+		}
+		name := filepath.Base(src.Path)
 
-		entries, err := os.ReadDir(dataDir)
+		res, err := src.Archive.VerifySignature()
 		if err != nil {
-			return "", false
+			log.Printf("%s: signature check failed: %v", name, err)
+			continue
+		}
+
+		switch {
+		case res.Tampered():
+			log.Printf("WARNING: %s has been modified (weak: %s, strong: %s)", name, res.Weak, res.Strong)
+			tampered = append(tampered, name)
+		case res.Unsigned():
+			log.Printf("%s: unsigned", name)
 		}
+	}
+	return tampered
+}
 
-		for _, e := range entries {
-			if e.IsDir() {
+// LoadMPQs loads MPQs in the client profile's order, then its patches,
+// either replaced by config.json, plus any extra archives it lists as
+// further patches. Missing MPQs are skipped (normal for some installs).
+// Loose-file directories are layered above the archive they name.
+func LoadMPQs(stack *vfs.MPQStack, cfg *Config, profile *ClientProfile) error {
+	dataDir := cfg.WowDataPath
+	remote := isRemote(dataDir)
+
+	locale := cfg.Locale
+	if locale == "" && !remote {
+		locale = detectLocale(dataDir)
+	}
+	if id, ok := mpq.ParseLocale(locale); ok {
+		stack.SetLocales(id)
+	}
+
+	order := profile.LoadOrder
+	if len(cfg.LoadOrder) > 0 {
+		order = cfg.LoadOrder
+	}
+	patches := profile.Patches
+	if len(cfg.PatchOrder) > 0 {
+		patches = cfg.PatchOrder
+	}
+	patches = append(patches[:len(patches):len(patches)], cfg.ExtraArchives...)
+
+	// Add the loose-file layers selected by match, once each
+	loose := cfg.LooseFiles
+	placed := make([]bool, len(loose))
+	addLoose := func(match func(LooseFileDir) bool) error {
+		for i, l := range loose {
+			if placed[i] || !match(l) {
 				continue
 			}
-			if strings.ToLower(e.Name()) == want {
-				return filepath.Join(dataDir, e.Name()), true
+			d, err := vfs.OpenDir(l.Path)
+			if err != nil {
+				return fmt.Errorf("loose files %s: %w", l.Path, err)
+			}
+			placed[i] = true
+			if err := stack.AddDir(d); err != nil {
+				return err
 			}
 		}
-		return "", false
+		return nil
 	}
 
-	// Open and add MPQ if present
-	addIfExists := func(name string) error {
-		full, ok := findInDir(name)
-		if !ok {
-			return nil
+	// Open and add one archive, given relative to the data dir
+	addArchive := func(name string, patch bool) error {
+		var a *mpq.MPQ
+		var err error
+		if remote {
+			url := strings.TrimSuffix(dataDir, "/") + "/" + name
+			a, err = vfs.OpenHTTPMPQ(url)
+			if errors.Is(err, vfs.ErrHTTPNotFound) {
+				return nil
+			}
+		} else {
+			// NOTE: MPQs stay open for the lifetime of the app
+			a, err = mpq.Open(filepath.Join(dataDir, filepath.FromSlash(name)))
 		}
-
-		a, err := mpq.Open(full)
 		if err != nil {
-			return fmt.Errorf("open %s: %w", full, err)
+			return fmt.Errorf("open %s: %w", name, err)
+		}
+		add := stack.Add
+		if patch {
+			add = stack.AddPatch
+		}
+		if err := add(a); err != nil {
+			return err
 		}
 
-		// NOTE: MPQs stay open for the lifetime of the app
-		return stack.Add(a)
+		return addLoose(func(l LooseFileDir) bool {
+			return strings.EqualFold(l.After, name) || strings.EqualFold(l.After, path.Base(name))
+		})
 	}
 
-	// Fixed base order
-	fixed := []string{
-		"base.MPQ",
-		"common.MPQ",
-		"common-2.MPQ",
-		"expansion.MPQ",
-		"lichking.MPQ",
-		"patch.MPQ",
-		"patch-2.MPQ",
-		"patch-3.MPQ",
+	// Add the archives of each entry, once each
+	loaded := make(map[string]bool)
+	addEntries := func(entries []string, patch bool) error {
+		for _, entry := range entries {
+			if strings.Contains(entry, "{locale}") {
+				if locale == "" {
+					continue
+				}
+				entry = strings.ReplaceAll(entry, "{locale}", locale)
+			}
+
+			var names []string
+			switch {
+			case !remote:
+				names = findArchives(dataDir, entry)
+			case strings.ContainsAny(entry, "*?["):
+				log.Printf("%s: patterns need a local data dir, skipped", entry)
+			default:
+				names = []string{entry}
+			}
+
+			for _, name := range names {
+				if loaded[strings.ToLower(name)] {
+					continue
+				}
+				loaded[strings.ToLower(name)] = true
+
+				if err := addArchive(name, patch); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
 	}
 
-	for _, name := range fixed {
-		if err := addIfExists(name); err != nil {
-			return err
+	if err := addEntries(order, false); err != nil {
+		return err
+	}
+	if err := addEntries(patches, true); err != nil {
+		return err
+	}
+
+	// Everything else goes on top, including layers naming an archive
+	// that wasn't loaded
+	for i, l := range loose {
+		if !placed[i] && l.After != "" {
+			log.Printf("loose files %s: %s not loaded, layering on top", l.Path, l.After)
 		}
 	}
+	return addLoose(func(LooseFileDir) bool { return true })
+}
 
-	// Numeric patches: patch-4 .. patch-9
-	for n := 4; n <= 9; n++ {
-		if err := addIfExists(fmt.Sprintf("patch-%d.MPQ", n)); err != nil {
-			return err
+// findArchives returns the archives a load order entry names, relative
+// to dataDir with '/' separators and their on-disk casing. Each path
+// element matches case-insensitively and may be a pattern.
+func findArchives(dataDir, entry string) []string {
+	elems := strings.Split(entry, "/")
+	found := []string{""}
+
+	for i, elem := range elems {
+		last := i == len(elems)-1
+		pattern := strings.ToLower(elem)
+
+		var next []string
+		for _, dir := range found {
+			entries, err := os.ReadDir(filepath.Join(dataDir, filepath.FromSlash(dir)))
+			if err != nil {
+				continue
+			}
+			for _, e := range entries {
+				if e.IsDir() == last {
+					continue
+				}
+				if ok, _ := filepath.Match(pattern, strings.ToLower(e.Name())); ok {
+					next = append(next, path.Join(dir, e.Name()))
+				}
+			}
 		}
+		found = next
 	}
 
-	// Letter patches: patch-a .. patch-z
-	for ch := 'a'; ch <= 'z'; ch++ {
-		if err := addIfExists(fmt.Sprintf("patch-%c.MPQ", ch)); err != nil {
-			return err
+	sort.Slice(found, func(i, j int) bool {
+		return archiveSortKey(found[i]) < archiveSortKey(found[j])
+	})
+	return found
+}
+
+// archiveSortKey orders archives the way the client loads them: by name
+// ignoring case and extension, so patch.MPQ comes before patch-2.MPQ.
+func archiveSortKey(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, path.Ext(name)))
+}
+
+// detectLocale returns the locale folder of a data dir, preferring enUS
+// when there are several. It returns "" when there is none.
+func detectLocale(dataDir string) string {
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return ""
+	}
+
+	var found []string
+	for _, e := range entries {
+		if _, ok := mpq.ParseLocale(e.Name()); ok && e.IsDir() {
+			found = append(found, e.Name())
 		}
 	}
 
-	return nil
+	switch {
+	case len(found) == 0:
+		return ""
+	case len(found) > 1:
+		log.Printf("several locale folders %v, set \"locale\" in config.json to pick one", found)
+		for _, l := range found {
+			if l == "enUS" {
+				return l
+			}
+		}
+	}
+	return found[0]
 }
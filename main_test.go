@@ -0,0 +1,176 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"wowmap/mpq"
+	"wowmap/vfs"
+)
+
+// writeArchive saves an archive holding one file, who.txt, whose
+// content is the archive's name.
+func writeArchive(t *testing.T, dataDir, name string) {
+	t.Helper()
+	p := filepath.Join(dataDir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	w := mpq.NewWriter()
+	if err := w.Add("who.txt", []byte(name), mpq.MPQ_FILE_COMPRESS, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Save(p); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindArchives(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"base.MPQ",
+		"patch.MPQ",
+		"Patch-2.mpq",
+		"patch-4.MPQ",
+		"patch-a.MPQ",
+		"enUS/patch-enUS-5.MPQ",
+	} {
+		writeArchive(t, dir, name)
+	}
+	// Directories never match the last element, nor files the others
+	os.Mkdir(filepath.Join(dir, "patch-5.MPQ"), 0755)
+	os.WriteFile(filepath.Join(dir, "deDE"), nil, 0644)
+
+	tests := []struct {
+		entry string
+		want  []string
+	}{
+		{"base.MPQ", []string{"base.MPQ"}},
+		{"PATCH-2.MPQ", []string{"Patch-2.mpq"}},
+		{"patch-[4-9].MPQ", []string{"patch-4.MPQ"}},
+		{"patch-*.MPQ", []string{"Patch-2.mpq", "patch-4.MPQ", "patch-a.MPQ"}},
+		{"patch*.MPQ", []string{"patch.MPQ", "Patch-2.mpq", "patch-4.MPQ", "patch-a.MPQ"}},
+		{"enus/patch-enus-[4-9].mpq", []string{"enUS/patch-enUS-5.MPQ"}},
+		{"*/patch-*.MPQ", []string{"enUS/patch-enUS-5.MPQ"}},
+		{"missing.MPQ", nil},
+		{"deDE/patch-deDE.MPQ", nil},
+	}
+	for _, tt := range tests {
+		if got := findArchives(dir, tt.entry); !slices.Equal(got, tt.want) {
+			t.Errorf("findArchives(%q) = %q, want %q", tt.entry, got, tt.want)
+		}
+	}
+}
+
+func TestDetectLocale(t *testing.T) {
+	dir := t.TempDir()
+	if got := detectLocale(dir); got != "" {
+		t.Errorf("no locale folders: got %q", got)
+	}
+
+	os.WriteFile(filepath.Join(dir, "enUS"), nil, 0644)
+	os.Mkdir(filepath.Join(dir, "Cache"), 0755)
+	os.Mkdir(filepath.Join(dir, "deDE"), 0755)
+	if got := detectLocale(dir); got != "deDE" {
+		t.Errorf("one locale folder: got %q, want deDE", got)
+	}
+
+	os.Remove(filepath.Join(dir, "enUS"))
+	os.Mkdir(filepath.Join(dir, "enUS"), 0755)
+	if got := detectLocale(dir); got != "enUS" {
+		t.Errorf("several locale folders: got %q, want enUS", got)
+	}
+}
+
+func TestLoadMPQsOrder(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"common.MPQ",
+		"patch.MPQ",
+		"patch-3.MPQ",
+		"patch-b.MPQ",
+		"patch-x-custom.MPQ",
+		"enUS/locale-enUS.MPQ",
+		"enUS/patch-enUS-2.MPQ",
+	} {
+		writeArchive(t, dir, name)
+	}
+	loose := filepath.Join(dir, "loose")
+	os.Mkdir(loose, 0755)
+
+	stack := vfs.New()
+	cfg := &Config{
+		WowDataPath:   dir,
+		ExtraArchives: []string{"patch-x-*.MPQ"},
+		LooseFiles:    []LooseFileDir{{Path: loose, After: "patch.mpq"}},
+	}
+	if err := LoadMPQs(stack, cfg, findProfile("3.3.5")); err != nil {
+		t.Fatal(err)
+	}
+
+	var got, patches []string
+	for _, src := range stack.Sources() {
+		rel, _ := filepath.Rel(dir, src.Path)
+		got = append(got, filepath.ToSlash(rel))
+		if src.Patch {
+			patches = append(patches, filepath.ToSlash(rel))
+		}
+	}
+	want := []string{
+		"common.MPQ",
+		"enUS/locale-enUS.MPQ",
+		"patch.MPQ",
+		"loose",
+		"patch-3.MPQ",
+		"patch-b.MPQ",
+		"enUS/patch-enUS-2.MPQ",
+		"patch-x-custom.MPQ",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("load order %q, want %q", got, want)
+	}
+	wantPatches := []string{
+		"patch.MPQ",
+		"patch-3.MPQ",
+		"patch-b.MPQ",
+		"enUS/patch-enUS-2.MPQ",
+		"patch-x-custom.MPQ",
+	}
+	if !slices.Equal(patches, wantPatches) {
+		t.Errorf("patches %q, want %q", patches, wantPatches)
+	}
+
+	if data, err := stack.ReadFile("who.txt"); err != nil || string(data) != "patch-x-custom.MPQ" {
+		t.Errorf("who.txt: %q, %v", data, err)
+	}
+}
+
+func TestLoadMPQsPatchOrder(t *testing.T) {
+	// Archives are patches by their place in the order, not their names
+	dir := t.TempDir()
+	for _, name := range []string{"common.MPQ", "patch.MPQ", "override.MPQ"} {
+		writeArchive(t, dir, name)
+	}
+
+	stack := vfs.New()
+	cfg := &Config{
+		WowDataPath: dir,
+		LoadOrder:   []string{"common.MPQ", "patch.MPQ"},
+		PatchOrder:  []string{"override.MPQ"},
+	}
+	if err := LoadMPQs(stack, cfg, findProfile("3.3.5")); err != nil {
+		t.Fatal(err)
+	}
+
+	var patches []string
+	for _, src := range stack.Sources() {
+		if src.Patch {
+			patches = append(patches, filepath.Base(src.Path))
+		}
+	}
+	if !slices.Equal(patches, []string{"override.MPQ"}) {
+		t.Errorf("patches %q, want override.MPQ", patches)
+	}
+}
@@ -59,6 +59,9 @@ type Game struct {
 
 	// UI
 	selector *MapSelector
+
+	// Data rebuilt by watchData
+	reloads chan *dataReload
     
     // Startup error handling
     bootErr  error
@@ -76,6 +79,7 @@ func NewGame(ctx *AppContext, bootErr error) *Game {
         tiles:   make(map[tileKey]*ebiten.Image),
         zoom:    1,
         current: -1,
+        reloads: make(chan *dataReload, 1),
     }
 
     // If startup failed, return game early
@@ -97,11 +101,6 @@ func NewGame(ctx *AppContext, bootErr error) *Game {
     return g
 }
 
-func mpqPath(p string) string {
-	p = strings.ReplaceAll(p, "/", "\\")
-	return strings.ToUpper(p)
-}
-
 func (g *Game) loadMap(index int) {
 	g.tiles = make(map[tileKey]*ebiten.Image)
 	g.current = index
@@ -115,7 +114,7 @@ func (g *Game) loadMap(index int) {
 	g.tileW, g.tileH = 0, 0
 
 	for _, t := range tiles {
-        path := mpqPath("textures/minimap/" + t.Hash)
+        path := t.Path
 
         // Cache hit
         if cached, ok := g.cache.Get(path); ok {
@@ -131,7 +130,7 @@ func (g *Game) loadMap(index int) {
         // Cache miss
         img, err := blp.DecodeBLPFromFS(g.ctx.FS, path)
         if err != nil {
-            log.Println(t.Hash, err)
+            log.Println(t.Path, err)
             continue
         }
 
@@ -164,6 +163,13 @@ func (g *Game) Update() error {
         return nil
     }
 
+	// Swap in reloaded data
+	select {
+	case r := <-g.reloads:
+		g.applyReload(r)
+	default:
+	}
+
 	mx, my := ebiten.CursorPosition()
 
 	// Open map selector
@@ -379,6 +385,12 @@ func (g *Game) drawHeader(screen *ebiten.Image) {
 	}
 
 	text.Draw(screen, label, basicfont.Face7x13, 18, 28, color.White)
+
+	if len(g.ctx.Tampered) > 0 {
+		warn := "Modified archives: " + strings.Join(g.ctx.Tampered, ", ")
+		ui.DrawRect(screen, 10, 40, len(warn)*7+16, 26, color.RGBA{120, 20, 20, 255})
+		text.Draw(screen, warn, basicfont.Face7x13, 18, 58, color.White)
+	}
 }
 
 /* =======================
@@ -5,6 +5,7 @@ import (
 	"image/draw"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -15,6 +16,9 @@ import (
 type TileRef struct {
 	X, Y int
 	Hash string
+
+	// Path of the tile image in the data filesystem
+	Path string
 }
 
 /* =======================
@@ -44,6 +48,52 @@ func ParseMD5TranslateFromBytes(data []byte) (map[string][]TileRef, error) {
 	return parseMD5Translate(data)
 }
 
+// ScanMinimapsFromFS finds minimap tiles stored under their own names,
+// <dir>/<map>/mapXX_YY.blp, as modern clients have them.
+func ScanMinimapsFromFS(fsys fs.FS, dir string) (map[string][]TileRef, error) {
+	maps, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string][]TileRef)
+	for _, m := range maps {
+		if !m.IsDir() {
+			continue
+		}
+		mapDir := path.Join(dir, m.Name())
+
+		files, err := fs.ReadDir(fsys, mapDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			name := strings.ToLower(f.Name())
+			if f.IsDir() || !strings.HasPrefix(name, "map") || !strings.HasSuffix(name, ".blp") {
+				continue
+			}
+
+			toks := strings.Split(strings.TrimSuffix(name[len("map"):], ".blp"), "_")
+			if len(toks) != 2 {
+				continue
+			}
+			x, errX := strconv.Atoi(toks[0])
+			y, errY := strconv.Atoi(toks[1])
+			if errX != nil || errY != nil {
+				continue
+			}
+
+			result[m.Name()] = append(result[m.Name()], TileRef{
+				X:    x,
+				Y:    y,
+				Path: path.Join(mapDir, f.Name()),
+			})
+		}
+	}
+
+	return result, nil
+}
+
 /* =======================
    Core parser
    ======================= */
@@ -101,6 +151,7 @@ func parseMD5Translate(data []byte) (map[string][]TileRef, error) {
 			X:    x,
 			Y:    y,
 			Hash: fields[1],
+			Path: "textures/minimap/" + strings.ReplaceAll(fields[1], "\\", "/"),
 		})
 	}
 
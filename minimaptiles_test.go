@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseMD5Translate(t *testing.T) {
+	trs := []byte("dir: Azeroth\n" +
+		"Azeroth\\map30_31.blp\t0123456789abcdef0123456789abcdef.blp\n" +
+		"Azeroth\\map30_32.blp\tsub\\fedcba9876543210fedcba9876543210.blp\n" +
+		"dir: Empty\n")
+
+	maps, err := ParseMD5TranslateFromBytes(trs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := maps["Empty"]; ok {
+		t.Error("map without tiles kept")
+	}
+
+	// Tile paths are fs.FS paths, whatever separators the file uses
+	fsys := fstest.MapFS{
+		"textures/minimap/0123456789abcdef0123456789abcdef.blp":     {},
+		"textures/minimap/sub/fedcba9876543210fedcba9876543210.blp": {},
+	}
+	tiles := maps["Azeroth"]
+	if len(tiles) != 2 || tiles[0].X != 30 || tiles[0].Y != 31 || tiles[1].Y != 32 {
+		t.Fatalf("Azeroth tiles = %+v", tiles)
+	}
+	for _, tile := range tiles {
+		if _, err := fsys.Open(tile.Path); err != nil {
+			t.Errorf("tile %d,%d: %v", tile.X, tile.Y, err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package mpq
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+/* =========================
+   IMA ADPCM (WAVE sectors)
+   ========================= */
+
+const adpcmInitialStepIndex = 0x2C
+
+var adpcmNextStep = [32]int{
+	-1, 0, -1, 4, -1, 2, -1, 6,
+	-1, 1, -1, 5, -1, 3, -1, 7,
+	-1, 1, -1, 5, -1, 3, -1, 7,
+	-1, 2, -1, 4, -1, 6, -1, 8,
+}
+
+var adpcmStepSize = [89]int{
+	7, 8, 9, 10, 11, 12, 13, 14,
+	16, 17, 19, 21, 23, 25, 28, 31,
+	34, 37, 41, 45, 50, 55, 60, 66,
+	73, 80, 88, 97, 107, 118, 130, 143,
+	157, 173, 190, 209, 230, 253, 279, 307,
+	337, 371, 408, 449, 494, 544, 598, 658,
+	724, 796, 876, 963, 1060, 1166, 1282, 1411,
+	1552, 1707, 1878, 2066, 2272, 2499, 2749, 3024,
+	3327, 3660, 4026, 4428, 4871, 5358, 5894, 6484,
+	7132, 7845, 8630, 9493, 10442, 11487, 12635, 13899,
+	15289, 16818, 18500, 20350, 22385, 24623, 27086, 29794,
+	32767,
+}
+
+func decompressADPCMMono(data []byte, expected uint32) ([]byte, error) {
+	return decompressADPCM(data, expected, 1)
+}
+
+func decompressADPCMStereo(data []byte, expected uint32) ([]byte, error) {
+	return decompressADPCM(data, expected, 2)
+}
+
+// decompressADPCM expands Storm's ADPCM stream into 16-bit PCM samples.
+// The stream starts with a zero byte, the bit shift and one initial
+// sample per channel; channels are interleaved sample by sample.
+func decompressADPCM(data []byte, expected uint32, channels int) ([]byte, error) {
+	if len(data) < 2+2*channels {
+		return nil, errors.New("adpcm: truncated header")
+	}
+
+	shift := uint(data[1])
+	data = data[2:]
+
+	out := outputBuffer(expected)
+	put := func(s int) bool {
+		if uint32(len(out))+2 > expected {
+			return false
+		}
+		out = binary.LittleEndian.AppendUint16(out, uint16(int16(s)))
+		return true
+	}
+
+	var predicted [2]int
+	stepIndex := [2]int{adpcmInitialStepIndex, adpcmInitialStepIndex}
+
+	for ch := 0; ch < channels; ch++ {
+		predicted[ch] = int(int16(binary.LittleEndian.Uint16(data)))
+		data = data[2:]
+		if !put(predicted[ch]) {
+			return out, nil
+		}
+	}
+
+	ch := channels - 1
+	for _, enc := range data {
+		ch = (ch + 1) % channels
+
+		// Bytes with the top bit set are commands
+		if enc&0x80 != 0 {
+			switch enc {
+			case 0x80:
+				if stepIndex[ch] != 0 {
+					stepIndex[ch]--
+				}
+				if !put(predicted[ch]) {
+					return out, nil
+				}
+				continue
+			case 0x81:
+				stepIndex[ch] = min(stepIndex[ch]+8, len(adpcmStepSize)-1)
+			case 0x82: // only moves on to the next channel
+			default:
+				stepIndex[ch] = max(stepIndex[ch]-8, 0)
+			}
+			// The other commands don't consume the channel's sample slot
+			ch = (ch + 1) % channels
+			continue
+		}
+
+		step := adpcmStepSize[stepIndex[ch]]
+		predicted[ch] = adpcmDecodeSample(predicted[ch], enc, step, step>>shift)
+		if !put(predicted[ch]) {
+			return out, nil
+		}
+
+		idx := stepIndex[ch] + adpcmNextStep[enc&0x1F]
+		stepIndex[ch] = max(0, min(idx, len(adpcmStepSize)-1))
+	}
+
+	return out, nil
+}
+
+func adpcmDecodeSample(predicted int, enc byte, step, diff int) int {
+	for bit := uint(0); bit < 6; bit++ {
+		if enc&(1<<bit) != 0 {
+			diff += step >> bit
+		}
+	}
+
+	if enc&0x40 != 0 {
+		return max(predicted-diff, -32768)
+	}
+	return min(predicted+diff, 32767)
+}
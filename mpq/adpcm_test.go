@@ -0,0 +1,102 @@
+package mpq
+
+import (
+	"encoding/binary"
+	"slices"
+	"testing"
+)
+
+// The expected samples were worked through by hand from Storm's decoder:
+// a zero byte and the bit shift, one initial sample per channel, then
+// one code or command byte per sample.
+func TestDecompressADPCM(t *testing.T) {
+	tests := []struct {
+		name     string
+		channels int
+		in       []byte
+		want     []int16
+	}{
+		{
+			name:     "mono",
+			channels: 1,
+			in:       []byte{0x00, 0x02, 0x10, 0x00, 0x01, 0x43, 0x80, 0x81, 0x07, 0x85, 0x3F, 0x7F, 0x00},
+			want:     []int16{16, 633, -231, -231, 2589, 5173, -369, 970},
+		},
+		{
+			name:     "mono clamped",
+			channels: 1,
+			in:       []byte{0x00, 0x03, 0x00, 0x7D, 0x3F, 0x3F, 0x7F, 0x7F, 0x7F, 0x7F, 0x7F},
+			want:     []int16{32000, 32767, 32767, 28010, 17815, -4046, -32768, -32768},
+		},
+		{
+			name:     "stereo",
+			channels: 2,
+			in: []byte{
+				0x00, 0x01, 0xE8, 0x03, 0x18, 0xFC,
+				0x02, 0x42, 0x81, 0x05, 0x82, 0x44, 0x83, 0x01, 0x80, 0x20,
+			},
+			want: []int16{1000, -1000, 1494, -1494, 3178, -1830, 3994, -1830, 4283},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var want []byte
+			for _, s := range tt.want {
+				want = binary.LittleEndian.AppendUint16(want, uint16(s))
+			}
+
+			got, err := decompressADPCM(tt.in, uint32(len(want)), tt.channels)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("got %v, want %v", samples(got), tt.want)
+			}
+
+			// Output stops at the expected size
+			got, err = decompressADPCM(tt.in, 6, tt.channels)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, want[:6]) {
+				t.Errorf("truncated: got %v, want %v", samples(got), tt.want[:3])
+			}
+		})
+	}
+}
+
+func TestDecompressADPCMTruncatedHeader(t *testing.T) {
+	if _, err := decompressADPCMStereo([]byte{0x00, 0x01, 0xE8, 0x03}, 8); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+// The sectors hold 16-bit PCM compressed by StormLib, alone and followed
+// by Huffman coding the way Storm compresses WAVE files.
+func TestDecompressADPCMStormSectors(t *testing.T) {
+	for _, name := range []string{
+		"adpcm-mono",           // 0x40
+		"adpcm-stereo",         // 0x80
+		"adpcm-mono-huffman",   // 0x41
+		"adpcm-stereo-huffman", // 0x81
+	} {
+		sector, want := stormSector(t, name)
+		got, err := decompress(sector, uint32(len(want)))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: output differs from StormLib's", name)
+		}
+	}
+}
+
+func samples(b []byte) []int16 {
+	out := make([]int16, len(b)/2)
+	for i := range out {
+		out[i] = int16(binary.LittleEndian.Uint16(b[i*2:]))
+	}
+	return out
+}
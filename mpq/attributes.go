@@ -0,0 +1,228 @@
+package mpq
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"time"
+)
+
+/* =========================
+   (attributes)
+   ========================= */
+
+const (
+	MPQ_ATTRIBUTE_CRC32    = 0x00000001
+	MPQ_ATTRIBUTE_FILETIME = 0x00000002
+	MPQ_ATTRIBUTE_MD5      = 0x00000004
+	MPQ_ATTRIBUTE_PATCH    = 0x00000008
+)
+
+const attributesVersion = 100
+
+// fileTimeEpoch is the Unix epoch in FILETIME ticks.
+const fileTimeEpoch = 116444736000000000
+
+// FileAttributes is the "(attributes)" record of one block. Flags tells
+// which of the fields were stored.
+type FileAttributes struct {
+	Flags    uint32
+	CRC32    uint32
+	FileTime uint64 // Windows FILETIME, 100ns ticks since 1601
+	MD5      [16]byte
+	Patch    bool
+}
+
+// ModTime converts FileTime to a time.Time. It is the zero time when no
+// file time was stored.
+func (fa FileAttributes) ModTime() time.Time {
+	if fa.Flags&MPQ_ATTRIBUTE_FILETIME == 0 || fa.FileTime == 0 {
+		return time.Time{}
+	}
+	ticks := int64(fa.FileTime - fileTimeEpoch)
+	return time.Unix(ticks/1e7, ticks%1e7*100).UTC()
+}
+
+// Attributes returns the parsed "(attributes)" file, one record per
+// block table entry.
+func (m *MPQ) Attributes() ([]FileAttributes, error) {
+	m.attrsMu.Lock()
+	defer m.attrsMu.Unlock()
+
+	if m.attrs != nil || m.attrsErr != nil {
+		return m.attrs, m.attrsErr
+	}
+
+	data, err := m.ReadFile("(attributes)")
+	if err != nil {
+		m.attrsErr = err
+		return nil, err
+	}
+	m.attrs, m.attrsErr = parseAttributes(data, len(m.blockTable))
+	return m.attrs, m.attrsErr
+}
+
+// parseAttributes decodes the version/flags header and the per-block
+// arrays that follow it. Some tools write fewer records than blocks; the
+// missing records are left empty.
+func parseAttributes(data []byte, count int) ([]FileAttributes, error) {
+	if len(data) < 8 {
+		return nil, corrupt("attributes: truncated header")
+	}
+	version := binary.LittleEndian.Uint32(data)
+	flags := binary.LittleEndian.Uint32(data[4:])
+	if version != attributesVersion {
+		return nil, fmt.Errorf("%w: attributes version %d", ErrUnsupported, version)
+	}
+	data = data[8:]
+
+	out := make([]FileAttributes, count)
+	for i := range out {
+		out[i].Flags = flags
+	}
+
+	// Each array is truncated to the records actually present
+	take := func(elemSize int) []byte {
+		n := min(count*elemSize, len(data)/elemSize*elemSize)
+		b := data[:n]
+		data = data[n:]
+		return b
+	}
+
+	if flags&MPQ_ATTRIBUTE_CRC32 != 0 {
+		b := take(4)
+		for i := 0; i*4 < len(b); i++ {
+			out[i].CRC32 = binary.LittleEndian.Uint32(b[i*4:])
+		}
+	}
+	if flags&MPQ_ATTRIBUTE_FILETIME != 0 {
+		b := take(8)
+		for i := 0; i*8 < len(b); i++ {
+			out[i].FileTime = binary.LittleEndian.Uint64(b[i*8:])
+		}
+	}
+	if flags&MPQ_ATTRIBUTE_MD5 != 0 {
+		b := take(16)
+		for i := 0; i*16 < len(b); i++ {
+			copy(out[i].MD5[:], b[i*16:])
+		}
+	}
+	if flags&MPQ_ATTRIBUTE_PATCH != 0 {
+		b := data[:min((count+7)/8, len(data))]
+		for i := range out {
+			if i/8 < len(b) {
+				out[i].Patch = b[i/8]&(1<<(i%8)) != 0
+			}
+		}
+	}
+
+	return out, nil
+}
+
+/* =========================
+   Verification
+   ========================= */
+
+// VerifyStatus is the outcome of checking a file against the CRC32 and
+// MD5 stored for it in "(attributes)".
+type VerifyStatus int
+
+const (
+	VerifyOK          VerifyStatus = iota // every stored checksum matches
+	VerifyNotFound                        // no such file in the archive
+	VerifyNoChecksum                      // nothing stored to compare with
+	VerifyReadFailed                      // the file or "(attributes)" could not be read
+	VerifyCRCMismatch                     // the CRC32 differs
+	VerifyMD5Mismatch                     // the MD5 differs
+)
+
+func (s VerifyStatus) String() string {
+	switch s {
+	case VerifyOK:
+		return "ok"
+	case VerifyNotFound:
+		return "not found"
+	case VerifyNoChecksum:
+		return "no checksum"
+	case VerifyReadFailed:
+		return "read failed"
+	case VerifyCRCMismatch:
+		return "CRC32 mismatch"
+	case VerifyMD5Mismatch:
+		return "MD5 mismatch"
+	}
+	return fmt.Sprintf("VerifyStatus(%d)", int(s))
+}
+
+// VerifyResult is the outcome of checking one file against its stored
+// checksums. Err is set when Status is VerifyReadFailed, which also
+// covers an unreadable "(attributes)".
+type VerifyResult struct {
+	Name   string
+	Locale uint16
+	Status VerifyStatus
+	Err    error
+}
+
+// Verify decompresses name and compares it with the CRC32 and MD5 stored
+// in "(attributes)". Zero checksums are treated as not stored.
+func (m *MPQ) Verify(name string) VerifyResult {
+	h, ok := m.lookup(name)
+	if !ok {
+		return VerifyResult{Name: name, Status: VerifyNotFound}
+	}
+	return m.verifyBlock(name, h.Locale, h.BlockIdx)
+}
+
+// VerifyAll verifies every file named by the archive's listfiles. Locale
+// variants share a name but not a block, so each gets its own result.
+func (m *MPQ) VerifyAll() []VerifyResult {
+	var out []VerifyResult
+	for _, fi := range m.List() {
+		out = append(out, m.verifyBlock(fi.Name, fi.Locale, fi.BlockIdx))
+	}
+	return out
+}
+
+func (m *MPQ) verifyBlock(name string, locale uint16, blockIdx uint32) VerifyResult {
+	res := VerifyResult{Name: name, Locale: locale}
+
+	// A missing "(attributes)" just means nothing was stored
+	attrs, err := m.Attributes()
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		res.Status = VerifyReadFailed
+		res.Err = err
+		return res
+	}
+	if int(blockIdx) >= len(attrs) {
+		res.Status = VerifyNoChecksum
+		return res
+	}
+	a := attrs[blockIdx]
+
+	checkCRC := a.Flags&MPQ_ATTRIBUTE_CRC32 != 0 && a.CRC32 != 0
+	checkMD5 := a.Flags&MPQ_ATTRIBUTE_MD5 != 0 && !isZeroMD5(a.MD5)
+	if !checkCRC && !checkMD5 {
+		res.Status = VerifyNoChecksum
+		return res
+	}
+
+	data, err := m.readBlock(name, blockIdx)
+	if err != nil {
+		res.Status = VerifyReadFailed
+		res.Err = err
+		return res
+	}
+
+	switch {
+	case checkCRC && crc32.ChecksumIEEE(data) != a.CRC32:
+		res.Status = VerifyCRCMismatch
+	case checkMD5 && md5.Sum(data) != a.MD5:
+		res.Status = VerifyMD5Mismatch
+	default:
+		res.Status = VerifyOK
+	}
+	return res
+}
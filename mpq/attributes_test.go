@@ -0,0 +1,115 @@
+package mpq
+
+import (
+	"crypto/md5"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAttributes(t *testing.T) {
+	m := openStorm(t, "storm.mpq")
+	attrs, err := m.Attributes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attrs) != len(m.blockTable) {
+		t.Fatalf("%d records for %d blocks", len(attrs), len(m.blockTable))
+	}
+
+	h, ok := m.lookup("zlib.txt")
+	if !ok {
+		t.Fatal("no zlib.txt")
+	}
+	a := attrs[h.BlockIdx]
+	want := stormText("zlib.txt", 500)
+	if a.CRC32 != crc32.ChecksumIEEE(want) {
+		t.Errorf("CRC32 %08x", a.CRC32)
+	}
+	if a.MD5 != md5.Sum(want) {
+		t.Errorf("MD5 %x", a.MD5)
+	}
+	// gen.cpp stamps every file with FILETIME 0x01D0000000000000
+	if got, want := a.ModTime(), time.Date(2014, 11, 14, 11, 41, 59, 374438400, time.UTC); !got.Equal(want) {
+		t.Errorf("ModTime %v, want %v", got, want)
+	}
+}
+
+func TestVerifyAll(t *testing.T) {
+	m := openStorm(t, "storm.mpq")
+
+	locales := map[uint16]bool{}
+	for _, res := range m.VerifyAll() {
+		// StormLib stores no checksums for empty files
+		if res.Name == "empty.txt" && res.Status == VerifyNoChecksum {
+			continue
+		}
+		if res.Status != VerifyOK {
+			t.Errorf("%s (%#x): %v (%v)", res.Name, res.Locale, res.Status, res.Err)
+		}
+		if res.Name == "locale.txt" {
+			locales[res.Locale] = true
+		}
+	}
+	if len(locales) != 3 || !locales[0] || !locales[0x407] || !locales[0x40C] {
+		t.Errorf("locale.txt verified for locales %v, want 0, 0x407 and 0x40c", locales)
+	}
+}
+
+// corruptStorm copies testdata/name to a temporary file after letting
+// edit change it.
+func corruptStorm(t *testing.T, name string, edit func(m *MPQ, raw []byte)) *MPQ {
+	t.Helper()
+	raw, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit(openStorm(t, name), raw)
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func TestVerify(t *testing.T) {
+	m := openStorm(t, "storm.mpq")
+	if res := m.Verify("stored.txt"); res.Status != VerifyOK {
+		t.Fatalf("intact archive: got %v (%v)", res.Status, res.Err)
+	}
+	if res := m.Verify("missing.txt"); res.Status != VerifyNotFound {
+		t.Errorf("missing file: got %v", res.Status)
+	}
+
+	// Flip a byte of the uncompressed file
+	bad := corruptStorm(t, "storm.mpq", func(m *MPQ, raw []byte) {
+		h, _ := m.lookup("stored.txt")
+		raw[m.blockOffset(h.BlockIdx)+100] ^= 0x01
+	})
+	if res := bad.Verify("stored.txt"); res.Status != VerifyCRCMismatch {
+		t.Errorf("modified file: got %v (%v), want %v", res.Status, res.Err, VerifyCRCMismatch)
+	}
+
+	// Overwrite the start of "(attributes)"
+	bad = corruptStorm(t, "storm.mpq", func(m *MPQ, raw []byte) {
+		h, ok := m.lookup("(attributes)")
+		if !ok {
+			t.Fatal("no (attributes)")
+		}
+		off := m.blockOffset(h.BlockIdx)
+		for i := range 12 {
+			raw[off+int64(i)] = 0xFF
+		}
+	})
+	if res := bad.Verify("stored.txt"); res.Status != VerifyReadFailed || res.Err == nil {
+		t.Errorf("corrupt (attributes): got %v (%v), want %v", res.Status, res.Err, VerifyReadFailed)
+	}
+}
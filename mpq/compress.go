@@ -0,0 +1,140 @@
+package mpq
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/* =========================
+   Compression dispatch
+   ========================= */
+
+// maxPrealloc caps the output buffer allocated up front from a size
+// field; larger outputs grow as they are decoded.
+const maxPrealloc = 16 << 20
+
+func outputBuffer(expected uint32) []byte {
+	return make([]byte, 0, min(expected, maxPrealloc))
+}
+
+type decompressor struct {
+	mask byte
+	fn   func(data []byte, expected uint32) ([]byte, error)
+}
+
+// decompressChain lists the decompressors in the order Storm applies
+// them when several mask bits are set. Compression ran in reverse, so
+// sparse (applied first when compressing) is undone last.
+var decompressChain = []decompressor{
+	{MPQ_COMPRESSION_BZIP2, decompressBzip2},
+	{MPQ_COMPRESSION_PKWARE, explode},
+	{MPQ_COMPRESSION_ZLIB, decompressZlib},
+	{MPQ_COMPRESSION_HUFFMAN, decompressHuffman},
+	{MPQ_COMPRESSION_ADPCM_STEREO, decompressADPCMStereo},
+	{MPQ_COMPRESSION_ADPCM_MONO, decompressADPCMMono},
+	{MPQ_COMPRESSION_SPARSE, decompressSparse},
+}
+
+// decompress undoes the compressions named by the leading mask byte.
+func decompress(data []byte, expected uint32) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, corrupt("empty compressed data")
+	}
+
+	mask := data[0]
+	data = data[1:]
+
+	// LZMA shares bits with bzip2/zlib and is never combined
+	if mask == MPQ_COMPRESSION_LZMA {
+		out, err := decompressLZMA(data, expected)
+		return out, asCorrupt(err)
+	}
+
+	remaining := mask
+	for _, d := range decompressChain {
+		remaining &^= d.mask
+	}
+	if mask == 0 || remaining != 0 {
+		return nil, fmt.Errorf("%w: compression 0x%02X", ErrUnsupported, mask)
+	}
+
+	for _, d := range decompressChain {
+		if mask&d.mask == 0 {
+			continue
+		}
+		out, err := d.fn(data, expected)
+		if err != nil {
+			return nil, asCorrupt(err)
+		}
+		data = out
+	}
+
+	return data, nil
+}
+
+// asCorrupt marks a decoder error as ErrCorrupt unless it already
+// carries one of the package's error kinds.
+func asCorrupt(err error) error {
+	if err == nil || errors.Is(err, ErrCorrupt) || errors.Is(err, ErrUnsupported) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrCorrupt, err)
+}
+
+/* =========================
+   Decompressors
+   ========================= */
+
+func decompressZlib(data []byte, expected uint32) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(io.LimitReader(r, int64(expected)))
+}
+
+func decompressBzip2(data []byte, expected uint32) ([]byte, error) {
+	r := bzip2.NewReader(bytes.NewReader(data))
+	return io.ReadAll(io.LimitReader(r, int64(expected)))
+}
+
+// decompressSparse expands Storm's zero run-length encoding: a big-endian
+// output size followed by chunks. A set high bit marks (n&0x7F)+1 literal
+// bytes, otherwise (n&0x7F)+3 zero bytes.
+func decompressSparse(data []byte, expected uint32) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, errors.New("sparse: truncated header")
+	}
+
+	size := uint32(data[0])<<24 | uint32(data[1])<<16 | uint32(data[2])<<8 | uint32(data[3])
+	if size > expected {
+		return nil, fmt.Errorf("sparse: size %d exceeds expected %d", size, expected)
+	}
+	data = data[4:]
+
+	out := outputBuffer(size)
+	for len(data) > 0 && uint32(len(out)) < size {
+		n := data[0]
+		data = data[1:]
+
+		left := int(size) - len(out)
+		if n&0x80 != 0 {
+			chunk := min(int(n&0x7F)+1, left)
+			if chunk > len(data) {
+				return nil, errors.New("sparse: truncated data chunk")
+			}
+			out = append(out, data[:chunk]...)
+			data = data[chunk:]
+		} else {
+			chunk := min(int(n&0x7F)+3, left)
+			out = append(out, make([]byte, chunk)...)
+		}
+	}
+
+	return out, nil
+}
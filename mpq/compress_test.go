@@ -0,0 +1,105 @@
+package mpq
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDecompressStormSectors(t *testing.T) {
+	for _, name := range []string{
+		"bzip2",        // 0x10
+		"lzma",         // 0x12
+		"sparse",       // 0x20
+		"sparse-zlib",  // 0x22
+		"sparse-bzip2", // 0x30
+		"zlib-pkware",  // 0x0A
+	} {
+		sector, want := stormSector(t, name)
+		got, err := decompress(sector, uint32(len(want)))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: output differs from StormLib's", name)
+		}
+	}
+}
+
+// The testdata/lzma streams were written by xz-utils (xz --format=lzma),
+// so they end in an end marker and carry an unknown size. Storm puts a
+// filter byte in front of the same layout.
+func TestDecompressLZMAAlone(t *testing.T) {
+	for _, name := range []string{"text", "binary", "runs"} {
+		stream, err := os.ReadFile(filepath.Join("testdata", "lzma", name+".lzma"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := os.ReadFile(filepath.Join("testdata", "lzma", name+".out"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sector := append([]byte{MPQ_COMPRESSION_LZMA, 0}, stream...)
+		got, err := decompress(sector, uint32(len(want)))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: output differs", name)
+		}
+	}
+}
+
+func TestDecompressSparse(t *testing.T) {
+	in := []byte{
+		0x00, 0x00, 0x00, 0x0A, // output size
+		0x81, 'a', 'b', // 2 literals
+		0x02,                // 5 zeros
+		0x82, 'c', 'd', 'e', // 3 literals
+	}
+	got, err := decompressSparse(in, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte("ab\x00\x00\x00\x00\x00cde"); !bytes.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	if _, err := decompressSparse(in, 9); err == nil {
+		t.Error("size above expected: no error")
+	}
+	if _, err := decompressSparse(in[:9], 10); err == nil {
+		t.Error("truncated literal run: no error")
+	}
+}
+
+func TestDecompressMasks(t *testing.T) {
+	for _, sector := range [][]byte{
+		nil,
+		{0x00, 'x'},
+		{0x04, 'x'},        // unknown bit
+		{0x12 | 0x02, 'x'}, // LZMA with other bits is not LZMA
+	} {
+		if _, err := decompress(sector, 1); err == nil {
+			t.Errorf("% X: no error", sector)
+		}
+	}
+}
+
+func TestReadStormCompressed(t *testing.T) {
+	m := openStorm(t, "storm.mpq")
+	for _, name := range []string{"bzip2.txt", "lzma.txt", "zlib.txt"} {
+		got, err := m.ReadFile(name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(got, stormText(name, 500)) {
+			t.Errorf("%s: content differs", name)
+		}
+	}
+}
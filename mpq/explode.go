@@ -0,0 +1,202 @@
+package mpq
+
+import (
+	"errors"
+	"fmt"
+)
+
+/* =========================
+   PKWARE DCL explode
+   ========================= */
+
+// The PKWARE Data Compression Library "implode" format stores a literal
+// mode byte, a dictionary size byte and then an LSB-first bit stream of
+// literals and (length, distance) pairs. Huffman tables are fixed and
+// given below in run-length form: each byte is (count-1)<<4 | bitlength.
+
+const explodeMaxBits = 13
+
+var (
+	explodeLitLen = []byte{
+		11, 124, 8, 7, 28, 7, 188, 13, 76, 4, 10, 8, 12, 10, 12, 10, 8, 23, 8,
+		9, 7, 6, 7, 8, 7, 6, 55, 8, 23, 24, 12, 11, 7, 9, 11, 12, 6, 7, 22, 5,
+		7, 24, 6, 11, 9, 6, 7, 22, 7, 11, 38, 7, 9, 8, 25, 11, 8, 11, 9, 12,
+		8, 12, 5, 38, 5, 38, 5, 11, 7, 5, 6, 21, 6, 10, 53, 8, 7, 24, 10, 27,
+		44, 253, 253, 253, 252, 252, 252, 13, 12, 45, 12, 45, 12, 61, 12, 45,
+		44, 173,
+	}
+	explodeLenLen  = []byte{2, 35, 36, 53, 38, 23}
+	explodeDistLen = []byte{2, 20, 53, 230, 247, 151, 248}
+
+	explodeLenBase  = [16]int{3, 2, 4, 5, 6, 7, 8, 9, 10, 12, 16, 24, 40, 72, 136, 264}
+	explodeLenExtra = [16]uint{0, 0, 0, 0, 0, 0, 0, 0, 1, 2, 3, 4, 5, 6, 7, 8}
+)
+
+var (
+	explodeLitCode  = newExplodeHuffman(explodeLitLen)
+	explodeLenCode  = newExplodeHuffman(explodeLenLen)
+	explodeDistCode = newExplodeHuffman(explodeDistLen)
+)
+
+var errExplodeInput = errors.New("pkware: unexpected end of input")
+
+type explodeHuffman struct {
+	count  [explodeMaxBits + 1]int
+	symbol []int
+}
+
+// newExplodeHuffman expands a run-length encoded code length table into
+// canonical decoding tables.
+func newExplodeHuffman(rep []byte) *explodeHuffman {
+	var lengths []int
+	for _, b := range rep {
+		n := int(b>>4) + 1
+		for ; n > 0; n-- {
+			lengths = append(lengths, int(b&15))
+		}
+	}
+
+	h := &explodeHuffman{symbol: make([]int, len(lengths))}
+	for _, l := range lengths {
+		h.count[l]++
+	}
+
+	var offs [explodeMaxBits + 1]int
+	for l := 1; l < explodeMaxBits; l++ {
+		offs[l+1] = offs[l] + h.count[l]
+	}
+	for sym, l := range lengths {
+		if l != 0 {
+			h.symbol[offs[l]] = sym
+			offs[l]++
+		}
+	}
+	return h
+}
+
+type explodeState struct {
+	in     []byte
+	pos    int
+	bitBuf uint32
+	bitCnt uint
+}
+
+func (s *explodeState) bits(need uint) (int, error) {
+	for s.bitCnt < need {
+		if s.pos >= len(s.in) {
+			return 0, errExplodeInput
+		}
+		s.bitBuf |= uint32(s.in[s.pos]) << s.bitCnt
+		s.pos++
+		s.bitCnt += 8
+	}
+	v := s.bitBuf & (1<<need - 1)
+	s.bitBuf >>= need
+	s.bitCnt -= need
+	return int(v), nil
+}
+
+// decode reads one symbol. PKWARE stores codes bit-inverted.
+func (s *explodeState) decode(h *explodeHuffman) (int, error) {
+	code, first, index := 0, 0, 0
+	for l := 1; l <= explodeMaxBits; l++ {
+		b, err := s.bits(1)
+		if err != nil {
+			return 0, err
+		}
+		code |= b ^ 1
+		count := h.count[l]
+		if code < first+count {
+			return h.symbol[index+code-first], nil
+		}
+		index += count
+		first += count
+		first <<= 1
+		code <<= 1
+	}
+	return 0, errors.New("pkware: invalid code")
+}
+
+// explode decompresses a PKWARE DCL stream. Output stops at the end
+// marker or once expected bytes have been produced.
+func explode(data []byte, expected uint32) ([]byte, error) {
+	s := &explodeState{in: data}
+
+	lit, err := s.bits(8)
+	if err != nil {
+		return nil, err
+	}
+	if lit > 1 {
+		return nil, fmt.Errorf("pkware: bad literal mode %d", lit)
+	}
+
+	dict, err := s.bits(8)
+	if err != nil {
+		return nil, err
+	}
+	if dict < 4 || dict > 6 {
+		return nil, fmt.Errorf("pkware: bad dictionary size %d", dict)
+	}
+
+	out := outputBuffer(expected)
+
+	for uint32(len(out)) < expected {
+		flag, err := s.bits(1)
+		if err != nil {
+			return nil, err
+		}
+
+		if flag == 0 {
+			var c int
+			if lit != 0 {
+				c, err = s.decode(explodeLitCode)
+			} else {
+				c, err = s.bits(8)
+			}
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, byte(c))
+			continue
+		}
+
+		sym, err := s.decode(explodeLenCode)
+		if err != nil {
+			return nil, err
+		}
+		extra, err := s.bits(explodeLenExtra[sym])
+		if err != nil {
+			return nil, err
+		}
+		length := explodeLenBase[sym] + extra
+		if length == 519 {
+			break // end of stream
+		}
+
+		shift := uint(dict)
+		if length == 2 {
+			shift = 2
+		}
+		dist, err := s.decode(explodeDistCode)
+		if err != nil {
+			return nil, err
+		}
+		low, err := s.bits(shift)
+		if err != nil {
+			return nil, err
+		}
+		dist = dist<<shift + low + 1
+
+		if dist > len(out) {
+			return nil, errors.New("pkware: distance too far back")
+		}
+
+		// Byte-wise copy: source and destination may overlap
+		from := len(out) - dist
+		for i := 0; i < length && uint32(len(out)) < expected; i++ {
+			out = append(out, out[from+i])
+		}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,100 @@
+package mpq
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// stormText is the content testdata/gen.cpp stores under name.
+func stormText(name string, lines int) []byte {
+	var b strings.Builder
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&b, "%s line %04d\n", name, i)
+	}
+	return []byte(b.String())
+}
+
+// stormSector loads a StormLib-compressed sector and its expected output.
+func stormSector(t testing.TB, name string) (sector, want []byte) {
+	t.Helper()
+	sector, err := os.ReadFile(filepath.Join("testdata", "sectors", name+".bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err = os.ReadFile(filepath.Join("testdata", "sectors", name+".out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sector, want
+}
+
+func openStorm(t testing.TB, name string) *MPQ {
+	t.Helper()
+	m, err := Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func TestExplodeBlastVector(t *testing.T) {
+	// The example stream from zlib's contrib/blast/blast.c.
+	in := []byte{0x00, 0x04, 0x82, 0x24, 0x25, 0x8F, 0x80, 0x7F}
+	got, err := explode(in, 13)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "AIAIAIAIAIAIA" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestExplodeTruncated(t *testing.T) {
+	// Ask for more than the stream holds so only the end marker stops it.
+	in := []byte{0x00, 0x04, 0x82, 0x24, 0x25, 0x8F, 0x80, 0x7F}
+	if got, err := explode(in, 100); err != nil || string(got) != "AIAIAIAIAIAIA" {
+		t.Fatalf("full stream: %q, %v", got, err)
+	}
+	for n := 0; n < len(in); n++ {
+		if _, err := explode(in[:n], 100); err == nil {
+			t.Errorf("%d bytes: no error", n)
+		}
+	}
+}
+
+func TestExplodeStormSector(t *testing.T) {
+	sector, want := stormSector(t, "pkware")
+	got, err := decompress(sector, uint32(len(want)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("pkware sector differs from StormLib's output")
+	}
+}
+
+func TestExplodeStormFiles(t *testing.T) {
+	m := openStorm(t, "storm.mpq")
+	for _, tc := range []struct {
+		name  string
+		lines int
+	}{
+		{"implode.txt", 500},
+		{"implode-single.txt", 100},
+		{"pkware.txt", 500},
+	} {
+		got, err := m.ReadFile(tc.name)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !bytes.Equal(got, stormText(tc.name, tc.lines)) {
+			t.Errorf("%s: content differs", tc.name)
+		}
+	}
+}
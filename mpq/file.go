@@ -0,0 +1,315 @@
+package mpq
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+/* =========================
+   Streaming file access
+   ========================= */
+
+// sectorCacheSize is how many decompressed sectors a File keeps.
+const sectorCacheSize = 4
+
+// File is a read-only handle to one archived file. Sectored files are
+// decompressed a sector at a time as they are read; single-unit files
+// are decompressed in full on first read. A File is safe for concurrent
+// use through ReadAt.
+type File struct {
+	m        *MPQ
+	name     string
+	blockIdx uint32
+	block    BlockEntry
+	offset   int64
+	sectSize uint32
+
+	// key is recovered from the sector table when the name is unknown
+	key      uint32
+	keyKnown bool
+
+	mu  sync.Mutex
+	pos int64
+
+	// Sector offset table and checksums, loaded on first read
+	offsets   []uint32
+	checksums []uint32
+	loaded    bool
+
+	cache []cachedSector
+	whole []byte
+}
+
+type cachedSector struct {
+	idx  uint32
+	data []byte
+}
+
+// Open returns a streaming handle to name, using the same locale choice
+// as ReadFile.
+func (m *MPQ) Open(name string) (*File, error) {
+	h, ok := m.lookup(name)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return m.openBlock(name, h.BlockIdx)
+}
+
+func (m *MPQ) openBlock(name string, blockIdx uint32) (*File, error) {
+	block, err := m.checkBlock(blockIdx)
+	if err != nil {
+		return nil, err
+	}
+	key, known := m.blockKey(name, blockIdx)
+	return &File{
+		m:        m,
+		name:     name,
+		blockIdx: blockIdx,
+		block:    block,
+		offset:   m.blockOffset(blockIdx),
+		sectSize: uint32(512) << m.header.SectorSizeShift,
+		key:      key,
+		keyKnown: known,
+	}, nil
+}
+
+// Name returns the name the file was opened with.
+func (f *File) Name() string {
+	return f.name
+}
+
+// Size returns the uncompressed size of the file.
+func (f *File) Size() int64 {
+	return int64(f.block.UncompressedSize)
+}
+
+// Close releases the sector cache. The archive stays open.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cache = nil
+	f.whole = nil
+	return nil
+}
+
+func (f *File) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n, err := f.readAt(p, f.pos)
+	f.pos += int64(n)
+	return n, err
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.Size()
+	default:
+		return 0, errors.New("mpq: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("mpq: negative position")
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n, err := f.readAt(p, off)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// readAt fills p from off, stopping at the end of the file. It returns
+// io.EOF only when off is already at or past the end.
+func (f *File) readAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("mpq: negative offset")
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if off >= f.Size() {
+		return 0, io.EOF
+	}
+	p = p[:min(int64(len(p)), f.Size()-off)]
+
+	if f.block.Flags&MPQ_FILE_SINGLE_UNIT != 0 {
+		if f.whole == nil {
+			data, err := f.m.readSingleUnit(f.name, f.blockIdx)
+			if err != nil {
+				return 0, err
+			}
+			f.whole = data
+		}
+		return copy(p, f.whole[off:]), nil
+	}
+
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		idx := uint32(pos / int64(f.sectSize))
+		data, err := f.sector(idx)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], data[pos%int64(f.sectSize):])
+	}
+	return n, nil
+}
+
+// sector returns decompressed sector idx, from the cache if possible.
+func (f *File) sector(idx uint32) ([]byte, error) {
+	for i, c := range f.cache {
+		if c.idx == idx {
+			// Move to the front so the oldest entry is evicted first
+			copy(f.cache[1:i+1], f.cache[:i])
+			f.cache[0] = c
+			return c.data, nil
+		}
+	}
+
+	data, err := f.readSector(idx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(f.cache) < sectorCacheSize {
+		f.cache = append(f.cache, cachedSector{})
+	}
+	copy(f.cache[1:], f.cache)
+	f.cache[0] = cachedSector{idx: idx, data: data}
+	return data, nil
+}
+
+// readSector reads, decrypts, checks and decompresses one sector.
+func (f *File) readSector(idx uint32) ([]byte, error) {
+	block := f.block
+
+	expected := min(f.sectSize, block.UncompressedSize-idx*f.sectSize)
+
+	// Uncompressed files have no offset table; sectors are contiguous
+	if block.Flags&MPQ_FILE_COMPRESS_MASK == 0 {
+		sector := make([]byte, expected)
+		if err := f.m.readAt(sector, f.offset+int64(idx)*int64(f.sectSize)); err != nil {
+			return nil, err
+		}
+		if block.Flags&MPQ_FILE_ENCRYPTED != 0 {
+			if !f.keyKnown {
+				return nil, errUnknownKey
+			}
+			mpqDecrypt(sector, f.key+idx)
+		}
+		return sector, nil
+	}
+
+	if !f.loaded {
+		if err := f.loadSectorTable(); err != nil {
+			return nil, err
+		}
+		f.loaded = true
+	}
+
+	start, end := f.offsets[idx], f.offsets[idx+1]
+	size := end - start
+
+	sector := make([]byte, size)
+	if err := f.m.readAt(sector, f.offset+int64(start)); err != nil {
+		return nil, err
+	}
+
+	if block.Flags&MPQ_FILE_ENCRYPTED != 0 {
+		mpqDecrypt(sector, f.key+idx)
+	}
+
+	// Both 0 and 0xFFFFFFFF stand for a checksum that was never stored
+	if idx < uint32(len(f.checksums)) && f.checksums[idx] != 0 && f.checksums[idx] != 0xFFFFFFFF {
+		if err := f.m.checkSector(f.name, idx, sector, f.checksums[idx]); err != nil {
+			return nil, err
+		}
+	}
+
+	if size == expected {
+		return sector, nil
+	}
+	return decompressUnit(sector, expected, block.Flags)
+}
+
+func (f *File) loadSectorTable() error {
+	sectorCount := (f.block.UncompressedSize + f.sectSize - 1) / f.sectSize
+
+	tableDWORDs := sectorCount + 1
+	if f.block.Flags&MPQ_FILE_SECTOR_CRC != 0 {
+		tableDWORDs++
+	}
+
+	table := make([]byte, tableDWORDs*4)
+	if uint32(len(table)) > f.block.CompressedSize {
+		return corrupt("%s: sector table larger than file", f.name)
+	}
+	if err := f.m.readAt(table, f.offset); err != nil {
+		return err
+	}
+
+	if f.block.Flags&MPQ_FILE_ENCRYPTED != 0 {
+		if !f.keyKnown {
+			key, ok := recoverSectorKey(table, f.block, f.sectSize)
+			if !ok {
+				return errUnknownKey
+			}
+			f.m.cacheKey(f.blockIdx, key)
+			f.key, f.keyKnown = key, true
+		}
+		mpqDecrypt(table, f.key-1)
+	}
+
+	f.offsets = make([]uint32, tableDWORDs)
+	for i := range f.offsets {
+		f.offsets[i] = binary.LittleEndian.Uint32(table[i*4:])
+	}
+
+	if !validSectorTable(f.offsets, f.block, f.sectSize) {
+		return corrupt("%s: bad sector table", f.name)
+	}
+
+	if f.block.Flags&MPQ_FILE_SECTOR_CRC != 0 && f.m.sectorCRCMode() != SectorCRCOff {
+		sums, err := f.m.readSectorChecksums(f.offset, f.offsets, sectorCount)
+		if err != nil {
+			return fmt.Errorf("%s: sector checksums: %w", f.name, err)
+		}
+		f.checksums = sums
+	}
+	return nil
+}
+
+// validSectorTable reports whether a sector offset table fits its block.
+// Offsets must rise within the file, and a stored sector is never larger
+// than the sector itself. The checksum entry, if any, is only bounded.
+func validSectorTable(offsets []uint32, block BlockEntry, sectSize uint32) bool {
+	sectors := (block.UncompressedSize + sectSize - 1) / sectSize
+	for i := 1; i < len(offsets); i++ {
+		prev, cur := offsets[i-1], offsets[i]
+		if cur < prev || cur > block.CompressedSize {
+			return false
+		}
+		if uint32(i) <= sectors && cur-prev > sectSize {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,124 @@
+package mpq
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+var streamFiles = []struct {
+	name  string
+	lines int
+}{
+	{"stored.txt", 500},
+	{"zlib.txt", 500},
+	{"crc.txt", 500},
+	{"encrypted.txt", 500},
+	{"fixkey.txt", 500},
+	{"single.txt", 500},
+	{"implode-single.txt", 100},
+	{"empty.txt", 0},
+}
+
+func TestFileRead(t *testing.T) {
+	m := openStorm(t, "storm.mpq")
+	for _, tc := range streamFiles {
+		f, err := m.Open(tc.name)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		want := stormText(tc.name, tc.lines)
+		if f.Size() != int64(len(want)) {
+			t.Errorf("%s: size %d, want %d", tc.name, f.Size(), len(want))
+		}
+		// Small reads cross every sector boundary
+		got, err := io.ReadAll(f)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		} else if !bytes.Equal(got, want) {
+			t.Errorf("%s: content differs", tc.name)
+		}
+		f.Close()
+	}
+	if _, err := m.Open("missing.txt"); err == nil {
+		t.Error("missing file: no error")
+	}
+}
+
+func TestFileReadAt(t *testing.T) {
+	m := openStorm(t, "storm.mpq")
+	for _, tc := range streamFiles {
+		want := stormText(tc.name, tc.lines)
+		f, err := m.Open(tc.name)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		// Spans within one sector, across a boundary and up to the end
+		for _, r := range [][2]int{{0, 10}, {4090, 4110}, {8000, 9000}, {len(want) - 7, len(want)}} {
+			if r[0] < 0 || r[1] > len(want) {
+				continue
+			}
+			p := make([]byte, r[1]-r[0])
+			n, err := f.ReadAt(p, int64(r[0]))
+			if err != nil || !bytes.Equal(p[:n], want[r[0]:r[1]]) {
+				t.Errorf("%s: ReadAt(%d, %d) = %q, %v", tc.name, r[0], r[1], p[:n], err)
+			}
+		}
+
+		// Reads past the end are short and report io.EOF
+		p := make([]byte, 16)
+		n, err := f.ReadAt(p, int64(len(want)-4))
+		if len(want) >= 4 && (n != 4 || err != io.EOF) {
+			t.Errorf("%s: ReadAt at end = %d, %v", tc.name, n, err)
+		}
+		if _, err := f.ReadAt(p, -1); err == nil {
+			t.Errorf("%s: negative offset: no error", tc.name)
+		}
+		f.Close()
+	}
+}
+
+func TestFileSeek(t *testing.T) {
+	m := openStorm(t, "storm.mpq")
+	f, err := m.Open("zlib.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	want := stormText("zlib.txt", 500)
+
+	for _, tc := range []struct {
+		offset int64
+		whence int
+		pos    int64
+	}{
+		{5000, io.SeekStart, 5000},
+		{-1000, io.SeekCurrent, 4000},
+		{-20, io.SeekEnd, int64(len(want)) - 20},
+	} {
+		pos, err := f.Seek(tc.offset, tc.whence)
+		if err != nil || pos != tc.pos {
+			t.Fatalf("Seek(%d, %d) = %d, %v; want %d", tc.offset, tc.whence, pos, err, tc.pos)
+		}
+		p := make([]byte, 10)
+		if _, err := io.ReadFull(f, p); err != nil || !bytes.Equal(p, want[pos:pos+10]) {
+			t.Errorf("read at %d = %q, %v", pos, p, err)
+		}
+		if _, err := f.Seek(pos, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := f.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("read at end = %d, %v", n, err)
+	}
+	if _, err := f.Seek(-1, io.SeekStart); err == nil {
+		t.Error("negative position: no error")
+	}
+	if _, err := f.Seek(0, 3); err == nil {
+		t.Error("bad whence: no error")
+	}
+}
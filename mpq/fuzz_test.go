@@ -0,0 +1,104 @@
+package mpq
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"testing"
+)
+
+// fuzzArchives seeds the archive targets with what Writer produces:
+// stored, compressed, encrypted and single-unit files, large enough to
+// span several sectors.
+func fuzzArchives(f *testing.F) {
+	big := bytes.Repeat([]byte("sector data "), 1000)
+
+	w := NewWriter()
+	w.Add("plain.txt", []byte("plain"), 0, 0)
+	w.Add("Dir\\zlib.txt", big, MPQ_FILE_COMPRESS, 0)
+	w.Add("Dir\\enUS.txt", []byte("locale"), 0, 0x409)
+	w.Add("secret.bin", big, MPQ_FILE_COMPRESS|MPQ_FILE_ENCRYPTED|MPQ_FILE_FIX_KEY, 0)
+	w.Add("single.bin", big, MPQ_FILE_COMPRESS|MPQ_FILE_SINGLE_UNIT, 0)
+	w.Add("empty.bin", nil, 0, 0)
+
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(buf.Bytes())
+
+	buf.Reset()
+	if _, err := NewWriter().WriteTo(&buf); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(buf.Bytes())
+}
+
+// FuzzOpen parses the header, tables, listfile and attributes.
+func FuzzOpen(f *testing.F) {
+	fuzzArchives(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m, err := OpenReaderAt(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return
+		}
+		m.List()
+		m.Attributes()
+		m.UserData()
+	})
+}
+
+// FuzzReadFile opens the archive and reads every file it can find, by
+// name and by block index.
+func FuzzReadFile(f *testing.F) {
+	fuzzArchives(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m, err := OpenReaderAt(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return
+		}
+		for _, fi := range m.List() {
+			m.ReadFile(fi.Name)
+			if f, err := m.Open(fi.Name); err == nil {
+				f.ReadAt(make([]byte, 64), f.Size()/2)
+			}
+		}
+		for _, idx := range m.UnnamedBlocks() {
+			m.ReadBlock(idx)
+		}
+		m.VerifyAll()
+		m.VerifySignature()
+	})
+}
+
+// FuzzDecompress feeds sectors to the decoders. They must fail with one
+// of the package's errors or return exactly the expected size.
+func FuzzDecompress(f *testing.F) {
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(bytes.Repeat([]byte("zlib "), 100))
+	zw.Close()
+	f.Add(append([]byte{MPQ_COMPRESSION_ZLIB}, z.Bytes()...), uint16(500), false)
+
+	adpcm := []byte{0x00, 0x02, 0x10, 0x00, 0x01, 0x43, 0x80, 0x81, 0x07, 0x85, 0x3F, 0x7F, 0x00}
+	f.Add(append([]byte{MPQ_COMPRESSION_ADPCM_MONO}, adpcm...), uint16(16), false)
+	f.Add(append([]byte{MPQ_COMPRESSION_ADPCM_STEREO}, adpcm...), uint16(16), false)
+	f.Add([]byte{MPQ_COMPRESSION_SPARSE, 0x00, 0x00, 0x00, 0x04, 0x83, 'a', 'b', 'c', 'd'}, uint16(8), false)
+	f.Add([]byte{0x00, 0x04, 0x82, 0x24, 0x25, 0x8F, 0x80, 0x7F}, uint16(8), true)
+
+	f.Fuzz(func(t *testing.T, data []byte, expected uint16, implode bool) {
+		var flags uint32
+		if implode {
+			flags = MPQ_FILE_IMPLODE
+		}
+		out, err := decompressUnit(data, uint32(expected), flags)
+		switch {
+		case err != nil:
+			if !errors.Is(err, ErrCorrupt) && !errors.Is(err, ErrUnsupported) {
+				t.Errorf("error %v is neither ErrCorrupt nor ErrUnsupported", err)
+			}
+		case len(out) != int(expected):
+			t.Errorf("got %d bytes, want %d", len(out), expected)
+		}
+	})
+}
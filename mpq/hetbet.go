@@ -0,0 +1,344 @@
+package mpq
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+/* =========================
+   HET / BET tables
+   ========================= */
+
+// Format version 3+ archives may carry a HET (hash entry) table mapping
+// Jenkins name hashes to file indices, and a BET (block entry) table of
+// bit-packed file records. Both start with a 12 byte extended header.
+
+const (
+	hetSignature = 0x1A544548 // 'HET\x1A'
+	betSignature = 0x1A544542 // 'BET\x1A'
+)
+
+type extHeader struct {
+	Signature uint32
+	Version   uint32
+	DataSize  uint32
+}
+
+type hetHeader struct {
+	TableSize      uint32
+	EntryCount     uint32
+	TotalCount     uint32
+	NameHashBits   uint32
+	IndexSizeTotal uint32
+	IndexSizeExtra uint32
+	IndexSize      uint32
+	IndexTableSize uint32
+}
+
+type betHeader struct {
+	TableSize         uint32
+	EntryCount        uint32
+	Unknown08         uint32
+	TableEntrySize    uint32
+	BitIndexFilePos   uint32
+	BitIndexFileSize  uint32
+	BitIndexCmpSize   uint32
+	BitIndexFlagIndex uint32
+	BitIndexUnknown   uint32
+	BitCountFilePos   uint32
+	BitCountFileSize  uint32
+	BitCountCmpSize   uint32
+	BitCountFlagIndex uint32
+	BitCountUnknown   uint32
+	BitTotalNameHash2 uint32
+	BitExtraNameHash2 uint32
+	BitCountNameHash2 uint32
+	NameHashArraySize uint32
+	FlagCount         uint32
+}
+
+type hetTable struct {
+	hdr        hetHeader
+	nameHashes []byte
+	indices    []byte
+
+	// From the BET table, used to confirm a HET match
+	betCount      uint32
+	betHash2      []byte
+	betHash2Total uint32
+	betHash2Bits  uint32
+}
+
+func (m *MPQ) readHetBet() error {
+	h := &m.header
+
+	hetKey := mpqHashString("(hash table)", MPQ_HASH_FILE_KEY)
+	betKey := mpqHashString("(block table)", MPQ_HASH_FILE_KEY)
+
+	hetData, err := m.readExtTable(h.HetTableOffset64, h.HetTableSize64, h.MD5HetTable, hetSignature, hetKey)
+	if err != nil {
+		return fmt.Errorf("HET table: %w", err)
+	}
+	betData, err := m.readExtTable(h.BetTableOffset64, h.BetTableSize64, h.MD5BetTable, betSignature, betKey)
+	if err != nil {
+		return fmt.Errorf("BET table: %w", err)
+	}
+
+	het := &hetTable{}
+	if err := readStruct(hetData, &het.hdr); err != nil {
+		return fmt.Errorf("HET table: %w", err)
+	}
+	if het.hdr.TotalCount == 0 || het.hdr.NameHashBits < 8 || het.hdr.NameHashBits > 64 {
+		return corrupt("HET table: bad header")
+	}
+	rest := hetData[binary.Size(het.hdr):]
+	if uint64(len(rest)) < uint64(het.hdr.TotalCount)+uint64(het.hdr.IndexTableSize) {
+		return corrupt("HET table: truncated")
+	}
+	het.nameHashes = rest[:het.hdr.TotalCount]
+	het.indices = rest[het.hdr.TotalCount : het.hdr.TotalCount+het.hdr.IndexTableSize]
+
+	var bet betHeader
+	if err := readStruct(betData, &bet); err != nil {
+		return fmt.Errorf("BET table: %w", err)
+	}
+	rest = betData[binary.Size(bet):]
+
+	tableBytes := (uint64(bet.TableEntrySize)*uint64(bet.EntryCount) + 7) / 8
+	need := uint64(bet.FlagCount)*4 + tableBytes + uint64(bet.NameHashArraySize)
+	if uint64(len(rest)) < need {
+		return corrupt("BET table: truncated")
+	}
+	if bet.EntryCount > maxTableEntries || bet.FlagCount > maxTableEntries {
+		return corrupt("BET table: %d entries", bet.EntryCount)
+	}
+
+	flags := make([]uint32, bet.FlagCount)
+	for i := range flags {
+		flags[i] = binary.LittleEndian.Uint32(rest[i*4:])
+	}
+	rest = rest[len(flags)*4:]
+	entries := rest[:tableBytes]
+	het.betHash2 = rest[tableBytes : tableBytes+uint64(bet.NameHashArraySize)]
+	het.betCount = bet.EntryCount
+	het.betHash2Total = bet.BitTotalNameHash2
+	het.betHash2Bits = bet.BitCountNameHash2
+	m.het = het
+
+	// Without a classic block table the BET table describes the files
+	if len(m.blockTable) != 0 {
+		return nil
+	}
+
+	m.blockTable = make([]BlockEntry, bet.EntryCount)
+	m.hiBlockTable = make([]uint16, bet.EntryCount)
+	for i := range m.blockTable {
+		base := uint64(i) * uint64(bet.TableEntrySize)
+		pos := getBits(entries, base+uint64(bet.BitIndexFilePos), bet.BitCountFilePos)
+		size := getBits(entries, base+uint64(bet.BitIndexFileSize), bet.BitCountFileSize)
+		cmp := getBits(entries, base+uint64(bet.BitIndexCmpSize), bet.BitCountCmpSize)
+		flagIdx := getBits(entries, base+uint64(bet.BitIndexFlagIndex), bet.BitCountFlagIndex)
+
+		var fl uint32
+		if flagIdx < uint64(len(flags)) {
+			fl = flags[flagIdx]
+		}
+		m.blockTable[i] = BlockEntry{
+			Offset:           uint32(pos),
+			CompressedSize:   uint32(cmp),
+			UncompressedSize: uint32(size),
+			Flags:            fl,
+		}
+		m.hiBlockTable[i] = uint16(pos >> 32)
+	}
+
+	return nil
+}
+
+// readExtTable reads, decrypts and if needed decompresses a HET or BET
+// table, returning the data that follows the extended header.
+func (m *MPQ) readExtTable(offset, storedSize uint64, sum [16]byte, sig, key uint32) ([]byte, error) {
+	var eh extHeader
+	hdrBuf := make([]byte, binary.Size(eh))
+	if offset > uint64(m.size) {
+		return nil, corrupt("table outside archive")
+	}
+	if err := m.readAt(hdrBuf, m.archivePos+int64(offset)); err != nil {
+		return nil, err
+	}
+	if err := readStruct(hdrBuf, &eh); err != nil {
+		return nil, err
+	}
+	if eh.Signature != sig {
+		return nil, corrupt("bad signature")
+	}
+
+	full := uint64(len(hdrBuf)) + uint64(eh.DataSize)
+	if storedSize == 0 {
+		storedSize = full
+	}
+	if storedSize < uint64(len(hdrBuf)) || storedSize > full || storedSize > uint64(m.size) {
+		return nil, corrupt("bad table size")
+	}
+	if eh.DataSize > maxTableEntries*16 {
+		return nil, corrupt("table of %d bytes", eh.DataSize)
+	}
+
+	buf := make([]byte, storedSize)
+	if err := m.readAt(buf, m.archivePos+int64(offset)); err != nil {
+		return nil, err
+	}
+	if !isZeroMD5(sum) && md5.Sum(buf) != sum {
+		return nil, corrupt("MD5 mismatch")
+	}
+
+	data := buf[len(hdrBuf):]
+	mpqDecrypt(data, key)
+
+	if storedSize < full {
+		out, err := decompress(data, eh.DataSize)
+		if err != nil {
+			return nil, err
+		}
+		data = out
+	}
+	if uint32(len(data)) != eh.DataSize {
+		return nil, corrupt("table size mismatch")
+	}
+	return data, nil
+}
+
+// find returns the file index for name, confirmed against the BET name
+// hashes.
+func (t *hetTable) find(name string) (uint32, bool) {
+	hashBits := t.hdr.NameHashBits
+	andMask := ^uint64(0)
+	if hashBits != 64 {
+		andMask = 1<<hashBits - 1
+	}
+	hash := jenkinsNameHash(name)&andMask | 1<<(hashBits-1)
+	hash1 := byte(hash >> (hashBits - 8))
+	hash2 := hash & (andMask >> 8)
+
+	start := uint32(hash % uint64(t.hdr.TotalCount))
+	for i := start; ; {
+		if t.nameHashes[i] == 0 {
+			return 0, false
+		}
+		if t.nameHashes[i] == hash1 {
+			idx := uint32(getBits(t.indices, uint64(i)*uint64(t.hdr.IndexSizeTotal), t.hdr.IndexSize))
+			if idx < t.betCount {
+				h2 := getBits(t.betHash2, uint64(idx)*uint64(t.betHash2Total), t.betHash2Bits)
+				if h2 == hash2 {
+					return idx, true
+				}
+			}
+		}
+
+		i = (i + 1) % t.hdr.TotalCount
+		if i == start {
+			return 0, false
+		}
+	}
+}
+
+/* =========================
+   Helpers
+   ========================= */
+
+// getBits reads count bits (at most 64) from an LSB-first bit array.
+// Bits past the end of the array read as zero.
+func getBits(arr []byte, pos uint64, count uint32) uint64 {
+	var v uint64
+	for i := uint32(0); i < count; i++ {
+		p := pos + uint64(i)
+		if p/8 >= uint64(len(arr)) {
+			break
+		}
+		if arr[p/8]&(1<<(p%8)) != 0 {
+			v |= 1 << i
+		}
+	}
+	return v
+}
+
+// jenkinsNameHash is Bob Jenkins' hashlittle2 over the lower-cased,
+// backslash-separated file name, as used by HET tables. Storm seeds it
+// with 2 and 1 and joins the two results high word first.
+func jenkinsNameHash(name string) uint64 {
+	name = strings.ToLower(strings.ReplaceAll(name, "/", "\\"))
+	c, b := hashlittle2([]byte(name), 2, 1)
+	return uint64(b)<<32 | uint64(c)
+}
+
+// hashlittle2 is lookup3.c's function of the same name: pc and pb seed
+// the state and the two returned words are its c and b.
+func hashlittle2(k []byte, pc, pb uint32) (uint32, uint32) {
+	a := 0xDEADBEEF + uint32(len(k)) + pc
+	b, c := a, a
+	c += pb
+
+	for len(k) > 12 {
+		a += binary.LittleEndian.Uint32(k[0:])
+		b += binary.LittleEndian.Uint32(k[4:])
+		c += binary.LittleEndian.Uint32(k[8:])
+		a, b, c = jenkinsMix(a, b, c)
+		k = k[12:]
+	}
+
+	if len(k) == 0 {
+		return c, b
+	}
+
+	var tail [12]byte
+	copy(tail[:], k)
+	a += binary.LittleEndian.Uint32(tail[0:])
+	b += binary.LittleEndian.Uint32(tail[4:])
+	c += binary.LittleEndian.Uint32(tail[8:])
+	_, b, c = jenkinsFinal(a, b, c)
+
+	return c, b
+}
+
+func jenkinsMix(a, b, c uint32) (uint32, uint32, uint32) {
+	a -= c
+	a ^= bits.RotateLeft32(c, 4)
+	c += b
+	b -= a
+	b ^= bits.RotateLeft32(a, 6)
+	a += c
+	c -= b
+	c ^= bits.RotateLeft32(b, 8)
+	b += a
+	a -= c
+	a ^= bits.RotateLeft32(c, 16)
+	c += b
+	b -= a
+	b ^= bits.RotateLeft32(a, 19)
+	a += c
+	c -= b
+	c ^= bits.RotateLeft32(b, 4)
+	b += a
+	return a, b, c
+}
+
+func jenkinsFinal(a, b, c uint32) (uint32, uint32, uint32) {
+	c ^= b
+	c -= bits.RotateLeft32(b, 14)
+	a ^= c
+	a -= bits.RotateLeft32(c, 11)
+	b ^= a
+	b -= bits.RotateLeft32(a, 25)
+	c ^= b
+	c -= bits.RotateLeft32(b, 16)
+	a ^= c
+	a -= bits.RotateLeft32(c, 4)
+	b ^= a
+	b -= bits.RotateLeft32(a, 14)
+	c ^= b
+	c -= bits.RotateLeft32(b, 24)
+	return a, b, c
+}
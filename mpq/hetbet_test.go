@@ -0,0 +1,118 @@
+package mpq
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Vectors printed by the driver5 test in lookup3.c.
+func TestHashlittle2(t *testing.T) {
+	const four = "Four score and seven years ago"
+	tests := []struct {
+		in     string
+		pc, pb uint32
+		c, b   uint32
+	}{
+		{"", 0, 0, 0xdeadbeef, 0xdeadbeef},
+		{"", 0, 0xdeadbeef, 0xbd5b7dde, 0xdeadbeef},
+		{"", 0xdeadbeef, 0xdeadbeef, 0x9c093ccd, 0xbd5b7dde},
+		{four, 0, 0, 0x17770551, 0xce7226e6},
+		{four, 0, 1, 0xe3607cae, 0xbd371de4},
+		{four, 1, 0, 0xcd628161, 0x6cbea4b3},
+	}
+	for _, tt := range tests {
+		c, b := hashlittle2([]byte(tt.in), tt.pc, tt.pb)
+		if c != tt.c || b != tt.b {
+			t.Errorf("hashlittle2(%q, %#x, %#x) = %08x %08x, want %08x %08x",
+				tt.in, tt.pc, tt.pb, c, b, tt.c, tt.b)
+		}
+	}
+}
+
+// Expected values from StormLib's HashStringJenkins.
+func TestJenkinsNameHash(t *testing.T) {
+	tests := []struct {
+		name string
+		want uint64
+	}{
+		{"", 0xdeadbef1deadbef2},
+		{"(listfile)", 0x3bc43bb0b2f3866a},
+		{"zlib.txt", 0x81b3b7cbb3ab0cc1},
+		{`World\Maps\Azeroth\Azeroth.wdt`, 0x64b735a7e1312f4d},
+		{"WORLD/maps/azeroth/AZEROTH.WDT", 0x64b735a7e1312f4d},
+	}
+	for _, tt := range tests {
+		if got := jenkinsNameHash(tt.name); got != tt.want {
+			t.Errorf("jenkinsNameHash(%q) = %016x, want %016x", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetBits(t *testing.T) {
+	arr := []byte{0xB5, 0x3C} // 1011 0101 0011 1100
+	tests := []struct {
+		pos   uint64
+		count uint32
+		want  uint64
+	}{
+		{0, 4, 0x5},
+		{4, 8, 0xCB},
+		{3, 5, 0x16},
+		{12, 8, 0x3}, // runs off the end
+		{16, 8, 0},
+	}
+	for _, tt := range tests {
+		if got := getBits(arr, tt.pos, tt.count); got != tt.want {
+			t.Errorf("getBits(%d, %d) = %#x, want %#x", tt.pos, tt.count, got, tt.want)
+		}
+	}
+}
+
+// The archives hold the same files in each format version; storm-hetbet
+// is storm-v3 with the hash and block tables dropped from the header.
+func TestOpenFormatVersions(t *testing.T) {
+	tests := []struct {
+		file    string
+		version uint16
+		hetOnly bool
+	}{
+		{"storm-v2.mpq", 1, false},
+		{"storm-v3.mpq", 2, false},
+		{"storm-v4.mpq", 3, false},
+		{"storm-hetbet.mpq", 2, true},
+	}
+	for _, tt := range tests {
+		m := openStorm(t, tt.file)
+		if m.header.FormatVersion != tt.version {
+			t.Errorf("%s: format version %d, want %d", tt.file, m.header.FormatVersion, tt.version)
+		}
+		if tt.version >= 2 && m.het == nil {
+			t.Errorf("%s: no HET table", tt.file)
+		}
+		if tt.hetOnly && len(m.hashTable) != 0 {
+			t.Errorf("%s: %d hash table entries", tt.file, len(m.hashTable))
+		}
+
+		for _, f := range []struct {
+			name, text string
+			lines      int
+		}{
+			{"zlib.txt", "zlib.txt", 500},
+			{"encrypted.txt", "encrypted.txt", 500},
+			{`World\Maps\Azeroth\Azeroth.wdt`, "Azeroth.wdt", 5},
+		} {
+			got, err := m.ReadFile(f.name)
+			if err != nil {
+				t.Errorf("%s: %s: %v", tt.file, f.name, err)
+				continue
+			}
+			if !bytes.Equal(got, stormText(f.text, f.lines)) {
+				t.Errorf("%s: %s: content differs", tt.file, f.name)
+			}
+		}
+
+		if _, err := m.ReadFile("missing.txt"); err == nil {
+			t.Errorf("%s: missing.txt: no error", tt.file)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package mpq
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// huffCompress is the encoder matching decompressHuffman: it walks the
+// same adaptive tree and writes each symbol's path from the root.
+func huffCompress(t *testing.T, cmpType byte, data []byte) []byte {
+	t.Helper()
+
+	tree, err := newHuffTree(cmpType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adaptive := cmpType == 0
+
+	w := &huffBitWriter{}
+	w.put(uint32(cmpType), 8)
+
+	for _, b := range data {
+		v := uint32(b)
+		if tree.byByte[v] == nil {
+			w.putSymbol(tree.byByte[huffNewByte])
+			w.put(v, 8)
+			if err := tree.insertBranch(v); err != nil {
+				t.Fatal(err)
+			}
+			if !adaptive {
+				tree.incWeight(tree.byByte[v])
+			}
+		} else {
+			w.putSymbol(tree.byByte[v])
+		}
+
+		if adaptive {
+			tree.incWeight(tree.byByte[v])
+		}
+	}
+	w.putSymbol(tree.byByte[huffEndOfStream])
+	return w.bytes()
+}
+
+type huffBitWriter struct {
+	out    []byte
+	bitBuf uint32
+	bitCnt uint
+}
+
+func (w *huffBitWriter) put(v uint32, n uint) {
+	for i := uint(0); i < n; i++ {
+		w.bitBuf |= (v >> i & 1) << w.bitCnt
+		w.bitCnt++
+		if w.bitCnt == 8 {
+			w.out = append(w.out, byte(w.bitBuf))
+			w.bitBuf, w.bitCnt = 0, 0
+		}
+	}
+}
+
+// putSymbol writes the path to a leaf, root first. The higher-weight
+// child of a node is childLo.prev and is reached with a 1 bit.
+func (w *huffBitWriter) putSymbol(it *huffItem) {
+	var path []uint32
+	for ; it.parent != nil; it = it.parent {
+		if it.parent.childLo == it {
+			path = append(path, 0)
+		} else {
+			path = append(path, 1)
+		}
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		w.put(path[i], 1)
+	}
+}
+
+func (w *huffBitWriter) bytes() []byte {
+	if w.bitCnt > 0 {
+		return append(w.out, byte(w.bitBuf))
+	}
+	return w.out
+}
+
+func TestHuffmanRoundTrip(t *testing.T) {
+	text := []byte("The quick brown fox jumps over the lazy dog. The quick brown fox jumps over the lazy dog.")
+	binary := make([]byte, 2048)
+	for i := range binary {
+		binary[i] = byte(i * i >> 3)
+	}
+	// Small values, as left by ADPCM, for the wave tables
+	wave := make([]byte, 1024)
+	for i := range wave {
+		wave[i] = byte(i % 13)
+	}
+
+	inputs := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"text", text},
+		{"binary", binary},
+		{"wave", wave},
+		{"one byte", []byte{0xFF}},
+		{"runs", bytes.Repeat([]byte{0x00, 0x00, 0x00, 0xFE}, 300)},
+	}
+
+	for cmpType := byte(0); int(cmpType) < len(huffWeights); cmpType++ {
+		for _, in := range inputs {
+			packed := huffCompress(t, cmpType, in.data)
+
+			got, err := decompressHuffman(packed, uint32(len(in.data)))
+			if err != nil {
+				t.Errorf("type %d, %s: %v", cmpType, in.name, err)
+				continue
+			}
+			if !bytes.Equal(got, in.data) {
+				t.Errorf("type %d, %s: round trip mismatch", cmpType, in.name)
+			}
+		}
+	}
+}
+
+func TestHuffmanErrors(t *testing.T) {
+	if _, err := decompressHuffman([]byte{byte(len(huffWeights))}, 16); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("unknown type: got %v, want ErrUnsupported", err)
+	}
+
+	packed := huffCompress(t, 1, []byte("truncated stream"))
+	if _, err := decompressHuffman(packed[:len(packed)/2], 16); err == nil {
+		t.Error("truncated stream: expected an error")
+	}
+}
+
+func TestHuffmanStormSector(t *testing.T) {
+	sector, want := stormSector(t, "huffman")
+	got, err := decompress(sector, uint32(len(want)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("output differs from StormLib's")
+	}
+}
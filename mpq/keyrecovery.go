@@ -0,0 +1,100 @@
+package mpq
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+/* =========================
+   File key recovery
+   ========================= */
+
+var errUnknownKey = fmt.Errorf("%w: file key unknown, name needed to decrypt", ErrUnsupported)
+
+// blockKey returns the decryption key of a block: derived from the name
+// when it is known, otherwise one recovered earlier.
+func (m *MPQ) blockKey(name string, blockIdx uint32) (uint32, bool) {
+	if name != "" {
+		return m.fileKey(name, m.blockTable[blockIdx], m.blockOffset(blockIdx)), true
+	}
+
+	m.keyMu.Lock()
+	defer m.keyMu.Unlock()
+	key, ok := m.keys[blockIdx]
+	return key, ok
+}
+
+func (m *MPQ) cacheKey(blockIdx, key uint32) {
+	m.keyMu.Lock()
+	defer m.keyMu.Unlock()
+	if m.keys == nil {
+		m.keys = make(map[uint32]uint32)
+	}
+	m.keys[blockIdx] = key
+}
+
+// recoverSectorKey finds the file key of a sectored file from its
+// encrypted sector offset table. The table's first entry is always the
+// table's own size in bytes; that known DWORD leaves one key candidate
+// per low key byte, and each candidate is checked against the shape of
+// the whole decrypted table.
+func recoverSectorKey(table []byte, block BlockEntry, sectSize uint32) (uint32, bool) {
+	if len(table) < 8 {
+		return 0, false
+	}
+	enc0 := binary.LittleEndian.Uint32(table)
+	plain0 := uint32(len(table))
+
+	buf := make([]byte, len(table))
+	offsets := make([]uint32, len(table)/4)
+	for i := uint32(0); i < 0x100; i++ {
+		seed := 0xEEEEEEEE + cryptTable[0x400+i]
+		key := (enc0 ^ plain0) - seed
+		if key&0xFF != i {
+			continue
+		}
+
+		copy(buf, table)
+		mpqDecrypt(buf, key)
+		for j := range offsets {
+			offsets[j] = binary.LittleEndian.Uint32(buf[j*4:])
+		}
+		if validSectorTable(offsets, block, sectSize) {
+			// The table is encrypted with the file key minus one
+			return key + 1, true
+		}
+	}
+	return 0, false
+}
+
+// ReadBlock reads a file by block index. It needs no name unless the
+// file is encrypted and its key can't be recovered from a sector offset
+// table.
+func (m *MPQ) ReadBlock(blockIdx uint32) ([]byte, error) {
+	return m.readBlock("", blockIdx)
+}
+
+// UnnamedBlocks returns the existing blocks referenced by hash entries
+// that no known name matches, for use with ReadBlock.
+func (m *MPQ) UnnamedBlocks() []uint32 {
+	known := m.knownHashes()
+
+	seen := make(map[uint32]bool)
+	var out []uint32
+	for _, h := range m.hashTable {
+		if h.BlockIdx >= uint32(len(m.blockTable)) || seen[h.BlockIdx] {
+			continue
+		}
+		if m.blockTable[h.BlockIdx].Flags&MPQ_FILE_EXISTS == 0 {
+			continue
+		}
+		if known[[2]uint32{h.NameA, h.NameB}] {
+			continue
+		}
+		seen[h.BlockIdx] = true
+		out = append(out, h.BlockIdx)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
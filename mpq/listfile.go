@@ -0,0 +1,153 @@
+package mpq
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+/* =========================
+   Listfile
+   ========================= */
+
+// FileInfo describes one archived file as named by a listfile.
+type FileInfo struct {
+	Name             string
+	Locale           uint16
+	CompressedSize   uint32
+	UncompressedSize uint32
+	Flags            uint32
+	BlockIdx         uint32
+}
+
+// IsDeleteMarker reports whether the entry marks the file as deleted
+// rather than storing it.
+func (fi FileInfo) IsDeleteMarker() bool {
+	return fi.Flags&MPQ_FILE_DELETE_MARKER != 0
+}
+
+// AddListfile registers file names from an external listfile. Names the
+// archive does not contain are ignored.
+func (m *MPQ) AddListfile(r io.Reader) error {
+	m.listMu.Lock()
+	defer m.listMu.Unlock()
+
+	m.loadInternalListfile()
+	return m.addListfile(r)
+}
+
+// List returns every named file in the archive, sorted by name. Names
+// come from the internal "(listfile)" and any external listfiles.
+func (m *MPQ) List() []FileInfo {
+	m.listMu.Lock()
+	m.loadInternalListfile()
+	names := make([]string, 0, len(m.names))
+	for _, n := range m.names {
+		names = append(names, n)
+	}
+	m.listMu.Unlock()
+
+	sort.Strings(names)
+
+	var out []FileInfo
+	for _, n := range names {
+		out = append(out, m.fileInfos(n)...)
+	}
+	return out
+}
+
+// Glob returns the named files matching pattern. Matching follows
+// path.Match, is case-insensitive and accepts either '/' or '\' as the
+// separator.
+func (m *MPQ) Glob(pattern string) ([]FileInfo, error) {
+	pattern = strings.ToLower(strings.ReplaceAll(pattern, "\\", "/"))
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	var out []FileInfo
+	for _, fi := range m.List() {
+		name := strings.ToLower(strings.ReplaceAll(fi.Name, "\\", "/"))
+		if ok, _ := path.Match(pattern, name); ok {
+			out = append(out, fi)
+		}
+	}
+	return out, nil
+}
+
+// loadInternalListfile, addListfile and addName need listMu held.
+func (m *MPQ) loadInternalListfile() {
+	if m.names != nil {
+		return
+	}
+	m.names = make(map[string]string)
+
+	// Archives without a listfile are valid; they just list nothing
+	if data, err := m.ReadFile("(listfile)"); err == nil {
+		m.addListfile(bytes.NewReader(data))
+		m.addName("(listfile)")
+	}
+}
+
+func (m *MPQ) addListfile(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	sc.Split(splitListfile)
+
+	for sc.Scan() {
+		m.addName(strings.TrimSpace(sc.Text()))
+	}
+	return sc.Err()
+}
+
+func (m *MPQ) addName(name string) {
+	if name == "" {
+		return
+	}
+	name = strings.ReplaceAll(name, "/", "\\")
+	key := strings.ToUpper(name)
+	if _, ok := m.names[key]; ok {
+		return
+	}
+	if len(m.findHashEntries(name)) == 0 {
+		return
+	}
+	m.names[key] = name
+}
+
+func (m *MPQ) fileInfos(name string) []FileInfo {
+	var out []FileInfo
+	for _, h := range m.findHashEntries(name) {
+		if int(h.BlockIdx) >= len(m.blockTable) {
+			continue
+		}
+		b := m.blockTable[h.BlockIdx]
+		if b.Flags&MPQ_FILE_EXISTS == 0 {
+			continue
+		}
+		out = append(out, FileInfo{
+			Name:             name,
+			Locale:           h.Locale,
+			CompressedSize:   b.CompressedSize,
+			UncompressedSize: b.UncompressedSize,
+			Flags:            b.Flags,
+			BlockIdx:         h.BlockIdx,
+		})
+	}
+	return out
+}
+
+// splitListfile splits on any of the separators Storm accepts in
+// listfiles: CR, LF and ';'.
+func splitListfile(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, "\r\n;"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,100 @@
+package mpq
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func names(fis []FileInfo) []string {
+	var out []string
+	for _, fi := range fis {
+		out = append(out, fi.Name)
+	}
+	return out
+}
+
+func TestList(t *testing.T) {
+	m := openStorm(t, "storm.mpq")
+
+	want := []string{
+		"(listfile)",
+		`Textures\Minimap\Azeroth\map30_31.blp`,
+		`Textures\Minimap\Azeroth\map30_32.blp`,
+		`World\Maps\Azeroth\Azeroth.wdt`,
+		"bzip2.txt",
+		"crc.txt",
+		"empty.txt",
+		"encrypted.txt",
+		"fixkey.txt",
+		"implode-single.txt",
+		"implode.txt",
+		"locale.txt",
+		"locale.txt",
+		"locale.txt",
+		"lzma.txt",
+		"pkware.txt",
+		"single.txt",
+		"stored.txt",
+		"zlib.txt",
+	}
+	list := m.List()
+	if got := names(list); !slices.Equal(got, want) {
+		t.Fatalf("List:\n got %q\nwant %q", got, want)
+	}
+
+	for _, fi := range list {
+		if fi.Name == "stored.txt" && (fi.CompressedSize != fi.UncompressedSize || fi.UncompressedSize != 10500) {
+			t.Errorf("stored.txt: sizes %d/%d", fi.CompressedSize, fi.UncompressedSize)
+		}
+		if fi.Name == "zlib.txt" && fi.Flags&MPQ_FILE_COMPRESS == 0 {
+			t.Errorf("zlib.txt: flags %#x", fi.Flags)
+		}
+	}
+}
+
+func TestAddListfile(t *testing.T) {
+	m := openStorm(t, "storm-unnamed.mpq")
+	if got := m.List(); len(got) != 0 {
+		t.Fatalf("no listfile, but List returned %q", names(got))
+	}
+
+	// Storm splits on CR, LF and ';', and names are matched by hash
+	lf := "readme.txt;missing.txt\r\n  DBFilesClient/Map.dbc  \n\nREADME.TXT\n"
+	if err := m.AddListfile(strings.NewReader(lf)); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`DBFilesClient\Map.dbc`, "readme.txt"}
+	if got := names(m.List()); !slices.Equal(got, want) {
+		t.Fatalf("List: got %q, want %q", got, want)
+	}
+}
+
+func TestGlob(t *testing.T) {
+	m := openStorm(t, "storm.mpq")
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"implode*.txt", []string{"implode-single.txt", "implode.txt"}},
+		{"textures/minimap/*/*.BLP", []string{`Textures\Minimap\Azeroth\map30_31.blp`, `Textures\Minimap\Azeroth\map30_32.blp`}},
+		{`World\Maps\*\*.wdt`, []string{`World\Maps\Azeroth\Azeroth.wdt`}},
+		{"*.wdt", nil}, // '*' does not cross directories
+		{"l?cale.txt", []string{"locale.txt", "locale.txt", "locale.txt"}},
+	}
+	for _, tt := range tests {
+		got, err := m.Glob(tt.pattern)
+		if err != nil {
+			t.Errorf("%s: %v", tt.pattern, err)
+			continue
+		}
+		if !slices.Equal(names(got), tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.pattern, names(got), tt.want)
+		}
+	}
+
+	if _, err := m.Glob("[a-"); err == nil {
+		t.Error("bad pattern: no error")
+	}
+}
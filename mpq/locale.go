@@ -0,0 +1,101 @@
+package mpq
+
+import (
+	"fmt"
+	"sort"
+)
+
+/* =========================
+   Locales
+   ========================= */
+
+// Hash entries carry the Windows LCID of the file's language. Neutral
+// (and, in practice, enUS) files use 0.
+const LocaleNeutral = 0
+
+var localeIDs = map[string]uint16{
+	"enUS": 0x0409,
+	"enGB": 0x0809,
+	"deDE": 0x0407,
+	"frFR": 0x040C,
+	"esES": 0x040A,
+	"esMX": 0x080A,
+	"itIT": 0x0410,
+	"ptBR": 0x0416,
+	"ptPT": 0x0816,
+	"ruRU": 0x0419,
+	"plPL": 0x0415,
+	"koKR": 0x0412,
+	"zhCN": 0x0804,
+	"zhTW": 0x0404,
+}
+
+// ParseLocale returns the LCID for a client locale code such as "deDE".
+func ParseLocale(code string) (uint16, bool) {
+	id, ok := localeIDs[code]
+	return id, ok
+}
+
+// LocaleName returns the client locale code for an LCID, "neutral" for
+// 0, or the hex value for unknown IDs.
+func LocaleName(id uint16) string {
+	if id == LocaleNeutral {
+		return "neutral"
+	}
+	for code, v := range localeIDs {
+		if v == id {
+			return code
+		}
+	}
+	return fmt.Sprintf("0x%04X", id)
+}
+
+// SetLocales sets the locale preference used by ReadFile and Open. Each
+// locale is tried in order, then the neutral locale, then the lowest
+// remaining LCID.
+func (m *MPQ) SetLocales(locales ...uint16) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.locales = append([]uint16(nil), locales...)
+}
+
+// Locales returns the current locale preference list.
+func (m *MPQ) Locales() []uint16 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]uint16(nil), m.locales...)
+}
+
+// Variants returns every locale version of name, ordered by LCID.
+func (m *MPQ) Variants(name string) []FileInfo {
+	out := m.fileInfos(name)
+	sort.Slice(out, func(i, j int) bool { return out[i].Locale < out[j].Locale })
+	return out
+}
+
+// ReadFileLocale reads the version of name tagged with exactly locale.
+func (m *MPQ) ReadFileLocale(name string, locale uint16) ([]byte, error) {
+	h, ok := m.lookupLocale(name, locale)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return m.readBlock(name, h.BlockIdx)
+}
+
+// OpenLocale is Open for the version of name tagged with exactly locale.
+func (m *MPQ) OpenLocale(name string, locale uint16) (*File, error) {
+	h, ok := m.lookupLocale(name, locale)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return m.openBlock(name, h.BlockIdx)
+}
+
+func (m *MPQ) lookupLocale(name string, locale uint16) (HashEntry, bool) {
+	for _, e := range m.findHashEntries(name) {
+		if e.Locale == locale && int(e.BlockIdx) < len(m.blockTable) {
+			return e, true
+		}
+	}
+	return HashEntry{}, false
+}
@@ -0,0 +1,96 @@
+package mpq
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestLocaleNames(t *testing.T) {
+	if id, ok := ParseLocale("deDE"); !ok || id != 0x407 {
+		t.Errorf("ParseLocale(deDE) = %#x, %v", id, ok)
+	}
+	if _, ok := ParseLocale("xxXX"); ok {
+		t.Error("ParseLocale(xxXX) succeeded")
+	}
+	for id, want := range map[uint16]string{0: "neutral", 0x40C: "frFR", 0x1234: "0x1234"} {
+		if got := LocaleName(id); got != want {
+			t.Errorf("LocaleName(%#x) = %q, want %q", id, got, want)
+		}
+	}
+}
+
+func TestVariants(t *testing.T) {
+	m := openStorm(t, "storm.mpq")
+	vs := m.Variants("locale.txt")
+	if len(vs) != 3 {
+		t.Fatalf("got %d variants, want 3", len(vs))
+	}
+	for i, want := range []uint16{0, 0x407, 0x40C} {
+		if vs[i].Locale != want || vs[i].Name != "locale.txt" {
+			t.Errorf("variant %d: %+v, want locale %#x", i, vs[i], want)
+		}
+	}
+	if vs := m.Variants("stored.txt"); len(vs) != 1 || vs[0].Locale != LocaleNeutral {
+		t.Errorf("stored.txt: %+v", vs)
+	}
+	if vs := m.Variants("missing.txt"); len(vs) != 0 {
+		t.Errorf("missing.txt: %+v", vs)
+	}
+}
+
+func TestReadFileLocale(t *testing.T) {
+	m := openStorm(t, "storm.mpq")
+	for locale, prefix := range map[uint16]string{
+		0:     "locale.txt",
+		0x407: "locale.txt deDE",
+		0x40C: "locale.txt frFR",
+	} {
+		want := stormText(prefix, 10)
+		got, err := m.ReadFileLocale("locale.txt", locale)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("ReadFileLocale(%#x): %q, %v", locale, got, err)
+		}
+		f, err := m.OpenLocale("locale.txt", locale)
+		if err != nil {
+			t.Fatalf("OpenLocale(%#x): %v", locale, err)
+		}
+		got, err = io.ReadAll(f)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("OpenLocale(%#x): %q, %v", locale, got, err)
+		}
+	}
+	if _, err := m.ReadFileLocale("locale.txt", 0x416); err == nil {
+		t.Error("ptBR: no error")
+	}
+	if _, err := m.OpenLocale("stored.txt", 0x407); err == nil {
+		t.Error("stored.txt deDE: no error")
+	}
+}
+
+func TestSetLocales(t *testing.T) {
+	m := openStorm(t, "storm.mpq")
+	for _, tc := range []struct {
+		locales []uint16
+		prefix  string
+	}{
+		{nil, "locale.txt"},
+		{[]uint16{0x40C}, "locale.txt frFR"},
+		{[]uint16{0x416, 0x407}, "locale.txt deDE"},
+		// Unavailable locales fall back to neutral
+		{[]uint16{0x416}, "locale.txt"},
+	} {
+		m.SetLocales(tc.locales...)
+		got, err := m.ReadFile("locale.txt")
+		if err != nil || !bytes.Equal(got, stormText(tc.prefix, 10)) {
+			t.Errorf("locales %#x: %q, %v", tc.locales, got, err)
+		}
+	}
+
+	m.SetLocales(0x407, 0x40C)
+	ls := m.Locales()
+	ls[0] = 0
+	if m.Locales()[0] != 0x407 {
+		t.Error("Locales returned the internal slice")
+	}
+}
@@ -0,0 +1,375 @@
+package mpq
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+/* =========================
+   LZMA
+   ========================= */
+
+// Storm stores LZMA sectors as a filter byte (always 0), the 5 byte
+// LZMA properties and an 8 byte uncompressed size, followed by the
+// range-coded stream.
+
+const (
+	lzmaHeaderSize = 1 + 5 + 8
+
+	lzmaNumStates          = 12
+	lzmaNumPosBitsMax      = 4
+	lzmaNumLenToPosStates  = 4
+	lzmaNumAlignBits       = 4
+	lzmaStartPosModelIndex = 4
+	lzmaEndPosModelIndex   = 14
+	lzmaNumFullDistances   = 1 << (lzmaEndPosModelIndex >> 1)
+	lzmaMatchMinLen        = 2
+
+	lzmaProbInit = 1 << 10
+)
+
+var errLZMACorrupt = errors.New("lzma: corrupt stream")
+
+func decompressLZMA(data []byte, expected uint32) ([]byte, error) {
+	if len(data) <= lzmaHeaderSize {
+		return nil, errors.New("lzma: truncated header")
+	}
+	if data[0] != 0 {
+		return nil, fmt.Errorf("%w: lzma filter %d", ErrUnsupported, data[0])
+	}
+
+	d := int(data[1])
+	if d >= 9*5*5 {
+		return nil, errors.New("lzma: bad properties")
+	}
+	lc, lp, pb := uint(d%9), uint(d/9%5), uint(d/45)
+
+	// The whole output is kept in memory, so the dictionary size in
+	// props[1:5] needs no separate window.
+	dec := newLZMADecoder(lc, lp, pb)
+	return dec.decode(data[lzmaHeaderSize:], expected)
+}
+
+/* =========================
+   Range coder
+   ========================= */
+
+type lzmaRangeDecoder struct {
+	in    []byte
+	pos   int
+	rng   uint32
+	code  uint32
+	overr bool
+}
+
+func (rc *lzmaRangeDecoder) init(in []byte) error {
+	if len(in) < 5 || in[0] != 0 {
+		return errLZMACorrupt
+	}
+	rc.in = in
+	rc.pos = 5
+	rc.rng = 0xFFFFFFFF
+	rc.code = binary.BigEndian.Uint32(in[1:])
+	if rc.code == rc.rng {
+		return errLZMACorrupt
+	}
+	return nil
+}
+
+func (rc *lzmaRangeDecoder) next() byte {
+	if rc.pos >= len(rc.in) {
+		rc.overr = true
+		return 0
+	}
+	b := rc.in[rc.pos]
+	rc.pos++
+	return b
+}
+
+func (rc *lzmaRangeDecoder) normalize() {
+	if rc.rng < 1<<24 {
+		rc.rng <<= 8
+		rc.code = rc.code<<8 | uint32(rc.next())
+	}
+}
+
+func (rc *lzmaRangeDecoder) bit(prob *uint16) uint32 {
+	bound := (rc.rng >> 11) * uint32(*prob)
+	var b uint32
+	if rc.code < bound {
+		*prob += (1<<11 - *prob) >> 5
+		rc.rng = bound
+	} else {
+		*prob -= *prob >> 5
+		rc.code -= bound
+		rc.rng -= bound
+		b = 1
+	}
+	rc.normalize()
+	return b
+}
+
+func (rc *lzmaRangeDecoder) direct(numBits uint) uint32 {
+	var res uint32
+	for ; numBits > 0; numBits-- {
+		rc.rng >>= 1
+		rc.code -= rc.rng
+		t := 0 - (rc.code >> 31)
+		rc.code += rc.rng & t
+		if rc.code == rc.rng {
+			rc.overr = true
+		}
+		rc.normalize()
+		res = res<<1 + t + 1
+	}
+	return res
+}
+
+func (rc *lzmaRangeDecoder) tree(probs []uint16, numBits uint) uint32 {
+	m := uint32(1)
+	for i := uint(0); i < numBits; i++ {
+		m = m<<1 + rc.bit(&probs[m])
+	}
+	return m - 1<<numBits
+}
+
+func (rc *lzmaRangeDecoder) reverseTree(probs []uint16, numBits uint) uint32 {
+	m := uint32(1)
+	var sym uint32
+	for i := uint(0); i < numBits; i++ {
+		b := rc.bit(&probs[m])
+		m = m<<1 + b
+		sym |= b << i
+	}
+	return sym
+}
+
+func newProbs(n int) []uint16 {
+	p := make([]uint16, n)
+	for i := range p {
+		p[i] = lzmaProbInit
+	}
+	return p
+}
+
+/* =========================
+   Length decoder
+   ========================= */
+
+type lzmaLenDecoder struct {
+	choice  uint16
+	choice2 uint16
+	low     [1 << lzmaNumPosBitsMax][]uint16
+	mid     [1 << lzmaNumPosBitsMax][]uint16
+	high    []uint16
+}
+
+func newLZMALenDecoder() *lzmaLenDecoder {
+	ld := &lzmaLenDecoder{
+		choice:  lzmaProbInit,
+		choice2: lzmaProbInit,
+		high:    newProbs(1 << 8),
+	}
+	for i := range ld.low {
+		ld.low[i] = newProbs(1 << 3)
+		ld.mid[i] = newProbs(1 << 3)
+	}
+	return ld
+}
+
+func (ld *lzmaLenDecoder) decode(rc *lzmaRangeDecoder, posState uint32) uint32 {
+	if rc.bit(&ld.choice) == 0 {
+		return rc.tree(ld.low[posState], 3)
+	}
+	if rc.bit(&ld.choice2) == 0 {
+		return 8 + rc.tree(ld.mid[posState], 3)
+	}
+	return 16 + rc.tree(ld.high, 8)
+}
+
+/* =========================
+   Decoder
+   ========================= */
+
+type lzmaDecoder struct {
+	lc, lp, pb uint
+
+	literal    []uint16
+	posSlot    [lzmaNumLenToPosStates][]uint16
+	posDecoder []uint16
+	align      []uint16
+
+	isMatch    []uint16
+	isRep      []uint16
+	isRepG0    []uint16
+	isRepG1    []uint16
+	isRepG2    []uint16
+	isRep0Long []uint16
+
+	lenDecoder    *lzmaLenDecoder
+	repLenDecoder *lzmaLenDecoder
+}
+
+func newLZMADecoder(lc, lp, pb uint) *lzmaDecoder {
+	d := &lzmaDecoder{
+		lc:            lc,
+		lp:            lp,
+		pb:            pb,
+		literal:       newProbs(0x300 << (lc + lp)),
+		posDecoder:    newProbs(1 + lzmaNumFullDistances - lzmaEndPosModelIndex),
+		align:         newProbs(1 << lzmaNumAlignBits),
+		isMatch:       newProbs(lzmaNumStates << lzmaNumPosBitsMax),
+		isRep:         newProbs(lzmaNumStates),
+		isRepG0:       newProbs(lzmaNumStates),
+		isRepG1:       newProbs(lzmaNumStates),
+		isRepG2:       newProbs(lzmaNumStates),
+		isRep0Long:    newProbs(lzmaNumStates << lzmaNumPosBitsMax),
+		lenDecoder:    newLZMALenDecoder(),
+		repLenDecoder: newLZMALenDecoder(),
+	}
+	for i := range d.posSlot {
+		d.posSlot[i] = newProbs(1 << 6)
+	}
+	return d
+}
+
+func (d *lzmaDecoder) distance(rc *lzmaRangeDecoder, length uint32) uint32 {
+	lenState := min(length, lzmaNumLenToPosStates-1)
+
+	slot := rc.tree(d.posSlot[lenState], 6)
+	if slot < lzmaStartPosModelIndex {
+		return slot
+	}
+
+	numDirectBits := uint(slot>>1) - 1
+	dist := (2 | slot&1) << numDirectBits
+	if slot < lzmaEndPosModelIndex {
+		return dist + rc.reverseTree(d.posDecoder[dist-slot:], numDirectBits)
+	}
+
+	dist += rc.direct(numDirectBits-lzmaNumAlignBits) << lzmaNumAlignBits
+	return dist + rc.reverseTree(d.align, lzmaNumAlignBits)
+}
+
+func (d *lzmaDecoder) decode(in []byte, expected uint32) ([]byte, error) {
+	var rc lzmaRangeDecoder
+	if err := rc.init(in); err != nil {
+		return nil, err
+	}
+
+	out := outputBuffer(expected)
+
+	var state uint32
+	var rep0, rep1, rep2, rep3 uint32
+	pbMask := uint32(1)<<d.pb - 1
+	lpMask := uint32(1)<<d.lp - 1
+
+	for uint32(len(out)) < expected {
+		if rc.overr {
+			return nil, errLZMACorrupt
+		}
+
+		posState := uint32(len(out)) & pbMask
+
+		if rc.bit(&d.isMatch[state<<lzmaNumPosBitsMax+posState]) == 0 {
+			var prev uint32
+			if len(out) > 0 {
+				prev = uint32(out[len(out)-1])
+			}
+			litState := (uint32(len(out))&lpMask)<<d.lc + prev>>(8-d.lc)
+			probs := d.literal[0x300*litState:]
+
+			sym := uint32(1)
+			if state >= 7 {
+				matchByte := uint32(out[len(out)-int(rep0)-1])
+				for sym < 0x100 {
+					matchBit := (matchByte >> 7) & 1
+					matchByte <<= 1
+					b := rc.bit(&probs[(1+matchBit)<<8+sym])
+					sym = sym<<1 | b
+					if matchBit != b {
+						break
+					}
+				}
+			}
+			for sym < 0x100 {
+				sym = sym<<1 | rc.bit(&probs[sym])
+			}
+			out = append(out, byte(sym))
+
+			switch {
+			case state < 4:
+				state = 0
+			case state < 10:
+				state -= 3
+			default:
+				state -= 6
+			}
+			continue
+		}
+
+		var length uint32
+		if rc.bit(&d.isRep[state]) != 0 {
+			if len(out) == 0 {
+				return nil, errLZMACorrupt
+			}
+			if rc.bit(&d.isRepG0[state]) == 0 {
+				if rc.bit(&d.isRep0Long[state<<lzmaNumPosBitsMax+posState]) == 0 {
+					if state < 7 {
+						state = 9
+					} else {
+						state = 11
+					}
+					out = append(out, out[len(out)-int(rep0)-1])
+					continue
+				}
+			} else {
+				var dist uint32
+				if rc.bit(&d.isRepG1[state]) == 0 {
+					dist = rep1
+				} else {
+					if rc.bit(&d.isRepG2[state]) == 0 {
+						dist = rep2
+					} else {
+						dist = rep3
+						rep3 = rep2
+					}
+					rep2 = rep1
+				}
+				rep1 = rep0
+				rep0 = dist
+			}
+			length = d.repLenDecoder.decode(&rc, posState)
+			if state < 7 {
+				state = 8
+			} else {
+				state = 11
+			}
+		} else {
+			rep3, rep2, rep1 = rep2, rep1, rep0
+			length = d.lenDecoder.decode(&rc, posState)
+			if state < 7 {
+				state = 7
+			} else {
+				state = 10
+			}
+			rep0 = d.distance(&rc, length)
+			if rep0 == 0xFFFFFFFF {
+				break // end marker
+			}
+		}
+
+		if int(rep0) >= len(out) {
+			return nil, errLZMACorrupt
+		}
+
+		length += lzmaMatchMinLen
+		from := len(out) - int(rep0) - 1
+		for i := uint32(0); i < length && uint32(len(out)) < expected; i++ {
+			out = append(out, out[from+int(i)])
+		}
+	}
+
+	return out, nil
+}
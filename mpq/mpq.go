@@ -2,13 +2,14 @@ package mpq
 
 import (
 	"bytes"
-	"compress/zlib"
+	"crypto/md5"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"strings"
+	"sync"
 )
 
 /* =========================
@@ -63,26 +64,74 @@ func mpqDecrypt(data []byte, key uint32) {
 	}
 }
 
+func mpqEncrypt(data []byte, key uint32) {
+	var seed uint32 = 0xEEEEEEEE
+	for i := 0; i+4 <= len(data); i += 4 {
+		seed += cryptTable[0x400+(key&0xFF)]
+		value := binary.LittleEndian.Uint32(data[i:])
+		binary.LittleEndian.PutUint32(data[i:], value^(key+seed))
+		key = ((^key << 21) + 0x11111111) | (key >> 11)
+		seed = value + seed + (seed << 5) + 3
+	}
+}
+
 /* =========================
    MPQ constants
    ========================= */
 
 const (
+	MPQ_FILE_IMPLODE       = 0x00000100
+	MPQ_FILE_COMPRESS      = 0x00000200
 	MPQ_FILE_COMPRESS_MASK = 0x0000FF00
 	MPQ_FILE_ENCRYPTED     = 0x00010000
 	MPQ_FILE_FIX_KEY       = 0x00020000
 	MPQ_FILE_SINGLE_UNIT   = 0x01000000
+	MPQ_FILE_DELETE_MARKER = 0x02000000
 	MPQ_FILE_SECTOR_CRC    = 0x04000000
+	MPQ_FILE_EXISTS        = 0x80000000
 )
 
 const (
-	MPQ_COMPRESSION_ZLIB = 0x02
+	MPQ_COMPRESSION_HUFFMAN      = 0x01
+	MPQ_COMPRESSION_ZLIB         = 0x02
+	MPQ_COMPRESSION_PKWARE       = 0x08
+	MPQ_COMPRESSION_BZIP2        = 0x10
+	MPQ_COMPRESSION_LZMA         = 0x12
+	MPQ_COMPRESSION_SPARSE       = 0x20
+	MPQ_COMPRESSION_ADPCM_MONO   = 0x40
+	MPQ_COMPRESSION_ADPCM_STEREO = 0x80
 )
 
+/* =========================
+   Errors
+   ========================= */
+
+// Errors returned by the package wrap one of these, so callers can test
+// with errors.Is.
+var (
+	ErrNotFound    = errors.New("file not found")
+	ErrCorrupt     = errors.New("corrupt archive")
+	ErrUnsupported = errors.New("unsupported archive feature")
+)
+
+// maxSectorShift bounds SectorSizeShift to a 4 MiB sector; real archives
+// use far less.
+const maxSectorShift = 13
+
+// maxTableEntries bounds hash and block tables whose size can't be
+// checked against the archive size (compressed v4 tables, BET tables).
+const maxTableEntries = 1 << 24
+
+func corrupt(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrCorrupt, fmt.Sprintf(format, args...))
+}
+
 /* =========================
    Structures
    ========================= */
 
+// Header covers all on-disk header versions. Fields beyond the archive's
+// FormatVersion are zero.
 type Header struct {
 	ID                uint32
 	HeaderSize        uint32
@@ -93,8 +142,35 @@ type Header struct {
 	BlockTableOffset  uint32
 	HashTableEntries  uint32
 	BlockTableEntries uint32
+
+	// Format version 2
+	HiBlockTableOffset64 uint64
+	HashTableOffsetHi    uint16
+	BlockTableOffsetHi   uint16
+
+	// Format version 3
+	ArchiveSize64    uint64
+	BetTableOffset64 uint64
+	HetTableOffset64 uint64
+
+	// Format version 4
+	HashTableSize64    uint64
+	BlockTableSize64   uint64
+	HiBlockTableSize64 uint64
+	HetTableSize64     uint64
+	BetTableSize64     uint64
+	RawChunkSize       uint32
+	MD5BlockTable      [16]byte
+	MD5HashTable       [16]byte
+	MD5HiBlockTable    [16]byte
+	MD5BetTable        [16]byte
+	MD5HetTable        [16]byte
+	MD5Header          [16]byte
 }
 
+// headerSizes is the on-disk header size per FormatVersion.
+var headerSizes = [...]int{32, 44, 68, 208}
+
 type HashEntry struct {
 	NameA    uint32
 	NameB    uint32
@@ -110,12 +186,43 @@ type BlockEntry struct {
 	Flags             uint32
 }
 
+// MPQ is an open archive. It is safe for concurrent use; the tables are
+// read-only after Open and the mutable state below has its own locks.
 type MPQ struct {
-	f          *os.File
-	header     Header
-	hashTable  []HashEntry
-	blockTable []BlockEntry
-	archivePos int64
+	r            io.ReaderAt
+	size         int64
+	path         string
+	closer       io.Closer
+	header       Header
+	hashTable    []HashEntry
+	blockTable   []BlockEntry
+	hiBlockTable []uint16
+	het          *hetTable
+	archivePos   int64
+
+	// Set when the archive is reached through a user-data header
+	userData    *UserDataHeader
+	userDataPos int64
+
+	// Known file names, keyed by upper-cased name (see listfile.go)
+	listMu sync.Mutex
+	names  map[string]string
+
+	// Parsed "(attributes)", loaded on first use (see attributes.go)
+	attrsMu  sync.Mutex
+	attrs    []FileAttributes
+	attrsErr error
+
+	// Settings guarded by mu: the sector checksum mode and handler and
+	// the preferred locales for lookups (see sectorcrc.go, locale.go)
+	mu         sync.RWMutex
+	crcMode    SectorCRCMode
+	crcHandler func(*SectorCRCError)
+	locales    []uint16
+
+	// File keys recovered for blocks of unknown name (see keyrecovery.go)
+	keyMu sync.Mutex
+	keys  map[uint32]uint32
 }
 
 /* =========================
@@ -128,18 +235,39 @@ func Open(path string) (*MPQ, error) {
 		return nil, err
 	}
 
-	mpq := &MPQ{f: f}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
 
-	if err := binary.Read(f, binary.LittleEndian, &mpq.header); err != nil {
+	mpq, err := OpenReaderAt(f, st.Size())
+	if err != nil {
+		f.Close()
 		return nil, err
 	}
+	return mpq, nil
+}
+
+// OpenReaderAt reads an archive from r, which holds size bytes. If r has
+// a Name method (as *os.File does) it becomes the archive's Path. Close
+// closes r if it implements io.Closer.
+func OpenReaderAt(r io.ReaderAt, size int64) (*MPQ, error) {
+	mpq := &MPQ{r: r, size: size}
+	if c, ok := r.(io.Closer); ok {
+		mpq.closer = c
+	}
+	if n, ok := r.(interface{ Name() string }); ok {
+		mpq.path = n.Name()
+	}
 
-	if mpq.header.ID != 0x1A51504D {
-		return nil, errors.New("not an MPQ archive")
+	if err := mpq.findArchive(); err != nil {
+		return nil, err
 	}
 
-	mpq.archivePos, _ = f.Seek(0, io.SeekCurrent)
-	mpq.archivePos -= int64(mpq.header.HeaderSize)
+	if err := mpq.readHeader(); err != nil {
+		return nil, err
+	}
 
 	if err := mpq.readTables(); err != nil {
 		return nil, err
@@ -148,8 +276,124 @@ func Open(path string) (*MPQ, error) {
 	return mpq, nil
 }
 
+const (
+	mpqSignature      = 0x1A51504D // 'MPQ\x1A'
+	userDataSignature = 0x1B51504D // 'MPQ\x1B'
+)
+
+// UserDataHeader precedes archives embedded after custom data; it points
+// at the real archive header.
+type UserDataHeader struct {
+	ID                 uint32
+	UserDataSize       uint32
+	HeaderOffset       uint32
+	UserDataHeaderSize uint32
+}
+
+// findArchive locates the archive header, which may sit at any 512 byte
+// boundary of the file, either directly or behind a user-data header,
+// and sets archivePos.
+func (m *MPQ) findArchive() error {
+	var sig [4]byte
+	for pos := int64(0); pos+int64(headerSizes[0]) <= m.size; pos += 512 {
+		if _, err := m.r.ReadAt(sig[:], pos); err != nil {
+			return err
+		}
+
+		switch binary.LittleEndian.Uint32(sig[:]) {
+		case mpqSignature:
+			m.archivePos = pos
+			return nil
+
+		case userDataSignature:
+			buf := make([]byte, binary.Size(UserDataHeader{}))
+			if _, err := m.r.ReadAt(buf, pos); err != nil {
+				return err
+			}
+			var ud UserDataHeader
+			if err := readStruct(buf, &ud); err != nil {
+				return err
+			}
+
+			// Follow the redirection only if it lands on an archive
+			target := pos + int64(ud.HeaderOffset)
+			if _, err := m.r.ReadAt(sig[:], target); err == nil && binary.LittleEndian.Uint32(sig[:]) == mpqSignature {
+				m.userData = &ud
+				m.userDataPos = pos
+				m.archivePos = target
+				return nil
+			}
+		}
+	}
+
+	return corrupt("not an MPQ archive")
+}
+
+// UserData returns the user-data header and the data that follows it,
+// or nil if the archive has none.
+func (m *MPQ) UserData() (*UserDataHeader, []byte, error) {
+	if m.userData == nil {
+		return nil, nil, nil
+	}
+
+	size := min(m.userData.UserDataHeaderSize, m.userData.UserDataSize)
+	data := make([]byte, min(int64(size), m.size-m.userDataPos))
+	if err := m.readAt(data, m.userDataPos+int64(binary.Size(UserDataHeader{}))); err != nil {
+		return nil, nil, err
+	}
+	ud := *m.userData
+	return &ud, data, nil
+}
+
+func (m *MPQ) readHeader() error {
+	buf := make([]byte, headerSizes[len(headerSizes)-1])
+	n, err := m.r.ReadAt(buf, m.archivePos)
+	if n < headerSizes[0] {
+		if err == nil || err == io.EOF {
+			err = corrupt("truncated header")
+		}
+		return err
+	}
+
+	if binary.LittleEndian.Uint32(buf) != mpqSignature {
+		return corrupt("not an MPQ archive")
+	}
+
+	// Only trust as many bytes as the format version defines
+	version := int(binary.LittleEndian.Uint16(buf[12:]))
+	size := headerSizes[min(version, len(headerSizes)-1)]
+	if n < size {
+		return corrupt("truncated header")
+	}
+	clear(buf[size:])
+
+	if err := readStruct(buf, &m.header); err != nil {
+		return err
+	}
+
+	if version >= 3 && !isZeroMD5(m.header.MD5Header) {
+		if md5.Sum(buf[:size-16]) != m.header.MD5Header {
+			return corrupt("header MD5 mismatch")
+		}
+	}
+
+	if m.header.SectorSizeShift > maxSectorShift {
+		return corrupt("sector size shift %d", m.header.SectorSizeShift)
+	}
+	return nil
+}
+
 func (m *MPQ) Close() error {
-	return m.f.Close()
+	if m.closer == nil {
+		return nil
+	}
+	return m.closer.Close()
+}
+
+// Path returns the name the archive was opened from, or "" if the
+// reader had none.
+func (m *MPQ) Path() string {
+	return m.path
 }
 
 /* =========================
@@ -157,41 +401,155 @@ func (m *MPQ) Close() error {
    ========================= */
 
 func (m *MPQ) readTables() error {
-	m.hashTable = make([]HashEntry, m.header.HashTableEntries)
-	m.blockTable = make([]BlockEntry, m.header.BlockTableEntries)
+	h := &m.header
+
+	// Check the counts before allocating anything they size
+	if !m.tableFits(h.HashTableEntries, 16, h.HashTableSize64) {
+		return corrupt("hash table: %d entries", h.HashTableEntries)
+	}
+	if !m.tableFits(h.BlockTableEntries, 16, h.BlockTableSize64) {
+		return corrupt("block table: %d entries", h.BlockTableEntries)
+	}
+
+	m.hashTable = make([]HashEntry, h.HashTableEntries)
+	m.blockTable = make([]BlockEntry, h.BlockTableEntries)
 
 	hashKey := mpqHashString("(hash table)", MPQ_HASH_FILE_KEY)
 	blockKey := mpqHashString("(block table)", MPQ_HASH_FILE_KEY)
 
 	if err := m.readEncryptedTable(
-		int64(m.header.HashTableOffset),
+		uint64(h.HashTableOffsetHi)<<32|uint64(h.HashTableOffset),
+		h.HashTableSize64,
+		h.MD5HashTable,
 		m.hashTable,
 		hashKey,
 	); err != nil {
-		return err
+		return fmt.Errorf("hash table: %w", err)
 	}
 
 	if err := m.readEncryptedTable(
-		int64(m.header.BlockTableOffset),
+		uint64(h.BlockTableOffsetHi)<<32|uint64(h.BlockTableOffset),
+		h.BlockTableSize64,
+		h.MD5BlockTable,
 		m.blockTable,
 		blockKey,
 	); err != nil {
-		return err
+		return fmt.Errorf("block table: %w", err)
+	}
+
+	// High 16 bits of each block's file offset (format version 2+)
+	if h.FormatVersion >= 1 && h.HiBlockTableOffset64 != 0 {
+		m.hiBlockTable = make([]uint16, h.BlockTableEntries)
+		if err := m.readTable(
+			h.HiBlockTableOffset64,
+			h.HiBlockTableSize64,
+			h.MD5HiBlockTable,
+			m.hiBlockTable,
+			nil,
+		); err != nil {
+			return fmt.Errorf("hi-block table: %w", err)
+		}
+	}
+
+	// HET/BET tables (format version 3+)
+	if h.FormatVersion >= 2 && h.HetTableOffset64 != 0 && h.BetTableOffset64 != 0 {
+		if err := m.readHetBet(); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (m *MPQ) readEncryptedTable(offset int64, table interface{}, key uint32) error {
-	size := binary.Size(table)
-	buf := make([]byte, size)
+// tableFits reports whether a table of count entries can be stored in
+// the archive: uncompressed tables must fit in the file, compressed ones
+// are only bounded by maxTableEntries.
+func (m *MPQ) tableFits(count, entrySize uint32, storedSize uint64) bool {
+	size := uint64(count) * uint64(entrySize)
+	if storedSize != 0 && storedSize < size {
+		return count <= maxTableEntries && storedSize <= uint64(m.size-m.archivePos)
+	}
+	return size <= uint64(m.size-m.archivePos)
+}
 
-	if _, err := m.f.ReadAt(buf, offset); err != nil {
+func (m *MPQ) readEncryptedTable(offset, storedSize uint64, sum [16]byte, table interface{}, key uint32) error {
+	return m.readTable(offset, storedSize, sum, table, func(b []byte) { mpqDecrypt(b, key) })
+}
+
+// readTable loads a table stored at an archive-relative offset. In
+// format version 4 archives the stored size may be smaller than the
+// table, in which case the table is compressed like a single-unit file.
+func (m *MPQ) readTable(offset, storedSize uint64, sum [16]byte, table interface{}, decrypt func([]byte)) error {
+	size := uint64(binary.Size(table))
+	if size == 0 {
+		return nil
+	}
+
+	compressed := storedSize != 0 && storedSize < size
+	readSize := size
+	if compressed {
+		readSize = storedSize
+	}
+
+	if offset > uint64(m.size) || readSize > uint64(m.size) {
+		return corrupt("table outside archive")
+	}
+	buf := make([]byte, readSize)
+	if err := m.readAt(buf, m.archivePos+int64(offset)); err != nil {
 		return err
 	}
 
-	mpqDecrypt(buf, key)
-	return binary.Read(bytes.NewReader(buf), binary.LittleEndian, table)
+	if m.header.FormatVersion >= 3 && !isZeroMD5(sum) && md5.Sum(buf) != sum {
+		return corrupt("MD5 mismatch")
+	}
+
+	if decrypt != nil {
+		decrypt(buf)
+	}
+
+	if compressed {
+		out, err := decompress(buf, uint32(size))
+		if err != nil {
+			return err
+		}
+		if uint64(len(out)) != size {
+			return corrupt("decompressed table size mismatch")
+		}
+		buf = out
+	}
+
+	return readStruct(buf, table)
+}
+
+// blockOffset returns the absolute file position of a block.
+func (m *MPQ) blockOffset(idx uint32) int64 {
+	off := uint64(m.blockTable[idx].Offset)
+	if int(idx) < len(m.hiBlockTable) {
+		off |= uint64(m.hiBlockTable[idx]) << 32
+	}
+	return m.archivePos + int64(off)
+}
+
+// readAt reads len(buf) bytes at an absolute file position. Reads that
+// fall outside the file are reported as ErrCorrupt, since only a bad
+// offset or size in the archive can cause them.
+func (m *MPQ) readAt(buf []byte, off int64) error {
+	if off < 0 || off > m.size || int64(len(buf)) > m.size-off {
+		return corrupt("read of %d bytes at %d outside archive", len(buf), off)
+	}
+	_, err := m.r.ReadAt(buf, off)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return corrupt("unexpected end of archive")
+	}
+	return err
+}
+
+func readStruct(buf []byte, v interface{}) error {
+	return binary.Read(bytes.NewReader(buf), binary.LittleEndian, v)
+}
+
+func isZeroMD5(sum [16]byte) bool {
+	return sum == [16]byte{}
 }
 
 /* =========================
@@ -200,6 +558,19 @@ func (m *MPQ) readEncryptedTable(offset int64, table interface{}, key uint32) er
 
 func (m *MPQ) findHashEntries(name string) []HashEntry {
 	name = strings.ReplaceAll(name, "/", "\\")
+
+	// Archives without a classic hash table are indexed by HET only
+	if m.header.HashTableEntries == 0 {
+		if m.het == nil {
+			return nil
+		}
+		idx, ok := m.het.find(name)
+		if !ok {
+			return nil
+		}
+		return []HashEntry{{BlockIdx: idx}}
+	}
+
 	hashA := mpqHashString(name, MPQ_HASH_NAME_A)
 	hashB := mpqHashString(name, MPQ_HASH_NAME_B)
 
@@ -222,154 +593,206 @@ func (m *MPQ) findHashEntries(name string) []HashEntry {
 	return matches
 }
 
+// NameHash identifies a file name in hash tables by its
+// MPQ_HASH_NAME_A and MPQ_HASH_NAME_B hashes.
+type NameHash [2]uint32
+
+// HashName returns the NameHash of a file name.
+func HashName(name string) NameHash {
+	return nameHashes(strings.ReplaceAll(name, "/", "\\"))
+}
+
+// NameHashes returns the NameHash of every hash table entry that points
+// at an existing block, for indexing files across archives. It returns
+// false for archives indexed only by a HET table, whose entries carry no
+// name hashes.
+func (m *MPQ) NameHashes() ([]NameHash, bool) {
+	if m.header.HashTableEntries == 0 {
+		return nil, false
+	}
+	out := make([]NameHash, 0, len(m.blockTable))
+	for _, h := range m.hashTable {
+		if h.BlockIdx < uint32(len(m.blockTable)) && m.blockTable[h.BlockIdx].Flags&MPQ_FILE_EXISTS != 0 {
+			out = append(out, NameHash{h.NameA, h.NameB})
+		}
+	}
+	return out, true
+}
+
 /* =========================
    ReadFile
    ========================= */
 
 func (m *MPQ) ReadFile(name string) ([]byte, error) {
-	candidates := m.findHashEntries(name)
-	if len(candidates) == 0 {
-		return nil, errors.New("file not found")
+	h, ok := m.lookup(name)
+	if !ok {
+		return nil, ErrNotFound
 	}
+	return m.readBlock(name, h.BlockIdx)
+}
 
-	var h HashEntry
-	found := false
-	for _, e := range candidates {
-		if e.Locale == 0 {
-			h = e
-			found = true
-			break
+// lookup picks the hash entry ReadFile uses for name following the
+// locale preference (see SetLocales).
+func (m *MPQ) lookup(name string) (HashEntry, bool) {
+	// Entries whose block no longer exists are skipped
+	var candidates []HashEntry
+	for _, e := range m.findHashEntries(name) {
+		if e.BlockIdx >= uint32(len(m.blockTable)) || m.blockTable[e.BlockIdx].Flags&MPQ_FILE_EXISTS != 0 {
+			candidates = append(candidates, e)
 		}
 	}
-	if !found {
-		h = candidates[0]
+	if len(candidates) == 0 {
+		return HashEntry{}, false
 	}
 
-	block := m.blockTable[h.BlockIdx]
-	fileOffset := int64(block.Offset)
+	m.mu.RLock()
+	locales := m.locales
+	m.mu.RUnlock()
 
-	if block.Flags&MPQ_FILE_SINGLE_UNIT != 0 {
-		raw := make([]byte, block.CompressedSize)
-		if _, err := m.f.ReadAt(raw, fileOffset); err != nil {
-			return nil, err
-		}
-
-		if block.Flags&MPQ_FILE_ENCRYPTED != 0 {
-			mpqDecrypt(raw, m.fileKey(name, block, fileOffset))
+	for _, loc := range locales {
+		for _, e := range candidates {
+			if e.Locale == loc {
+				return e, true
+			}
 		}
+	}
 
-		if block.Flags&MPQ_FILE_COMPRESS_MASK == 0 {
-			return raw, nil
+	// Neutral is 0, so the lowest LCID also covers the neutral fallback
+	best := candidates[0]
+	for _, e := range candidates[1:] {
+		if e.Locale < best.Locale {
+			best = e
 		}
-
-		return decompressSingleUnit(raw, block.UncompressedSize)
 	}
+	return best, true
+}
 
-	if block.Flags&MPQ_FILE_COMPRESS_MASK != 0 {
-		return m.readSectorCompressedFile(fileOffset, block, name)
+// Stat describes the entry ReadFile would use for name without reading
+// it.
+func (m *MPQ) Stat(name string) (FileInfo, bool) {
+	h, ok := m.lookup(name)
+	if !ok || int(h.BlockIdx) >= len(m.blockTable) {
+		return FileInfo{}, false
 	}
-
-	// Plain file
-	out := make([]byte, block.UncompressedSize)
-	_, err := m.f.ReadAt(out, fileOffset)
-	return out, err
+	b := m.blockTable[h.BlockIdx]
+	if b.Flags&MPQ_FILE_EXISTS == 0 {
+		return FileInfo{}, false
+	}
+	return FileInfo{
+		Name:             name,
+		Locale:           h.Locale,
+		CompressedSize:   b.CompressedSize,
+		UncompressedSize: b.UncompressedSize,
+		Flags:            b.Flags,
+		BlockIdx:         h.BlockIdx,
+	}, true
 }
 
-/* =========================
-   Sector-compressed reader
-   ========================= */
+// checkBlock validates a block entry against the archive before any of
+// its sizes are trusted.
+func (m *MPQ) checkBlock(blockIdx uint32) (BlockEntry, error) {
+	if blockIdx >= uint32(len(m.blockTable)) {
+		return BlockEntry{}, corrupt("block index %d out of range", blockIdx)
+	}
+	block := m.blockTable[blockIdx]
+	if block.Flags&MPQ_FILE_EXISTS == 0 {
+		return BlockEntry{}, ErrNotFound
+	}
 
-func (m *MPQ) readSectorCompressedFile(offset int64, block BlockEntry, name string) ([]byte, error) {
-	sectorSize := uint32(512) << m.header.SectorSizeShift
-	sectorCount := (block.UncompressedSize + sectorSize - 1) / sectorSize
+	off := m.blockOffset(blockIdx)
+	if off < m.archivePos || off > m.size || int64(block.CompressedSize) > m.size-off {
+		return BlockEntry{}, corrupt("block %d outside archive", blockIdx)
+	}
 
-	tableDWORDs := sectorCount + 1
-	if block.Flags&MPQ_FILE_SECTOR_CRC != 0 {
-		tableDWORDs++
+	packed := block.Flags&MPQ_FILE_COMPRESS_MASK != 0
+	switch {
+	case !packed && block.CompressedSize < block.UncompressedSize:
+		return BlockEntry{}, corrupt("block %d: stored size below file size", blockIdx)
+	case packed && block.Flags&MPQ_FILE_SINGLE_UNIT == 0:
+		// The sector offset table alone takes a DWORD per sector
+		sectSize := uint64(512) << m.header.SectorSizeShift
+		sectors := (uint64(block.UncompressedSize) + sectSize - 1) / sectSize
+		if (sectors+1)*4 > uint64(block.CompressedSize) {
+			return BlockEntry{}, corrupt("block %d: too small for its sector table", blockIdx)
+		}
 	}
+	return block, nil
+}
 
-	table := make([]byte, tableDWORDs*4)
-	if _, err := m.f.ReadAt(table, offset); err != nil {
+func (m *MPQ) readBlock(name string, blockIdx uint32) ([]byte, error) {
+	block, err := m.checkBlock(blockIdx)
+	if err != nil {
 		return nil, err
 	}
 
-	key := m.fileKey(name, block, offset)
-	if block.Flags&MPQ_FILE_ENCRYPTED != 0 {
-		mpqDecrypt(table, key-1)
+	if block.Flags&MPQ_FILE_SINGLE_UNIT != 0 {
+		return m.readSingleUnit(name, blockIdx)
 	}
 
-	offsets := make([]uint32, tableDWORDs)
-	for i := range offsets {
-		offsets[i] = binary.LittleEndian.Uint32(table[i*4:])
+	// Sectored and plain files go through the streaming reader. The
+	// output grows as sectors decode, so a bogus UncompressedSize fails
+	// on the data instead of on one huge allocation.
+	f, err := m.openBlock(name, blockIdx)
+	if err != nil {
+		return nil, err
 	}
+	var out bytes.Buffer
+	out.Grow(int(min(block.UncompressedSize, maxPrealloc)))
+	if _, err := io.Copy(&out, io.NewSectionReader(f, 0, f.Size())); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
 
-	out := make([]byte, 0, block.UncompressedSize)
-
-	for i := uint32(0); i < sectorCount; i++ {
-		start, end := offsets[i], offsets[i+1]
-		size := end - start
-
-		sector := make([]byte, size)
-		readPos := offset + int64(int32(start))
-		if _, err := m.f.ReadAt(sector, readPos); err != nil {
-			return nil, err
-		}
-
-		if block.Flags&MPQ_FILE_ENCRYPTED != 0 {
-			mpqDecrypt(sector, key+i)
-		}
-
-		expected := sectorSize
-		remain := block.UncompressedSize - i*sectorSize
-		if remain < expected {
-			expected = remain
-		}
+func (m *MPQ) readSingleUnit(name string, blockIdx uint32) ([]byte, error) {
+	block, err := m.checkBlock(blockIdx)
+	if err != nil {
+		return nil, err
+	}
+	raw := make([]byte, block.CompressedSize)
+	if err := m.readAt(raw, m.blockOffset(blockIdx)); err != nil {
+		return nil, err
+	}
 
-		if size == expected {
-			out = append(out, sector...)
-			continue
+	if block.Flags&MPQ_FILE_ENCRYPTED != 0 {
+		key, ok := m.blockKey(name, blockIdx)
+		if !ok {
+			return nil, errUnknownKey
 		}
+		mpqDecrypt(raw, key)
+	}
 
-		switch sector[0] {
-		case MPQ_COMPRESSION_ZLIB:
-			r, err := zlib.NewReader(bytes.NewReader(sector[1:]))
-			if err != nil {
-				return nil, err
-			}
-			data, err := io.ReadAll(r)
-			r.Close()
-			if err != nil {
-				return nil, err
-			}
-			out = append(out, data...)
-		default:
-			return nil, fmt.Errorf("unsupported compression: 0x%02X", sector[0])
-		}
+	if block.Flags&MPQ_FILE_COMPRESS_MASK == 0 || block.CompressedSize >= block.UncompressedSize {
+		return raw[:block.UncompressedSize], nil
 	}
 
-	return out[:block.UncompressedSize], nil
+	return decompressUnit(raw, block.UncompressedSize, block.Flags)
 }
 
 /* =========================
    Helpers
    ========================= */
 
-func decompressSingleUnit(data []byte, expected uint32) ([]byte, error) {
-	switch data[0] {
-	case MPQ_COMPRESSION_ZLIB:
-		r, err := zlib.NewReader(bytes.NewReader(data[1:]))
-		if err != nil {
-			return nil, err
-		}
-		out, err := io.ReadAll(r)
-		r.Close()
-		if err != nil {
-			return nil, err
-		}
-		return out, nil
-	default:
-		return nil, fmt.Errorf("unsupported compression: 0x%02X", data[0])
+// decompressUnit decompresses a single-unit file or one sector.
+// Imploded files carry a bare PKWARE stream; compressed files are
+// prefixed with a compression type byte.
+// Output shorter than expected means the data is damaged.
+func decompressUnit(data []byte, expected uint32, flags uint32) ([]byte, error) {
+	var out []byte
+	var err error
+	if flags&MPQ_FILE_IMPLODE != 0 {
+		out, err = explode(data, expected)
+		err = asCorrupt(err)
+	} else {
+		out, err = decompress(data, expected)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if uint32(len(out)) < expected {
+		return nil, corrupt("decompressed %d of %d bytes", len(out), expected)
 	}
+	return out[:expected], nil
 }
 
 func baseNameForKey(name string) string {
@@ -0,0 +1,119 @@
+package mpq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLookupSkipsFreedBlocks(t *testing.T) {
+	w := NewWriter()
+	w.Add("a.txt", []byte("a"), 0, 0)
+	w.Add("a.txt", []byte("a-de"), 0, 0x407)
+	_, m := saveArchive(t, w)
+	m.SetLocales(0x407)
+
+	fi, ok := m.Stat("a.txt")
+	if !ok || fi.Locale != 0x407 {
+		t.Fatalf("got %+v, %v", fi, ok)
+	}
+
+	// Free the German block: lookups fall back to the neutral one
+	m.blockTable[fi.BlockIdx].Flags &^= MPQ_FILE_EXISTS
+	if fi, ok := m.Stat("a.txt"); !ok || fi.Locale != 0 {
+		t.Errorf("after freeing one block: got %+v, %v", fi, ok)
+	}
+	if data, err := m.ReadFile("a.txt"); err != nil || string(data) != "a" {
+		t.Errorf("ReadFile: got %q, %v", data, err)
+	}
+
+	fi, _ = m.Stat("a.txt")
+	m.blockTable[fi.BlockIdx].Flags &^= MPQ_FILE_EXISTS
+	if fi, ok := m.Stat("a.txt"); ok {
+		t.Errorf("after freeing both blocks: got %+v", fi)
+	}
+	hashes, _ := m.NameHashes()
+	for _, h := range hashes {
+		if h == HashName("a.txt") {
+			t.Error("NameHashes lists a freed block")
+		}
+	}
+}
+
+// openPrefixed opens testdata/name behind the given prefix bytes.
+func openPrefixed(t *testing.T, name string, prefix []byte) (*MPQ, error) {
+	t.Helper()
+	raw, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, append(prefix, raw...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := Open(path)
+	if err == nil {
+		t.Cleanup(func() { m.Close() })
+	}
+	return m, err
+}
+
+func TestEmbeddedArchive(t *testing.T) {
+	m, err := openPrefixed(t, "storm.mpq", bytes.Repeat([]byte("stub"), 256))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.archivePos != 1024 {
+		t.Errorf("archive at %d, want 1024", m.archivePos)
+	}
+	if got, err := m.ReadFile("zlib.txt"); err != nil || !bytes.Equal(got, stormText("zlib.txt", 500)) {
+		t.Errorf("zlib.txt: %v", err)
+	}
+	if ud, _, err := m.UserData(); ud != nil || err != nil {
+		t.Errorf("UserData = %+v, %v; want none", ud, err)
+	}
+
+	// Headers are only searched for at 512 byte boundaries
+	if _, err := openPrefixed(t, "storm.mpq", make([]byte, 100)); err == nil {
+		t.Error("unaligned archive found")
+	}
+	if _, err := openPrefixed(t, "storm.mpq", nil); err != nil {
+		t.Errorf("plain archive: %v", err)
+	}
+}
+
+func TestUserDataHeader(t *testing.T) {
+	prefix := make([]byte, 0x400)
+	binary.LittleEndian.PutUint32(prefix[0:], userDataSignature)
+	binary.LittleEndian.PutUint32(prefix[4:], 0x200) // UserDataSize
+	binary.LittleEndian.PutUint32(prefix[8:], 0x400) // HeaderOffset
+	binary.LittleEndian.PutUint32(prefix[12:], 0x10) // UserDataHeaderSize
+	copy(prefix[16:], "user data here!!")
+
+	m, err := openPrefixed(t, "storm.mpq", prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ud, data, err := m.UserData()
+	if err != nil || ud == nil {
+		t.Fatalf("UserData = %+v, %v", ud, err)
+	}
+	if ud.HeaderOffset != 0x400 || string(data) != "user data here!!" {
+		t.Errorf("UserData = %+v, %q", ud, data)
+	}
+	if got, err := m.ReadFile("encrypted.txt"); err != nil || !bytes.Equal(got, stormText("encrypted.txt", 500)) {
+		t.Errorf("encrypted.txt: %v", err)
+	}
+
+	// A user-data header that points nowhere is skipped
+	binary.LittleEndian.PutUint32(prefix[8:], 0x200)
+	m, err = openPrefixed(t, "storm.mpq", prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ud, _, _ := m.UserData(); ud != nil || m.archivePos != 0x400 {
+		t.Errorf("followed a bad user-data header: %+v at %d", ud, m.archivePos)
+	}
+}
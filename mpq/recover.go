@@ -0,0 +1,208 @@
+package mpq
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+/* =========================
+   Name recovery
+   ========================= */
+
+// Recovery names hash entries that no listfile covers by hashing
+// candidate names against their NameA/NameB pairs. Archives indexed only
+// by a HET table have no such pairs and recover nothing.
+type Recovery struct {
+	m       *MPQ
+	pending map[[2]uint32]bool
+	found   []string
+}
+
+// mapTemplates are per-map paths; <map> is the map directory name and
+// <x>/<y> run over the 64x64 ADT grid (<xx>/<yy> zero-padded).
+var mapTemplates = []string{
+	"World\\Maps\\<map>\\<map>.wdt",
+	"World\\Maps\\<map>\\<map>.wdl",
+	"World\\Maps\\<map>\\<map>.tex",
+	"World\\Maps\\<map>\\<map>_<x>_<y>.adt",
+	"World\\Maps\\<map>\\<map>_<x>_<y>_obj0.adt",
+	"World\\Maps\\<map>\\<map>_<x>_<y>_obj1.adt",
+	"World\\Maps\\<map>\\<map>_<x>_<y>_tex0.adt",
+	"World\\Maps\\<map>\\<map>_<x>_<y>_lod.adt",
+	"Textures\\Minimap\\<map>\\map<xx>_<yy>.blp",
+	"World\\Minimaps\\<map>\\map<xx>_<yy>.blp",
+}
+
+// specialNames are the archive's own bookkeeping files, which listfiles
+// often leave out.
+var specialNames = []string{"(listfile)", "(attributes)", "(signature)"}
+
+// NewRecovery collects the hash entries of existing files that no known
+// name matches.
+func (m *MPQ) NewRecovery() *Recovery {
+	known := m.knownHashes()
+	r := &Recovery{m: m, pending: make(map[[2]uint32]bool)}
+	for _, h := range m.hashTable {
+		if h.BlockIdx >= uint32(len(m.blockTable)) || m.blockTable[h.BlockIdx].Flags&MPQ_FILE_EXISTS == 0 {
+			continue
+		}
+		k := [2]uint32{h.NameA, h.NameB}
+		if !known[k] {
+			r.pending[k] = true
+		}
+	}
+	return r
+}
+
+// knownHashes returns the name hashes of every listed file and of the
+// special files.
+func (m *MPQ) knownHashes() map[[2]uint32]bool {
+	known := make(map[[2]uint32]bool)
+	for _, name := range specialNames {
+		known[nameHashes(name)] = true
+	}
+	for _, fi := range m.List() {
+		known[nameHashes(fi.Name)] = true
+	}
+	return known
+}
+
+// Try hashes one candidate. A match is recorded, registered with the
+// archive as a known name, and reported as true.
+func (r *Recovery) Try(name string) bool {
+	name = strings.ReplaceAll(strings.TrimSpace(name), "/", "\\")
+	if name == "" || len(r.pending) == 0 {
+		return false
+	}
+
+	k := nameHashes(name)
+	if !r.pending[k] {
+		return false
+	}
+	delete(r.pending, k)
+	r.found = append(r.found, name)
+	r.m.listMu.Lock()
+	r.m.addName(name)
+	r.m.listMu.Unlock()
+	return true
+}
+
+// TryReader tries every name in a dictionary or listfile, returning how
+// many matched.
+func (r *Recovery) TryReader(rd io.Reader) (int, error) {
+	sc := bufio.NewScanner(rd)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	sc.Split(splitListfile)
+
+	n := 0
+	for sc.Scan() {
+		if r.Try(sc.Text()) {
+			n++
+		}
+	}
+	return n, sc.Err()
+}
+
+// TryMap tries the known per-map paths for every ADT grid cell of
+// mapName.
+func (r *Recovery) TryMap(mapName string) int {
+	n := 0
+	for _, t := range mapTemplates {
+		t = strings.ReplaceAll(t, "<map>", mapName)
+		if !strings.Contains(t, "<x") {
+			if r.Try(t) {
+				n++
+			}
+			continue
+		}
+		for x := 0; x < 64; x++ {
+			for y := 0; y < 64; y++ {
+				name := strings.NewReplacer(
+					"<xx>", fmt.Sprintf("%02d", x), "<yy>", fmt.Sprintf("%02d", y),
+					"<x>", fmt.Sprint(x), "<y>", fmt.Sprint(y),
+				).Replace(t)
+				if r.Try(name) {
+					n++
+				}
+			}
+		}
+	}
+	return n
+}
+
+// TryMD5Translate tries the minimap textures named by an
+// md5translate.trs file: both the hashed names and the plain
+// per-map names they stand for. Map directories listed in the file are
+// also tried with TryMap.
+func (r *Recovery) TryMD5Translate(data []byte) int {
+	n := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if dir, ok := strings.CutPrefix(line, "dir: "); ok {
+			n += r.TryMap(strings.TrimSpace(dir))
+			continue
+		}
+
+		for _, f := range strings.Fields(line) {
+			if r.Try("Textures\\Minimap\\" + f) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// MapNames returns the map directories named by known files, for use
+// with TryMap.
+func (r *Recovery) MapNames() []string {
+	const prefix = "WORLD\\MAPS\\"
+
+	seen := make(map[string]bool)
+	var out []string
+	for _, fi := range r.m.List() {
+		upper := strings.ToUpper(fi.Name)
+		if !strings.HasPrefix(upper, prefix) {
+			continue
+		}
+		dir, _, ok := strings.Cut(fi.Name[len(prefix):], "\\")
+		if !ok || seen[strings.ToUpper(dir)] {
+			continue
+		}
+		seen[strings.ToUpper(dir)] = true
+		out = append(out, dir)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Remaining returns how many entries are still unnamed.
+func (r *Recovery) Remaining() int {
+	return len(r.pending)
+}
+
+// Found returns the recovered names, sorted.
+func (r *Recovery) Found() []string {
+	out := append([]string(nil), r.found...)
+	sort.Strings(out)
+	return out
+}
+
+// WriteListfile writes the recovered names as a CRLF-separated listfile.
+func (r *Recovery) WriteListfile(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, name := range r.Found() {
+		bw.WriteString(name)
+		bw.WriteString("\r\n")
+	}
+	return bw.Flush()
+}
+
+func nameHashes(name string) [2]uint32 {
+	return [2]uint32{
+		mpqHashString(name, MPQ_HASH_NAME_A),
+		mpqHashString(name, MPQ_HASH_NAME_B),
+	}
+}
@@ -0,0 +1,145 @@
+package mpq
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRecoverySpecialNames(t *testing.T) {
+	// Only (listfile) is listed; (attributes) and (signature) are known
+	// without it
+	m := openStorm(t, "storm-signed.mpq")
+	if n := m.NewRecovery().Remaining(); n != 0 {
+		t.Errorf("%d unnamed entries, want 0", n)
+	}
+
+	m = openStorm(t, "storm-unnamed.mpq")
+	if n := m.NewRecovery().Remaining(); n != 5 {
+		t.Errorf("%d unnamed entries, want 5", n)
+	}
+}
+
+func TestRecoverySkipsFreedBlocks(t *testing.T) {
+	m := openStorm(t, "storm-unnamed.mpq")
+	h, ok := m.lookup("readme.txt")
+	if !ok {
+		t.Fatal("no readme.txt")
+	}
+	m.blockTable[h.BlockIdx].Flags &^= MPQ_FILE_EXISTS
+
+	r := m.NewRecovery()
+	if n := r.Remaining(); n != 4 {
+		t.Errorf("%d unnamed entries, want 4", n)
+	}
+	if r.Try("readme.txt") {
+		t.Error("recovered the name of a freed block")
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	m := openStorm(t, "storm-unnamed.mpq")
+	r := m.NewRecovery()
+
+	if r.Try("nonexistent.txt") {
+		t.Error("matched a name the archive lacks")
+	}
+	n, err := r.TryReader(strings.NewReader("readme.txt\r\nDBFilesClient/Map.dbc;other.txt\n"))
+	if err != nil || n != 2 {
+		t.Errorf("TryReader: %d, %v", n, err)
+	}
+	if r.Try("readme.txt") {
+		t.Error("matched readme.txt twice")
+	}
+	if n := r.TryMap("Azeroth"); n != 1 {
+		t.Errorf("TryMap: %d recovered, want 1", n)
+	}
+	if n := r.TryMD5Translate([]byte("dir: Kalimdor\r\n0123456789abcdef0123456789abcdef.blp\tAzeroth\\map30_31.blp\r\n")); n != 1 {
+		t.Errorf("TryMD5Translate: %d recovered, want 1", n)
+	}
+	if n := r.Remaining(); n != 1 {
+		t.Errorf("%d still unnamed, want 1", n)
+	}
+
+	want := []string{
+		"DBFilesClient\\Map.dbc",
+		"Textures\\Minimap\\0123456789abcdef0123456789abcdef.blp",
+		"World\\Maps\\Azeroth\\Azeroth_30_31.adt",
+		"readme.txt",
+	}
+	if got := r.Found(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("Found = %q", got)
+	}
+	if got := r.MapNames(); len(got) != 1 || got[0] != "Azeroth" {
+		t.Errorf("MapNames = %q", got)
+	}
+
+	// Recovered names become readable and listed
+	if data, err := m.ReadFile("readme.txt"); err != nil || !bytes.Equal(data, stormText("readme.txt", 5)) {
+		t.Errorf("readme.txt: %v", err)
+	}
+	if got := len(m.List()); got != 4 {
+		t.Errorf("listed %d files, want 4", got)
+	}
+
+	var buf bytes.Buffer
+	if err := r.WriteListfile(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != strings.Join(want, "\r\n")+"\r\n" {
+		t.Errorf("listfile %q", buf.String())
+	}
+}
+
+func TestUnnamedBlocks(t *testing.T) {
+	if got := openStorm(t, "storm-signed.mpq").UnnamedBlocks(); len(got) != 0 {
+		t.Errorf("signed archive: unnamed blocks %v, want none", got)
+	}
+
+	m := openStorm(t, "storm-unnamed.mpq")
+	blocks := m.UnnamedBlocks()
+	if len(blocks) != 5 {
+		t.Fatalf("unnamed blocks %v, want 5", blocks)
+	}
+
+	h, _ := m.lookup("readme.txt")
+	m.blockTable[h.BlockIdx].Flags &^= MPQ_FILE_EXISTS
+	for _, idx := range m.UnnamedBlocks() {
+		if idx == h.BlockIdx {
+			t.Error("freed block listed")
+		}
+	}
+	if _, err := m.ReadBlock(h.BlockIdx); err == nil {
+		t.Error("ReadBlock of a freed block: no error")
+	}
+}
+
+func TestReadBlockRecoversKeys(t *testing.T) {
+	m := openStorm(t, "storm-unnamed.mpq")
+	for _, tc := range []struct {
+		name  string
+		text  string
+		lines int
+	}{
+		{"readme.txt", "readme.txt", 5},
+		{"DBFilesClient\\Map.dbc", "Map.dbc", 500},
+		// Keyed by block position as well as name
+		{"Interface\\secret.lua", "secret.lua", 500},
+	} {
+		h, ok := m.lookup(tc.name)
+		if !ok {
+			t.Fatalf("no %s", tc.name)
+		}
+		got, err := m.ReadBlock(h.BlockIdx)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !bytes.Equal(got, stormText(tc.text, tc.lines)) {
+			t.Errorf("%s: content differs", tc.name)
+		}
+	}
+	if _, err := m.ReadBlock(uint32(len(m.blockTable))); err == nil {
+		t.Error("out-of-range block: no error")
+	}
+}
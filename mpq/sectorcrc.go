@@ -0,0 +1,134 @@
+package mpq
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+)
+
+/* =========================
+   Sector checksums
+   ========================= */
+
+// SectorCRCMode selects how an archive treats MPQ_FILE_SECTOR_CRC
+// checksums.
+type SectorCRCMode int
+
+const (
+	SectorCRCWarn   SectorCRCMode = iota // report mismatches and keep reading
+	SectorCRCStrict                      // fail the read on a mismatch
+	SectorCRCOff                         // don't load or check checksums
+)
+
+// SectorCRCError reports a sector whose stored data does not match its
+// ADLER32 checksum.
+type SectorCRCError struct {
+	File   string
+	Sector uint32
+	Want   uint32
+	Got    uint32
+}
+
+func (e *SectorCRCError) Error() string {
+	return fmt.Sprintf("%s: sector %d checksum mismatch (want %08X, got %08X)", e.File, e.Sector, e.Want, e.Got)
+}
+
+// SetSectorCRCMode sets how sector checksums are handled on later reads.
+// The default is SectorCRCWarn.
+func (m *MPQ) SetSectorCRCMode(mode SectorCRCMode) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.crcMode = mode
+}
+
+// SetSectorCRCHandler sets a function called with each checksum mismatch
+// read in SectorCRCWarn mode. While it is nil, mismatches are logged
+// with the standard logger.
+func (m *MPQ) SetSectorCRCHandler(fn func(*SectorCRCError)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.crcHandler = fn
+}
+
+func (m *MPQ) sectorCRCMode() SectorCRCMode {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.crcMode
+}
+
+// readSectorChecksums loads the checksum block that follows the last
+// sector. Its bounds are the extra entries at the end of the sector
+// offset table; it is zlib compressed when smaller than one DWORD per
+// sector.
+func (m *MPQ) readSectorChecksums(offset int64, offsets []uint32, sectorCount uint32) ([]uint32, error) {
+	start, end := offsets[sectorCount], offsets[sectorCount+1]
+	if end <= start {
+		return nil, nil
+	}
+
+	full := sectorCount * 4
+	if end-start > full {
+		return nil, corrupt("bad checksum block size")
+	}
+
+	raw := make([]byte, end-start)
+	if err := m.readAt(raw, offset+int64(start)); err != nil {
+		return nil, err
+	}
+	if uint32(len(raw)) < full {
+		data, err := decompress(raw, full)
+		if err != nil {
+			return nil, err
+		}
+		raw = data
+	}
+
+	sums := make([]uint32, len(raw)/4)
+	for i := range sums {
+		sums[i] = binary.LittleEndian.Uint32(raw[i*4:])
+	}
+	return sums, nil
+}
+
+// checkSector compares the stored (decrypted, still compressed) sector
+// data against its checksum.
+func (m *MPQ) checkSector(name string, idx uint32, sector []byte, want uint32) error {
+	got := sectorAdler32(sector)
+	if got == want {
+		return nil
+	}
+
+	err := &SectorCRCError{File: name, Sector: idx, Want: want, Got: got}
+
+	m.mu.RLock()
+	mode, handler := m.crcMode, m.crcHandler
+	m.mu.RUnlock()
+
+	if mode == SectorCRCStrict {
+		return err
+	}
+	if handler != nil {
+		handler(err)
+	} else {
+		log.Println("mpq:", err)
+	}
+	return nil
+}
+
+// sectorAdler32 is ADLER32 as Storm computes it, seeded with 0 instead
+// of the usual 1.
+func sectorAdler32(data []byte) uint32 {
+	const mod = 65521
+	var a, b uint32
+	for len(data) > 0 {
+		n := min(len(data), 5552)
+		for _, c := range data[:n] {
+			a += uint32(c)
+			b += a
+		}
+		a %= mod
+		b %= mod
+		data = data[n:]
+	}
+	return b<<16 | a
+}
@@ -0,0 +1,112 @@
+package mpq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestSectorAdler32(t *testing.T) {
+	// Seeded with 0, so empty data sums to 0 rather than 1
+	tests := []struct {
+		in   string
+		want uint32
+	}{
+		{"", 0x00000000},
+		{"a", 0x00610061},
+		{"abc", 0x024A0126},
+	}
+	for _, tt := range tests {
+		if got := sectorAdler32([]byte(tt.in)); got != tt.want {
+			t.Errorf("%q: got %08X, want %08X", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCheckSector(t *testing.T) {
+	sector := []byte("abc")
+	m := &MPQ{}
+
+	if err := m.checkSector("f", 0, sector, 0x024A0126); err != nil {
+		t.Fatalf("matching checksum: %v", err)
+	}
+
+	// Warn mode reports through the handler and keeps reading
+	var got []*SectorCRCError
+	m.SetSectorCRCHandler(func(e *SectorCRCError) { got = append(got, e) })
+	if err := m.checkSector("f", 3, sector, 1); err != nil {
+		t.Fatalf("warn mode: %v", err)
+	}
+	if len(got) != 1 || got[0].Sector != 3 || got[0].Want != 1 || got[0].Got != 0x024A0126 {
+		t.Errorf("handler got %v", got)
+	}
+
+	m.SetSectorCRCMode(SectorCRCStrict)
+	var crcErr *SectorCRCError
+	if err := m.checkSector("f", 3, sector, 1); !errors.As(err, &crcErr) {
+		t.Errorf("strict mode: got %v, want a *SectorCRCError", err)
+	}
+	if len(got) != 1 {
+		t.Error("strict mode called the handler")
+	}
+}
+
+func TestCheckSectorLogsWithoutHandler(t *testing.T) {
+	var buf bytes.Buffer
+	prev := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(prev)
+
+	m := &MPQ{}
+	if err := m.checkSector("f", 3, []byte("abc"), 1); err != nil {
+		t.Fatalf("warn mode: %v", err)
+	}
+	if !strings.Contains(buf.String(), "sector 3") {
+		t.Errorf("log output %q", buf.String())
+	}
+}
+
+// crc.txt in storm.mpq carries sector checksums: three sectors, so the
+// checksum block is the 12 bytes after the last one, stored uncompressed.
+func TestReadSectorCRC(t *testing.T) {
+	want := stormText("crc.txt", 500)
+	if got, err := openStorm(t, "storm.mpq").ReadFile("crc.txt"); err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("intact: %v", err)
+	}
+
+	bad := corruptStorm(t, "storm.mpq", func(m *MPQ, raw []byte) {
+		h, _ := m.lookup("crc.txt")
+		off := m.blockOffset(h.BlockIdx)
+		start := binary.LittleEndian.Uint32(raw[off+3*4:])
+		end := binary.LittleEndian.Uint32(raw[off+4*4:])
+		if end-start != 12 {
+			t.Fatalf("checksum block is %d bytes", end-start)
+		}
+		raw[off+int64(start)] ^= 0xFF
+	})
+
+	var got []*SectorCRCError
+	bad.SetSectorCRCHandler(func(e *SectorCRCError) { got = append(got, e) })
+	data, err := bad.ReadFile("crc.txt")
+	if err != nil || !bytes.Equal(data, want) {
+		t.Fatalf("warn mode: %v", err)
+	}
+	if len(got) != 1 || got[0].File != "crc.txt" || got[0].Sector != 0 {
+		t.Errorf("handler got %v", got)
+	}
+
+	bad.SetSectorCRCMode(SectorCRCStrict)
+	var crcErr *SectorCRCError
+	if _, err := bad.ReadFile("crc.txt"); !errors.As(err, &crcErr) {
+		t.Errorf("strict mode: got %v, want a *SectorCRCError", err)
+	}
+
+	bad.SetSectorCRCMode(SectorCRCOff)
+	got = nil
+	if _, err := bad.ReadFile("crc.txt"); err != nil || len(got) != 0 {
+		t.Errorf("off: %v, handler got %v", err, got)
+	}
+}
@@ -0,0 +1,364 @@
+package mpq
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+/* =========================
+   Signatures
+   ========================= */
+
+// Archives may carry a weak signature, a 512-bit RSA signature of the
+// archive's MD5 stored in the "(signature)" file, and/or a strong
+// signature, a 2048-bit RSA signature of its SHA-1 appended after the
+// archive as "NGIS" + 256 bytes. Both are stored little-endian.
+
+const (
+	weakSignatureFileSize = 72
+	weakSignatureSize     = 64
+	strongSignatureID     = 0x5349474E // 'NGIS'
+	strongSignatureSize   = 256
+)
+
+// WeakSignatureKey is Blizzard's public key for weak signatures.
+var WeakSignatureKey = mustParseKey(
+	"MFwwDQYJKoZIhvcNAQEBBQADSwAwSAJBAJJidwS/uILMBSO5DLGsBFknIXWWjQJe" +
+		"2kfdfEk3G/j66w4KkhZ1V61Rt4zLaMVCYpDun7FLwRjkMDSepO1q2DcCAwEAAQ==",
+)
+
+// strongSignatureKeys are tried in turn against strong signatures. They
+// start out with the public keys StormLib publishes; AddStrongSignatureKey
+// adds more and is safe while archives are verified.
+var strongSignatureKeys = []*rsa.PublicKey{
+	// Blizzard's key for game archives (no tail)
+	mustParseKey(
+		"MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEAsQZ+ziT2h8h+J/iMQpgd" +
+			"tH1HaJzOBE3agjU4yMPcrixaPOZoA4t8bwfey7qczfWywocYo3pleytFF+IuD4HD" +
+			"Fl9OXN1SFyupSgMx1EGZlgbFAomnbq9MQJyMqQtMhRAjFgg4TndS7YNb+JMSAEKp" +
+			"kXNqY28n/EVBHD5TsMuVCL579gIenbr61dI92DDEdy790IzIG0VKWLh/KOTcTJfm" +
+			"Ds/7HQTkGouVW+WUsfekuqNQo7ND9DBnhLjLjptxeFE2AZqYcA1ao3S9LN3GL1tW" +
+			"lVXFIX9c7fWqaVTQlZ2oNsI/ARVApOK3grNgqvwH6YoVYVXjNJEo5sQJsPsdV/hk" +
+			"dwIDAQAB",
+	),
+	// Warcraft III maps (file name tail)
+	mustParseKey(
+		"MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEA1BwklUUQ3UvjizOBRoF5" +
+			"yyOVc7KD+oGOQH5i6eUk1yfs0luCC70kNucNrfqhmviywVtahRse1JtXCPrx2bd3" +
+			"iN8Dx91fbkxjYIOGTsjYoHKTp0BbaFkJih776fcHgnFSb+7mJcDuJVvJOXxEH6w0" +
+			"1vo6VtujCqj1arqbyoal+xtAaczF3us5cOEp45sR1zAWTn1+7omN7VWV4QqJPaDS" +
+			"gBSESc0l1grO0i1VUSumayk7yBKIkb+LBvcG6WnYZHCi7VdLmaxER5m8oZfER66b" +
+			"heHoiSQIZf9PAY6Guw2DT5BTc54j/AaLQAKf2qcRSgQLVo5kQaddF3rCpsXoB/74" +
+			"6QIDAQAB",
+	),
+	// WoW patch archives ("ARCHIVE" tail)
+	mustParseKey(
+		"MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEAwOsMV0LagAWPEtEQM6b9" +
+			"6FHFkUyGbbyda2/Dfc9dyl21E9QvX+Yw7qKRMAKPzA2TlQQLZKvXpnKXF/YIK5xa" +
+			"5uwg9CEHCEAYolLG4xn0FUOE0E/0PuuytI0p0ICe6rk00PifZzTr8na2wI/l/GnQ" +
+			"bvnIVF1ck6cslATpQJ5JJVMXzoFlUABS19WESw4MXuJAS3AbMhxNWdEhVv7eO51c" +
+			"yGjRLy9QjogZODZTY0fSEksgBqQxNCoYVJYI/sF5K2flDsGqrIp0OdJ6teJlzg1Y" +
+			"UjYnb6bKjlidXoHEXI2TgA/mD6O3XFIt08I9s3crOCTgICq7cgX35qrZiIVWZdRv" +
+			"TwIDAQAB",
+	),
+	// WoW survey archives (no tail)
+	mustParseKey(
+		"MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEAnIt1DR6nRyyKsy2qahHe" +
+			"MKLtacatn/KxieHcwH87wLBxKy+jZ0gycTmJ7SaTdBAEMDs/V5IPIXEtoqYnid2c" +
+			"63TmfGDU92oc3Ph1PWUZ2PWxBhT06HYxRdbrgHw9/I29pNPi/607x+lzPORITOgU" +
+			"BR6MR8au8HsQP4bn4vkJNgnSgojh48/XQOB/cAln7As1neP61NmVimoLR4Bwi3zt" +
+			"zfgrZaUpyeNCUrOYJmH09YIjbBySTtXOUidoPHjFrMsCWpr6xs8xbETbs7MJFL6a" +
+			"vcUfTT67qfIZ9RsuKfnXJTIrV0kwDSjjuNXiPTmWAehSsiHIsrUXX5RNcwsSjClr" +
+			"nQIDAQAB",
+	),
+	// StarCraft II maps (no tail)
+	mustParseKey(
+		"MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEAmk4GT8zb+ICC25a17KZB" +
+			"q/ygKGJ2VSO6IT5PGHJlm1KfnHBA4B6SH3xMlJ4c6eG2k7QevZv+FOhjsAHubyWq" +
+			"2VKqWbrIFKv2ILc2RfMn8J9EDVRxvcxh6slRrVL69D0w1tfVGjMiKq2Fym5yGoRT" +
+			"E7CRgDqbAbXP9LBsCNWHiJLwfxMGzHbk8pIl9oia5pvM7ofZamSHchxlpy6xa4GJ" +
+			"7xKN01YCNvklTL1D7uol3wkwcHc7vrF8QwuJizuA5bSg4poEGtH62BZOYi+UL/z0" +
+			"31YK+k9CbQyM0X0pJoJoYz1TK+Y5J7vBnXCZtfcTYQ/ZzN6UcxTa57dJaiOlCh9z" +
+			"nQIDAQAB",
+	),
+}
+
+var strongKeysMu sync.RWMutex
+
+// AddStrongSignatureKey registers a PEM or base64 DER encoded public key
+// for strong signature checks. Adding a key twice has no effect.
+func AddStrongSignatureKey(encoded []byte) error {
+	key, err := parseKey(encoded)
+	if err != nil {
+		return err
+	}
+
+	strongKeysMu.Lock()
+	defer strongKeysMu.Unlock()
+	for _, k := range strongSignatureKeys {
+		if k.Equal(key) {
+			return nil
+		}
+	}
+	strongSignatureKeys = append(strongSignatureKeys, key)
+	return nil
+}
+
+func strongKeys() []*rsa.PublicKey {
+	strongKeysMu.RLock()
+	defer strongKeysMu.RUnlock()
+	return strongSignatureKeys
+}
+
+// SignatureStatus is the outcome of checking one signature of an
+// archive.
+type SignatureStatus int
+
+const (
+	SignatureNone       SignatureStatus = iota // not signed
+	SignatureValid                             // signed and intact
+	SignatureInvalid                           // signed, but the data was modified
+	SignatureUnknownKey                        // strong signature, but no key to check it
+)
+
+func (s SignatureStatus) String() string {
+	switch s {
+	case SignatureNone:
+		return "unsigned"
+	case SignatureValid:
+		return "valid"
+	case SignatureInvalid:
+		return "invalid"
+	case SignatureUnknownKey:
+		return "unknown key"
+	}
+	return fmt.Sprintf("SignatureStatus(%d)", int(s))
+}
+
+// SignatureResult reports each signature of an archive.
+type SignatureResult struct {
+	Weak   SignatureStatus
+	Strong SignatureStatus
+}
+
+// Official reports whether the archive is signed and every signature
+// present checks out.
+func (r SignatureResult) Official() bool {
+	return !r.Tampered() && (r.Weak == SignatureValid || r.Strong == SignatureValid)
+}
+
+// Tampered reports whether any signature failed.
+func (r SignatureResult) Tampered() bool {
+	return r.Weak == SignatureInvalid || r.Strong == SignatureInvalid
+}
+
+// Unsigned reports whether the archive carries no signature.
+func (r SignatureResult) Unsigned() bool {
+	return r.Weak == SignatureNone && r.Strong == SignatureNone
+}
+
+// VerifySignature checks the weak and strong signatures of the archive.
+func (m *MPQ) VerifySignature() (SignatureResult, error) {
+	var res SignatureResult
+	var err error
+
+	if res.Weak, err = m.verifyWeakSignature(); err != nil {
+		return res, err
+	}
+	if res.Strong, err = m.verifyStrongSignature(); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// archiveEnd returns the absolute position just past the archive.
+func (m *MPQ) archiveEnd() int64 {
+	size := int64(m.header.ArchiveSize)
+	if m.header.FormatVersion >= 2 && m.header.ArchiveSize64 != 0 {
+		size = int64(m.header.ArchiveSize64)
+	}
+	return m.archivePos + size
+}
+
+// verifyWeakSignature hashes the archive with the "(signature)" block
+// read as zeros and checks the stored signature against the digest.
+func (m *MPQ) verifyWeakSignature() (SignatureStatus, error) {
+	h, ok := m.lookupLocale("(signature)", LocaleNeutral)
+	if !ok || int(h.BlockIdx) >= len(m.blockTable) {
+		return SignatureNone, nil
+	}
+
+	sig, err := m.readBlock("(signature)", h.BlockIdx)
+	if err != nil {
+		return SignatureNone, err
+	}
+	if len(sig) != weakSignatureFileSize {
+		return SignatureInvalid, nil
+	}
+
+	start := m.blockOffset(h.BlockIdx)
+	exclude := [2]int64{start, start + int64(m.blockTable[h.BlockIdx].CompressedSize)}
+
+	digest := md5.New()
+	if err := m.hashRange(digest, m.archivePos, m.archiveEnd(), exclude); err != nil {
+		return SignatureNone, err
+	}
+
+	s := reverse(sig[8 : 8+weakSignatureSize])
+	em := rsaEncrypt(WeakSignatureKey, s)
+
+	want := append([]byte{0x00, 0x01}, bytes.Repeat([]byte{0xFF}, len(em)-3-len(md5DigestInfo)-md5.Size)...)
+	want = append(want, 0x00)
+	want = append(want, md5DigestInfo...)
+	want = digest.Sum(want)
+
+	if !bytes.Equal(em, want) {
+		return SignatureInvalid, nil
+	}
+	return SignatureValid, nil
+}
+
+// md5DigestInfo is the DER prefix PKCS #1 v1.5 puts before an MD5 digest.
+var md5DigestInfo = []byte{
+	0x30, 0x20, 0x30, 0x0C, 0x06, 0x08, 0x2A, 0x86, 0x48, 0x86,
+	0xF7, 0x0D, 0x02, 0x05, 0x05, 0x00, 0x04, 0x10,
+}
+
+// verifyStrongSignature checks the "NGIS" block after the archive. The
+// signed SHA-1 covers the file from the start of the archive (or its
+// user data) to the signature, optionally followed by a tail; the tails
+// Blizzard used are tried in turn.
+func (m *MPQ) verifyStrongSignature() (SignatureStatus, error) {
+	end := m.archiveEnd()
+
+	buf := make([]byte, 4+strongSignatureSize)
+	n, err := m.r.ReadAt(buf, end)
+	if err != nil && err != io.EOF {
+		return SignatureNone, err
+	}
+	if n < len(buf) || binary.LittleEndian.Uint32(buf) != strongSignatureID {
+		return SignatureNone, nil
+	}
+	keys := strongKeys()
+	if len(keys) == 0 {
+		return SignatureUnknownKey, nil
+	}
+
+	start := m.archivePos
+	if m.userData != nil {
+		start = m.userDataPos
+	}
+
+	digest := sha1.New()
+	if err := m.hashRange(digest, start, end, [2]int64{}); err != nil {
+		return SignatureNone, err
+	}
+
+	name := filepath.Base(m.path)
+	tails := []string{"", name, strings.ToUpper(name), "ARCHIVE"}
+
+	s := reverse(buf[4:])
+	for _, key := range keys {
+		em := rsaEncrypt(key, s)
+		for _, tail := range tails {
+			d := cloneSHA1(digest, tail)
+
+			want := append([]byte{0x0B}, bytes.Repeat([]byte{0xBB}, len(em)-1-sha1.Size)...)
+			want = append(want, reverse(d)...)
+			if bytes.Equal(em, want) {
+				return SignatureValid, nil
+			}
+		}
+	}
+	return SignatureInvalid, nil
+}
+
+// hashRange feeds [start, end) of the file into h, with the exclude
+// range read as zeros.
+func (m *MPQ) hashRange(h hash.Hash, start, end int64, exclude [2]int64) error {
+	buf := make([]byte, 64*1024)
+	for pos := start; pos < end; {
+		n := int(min(int64(len(buf)), end-pos))
+		if err := m.readAt(buf[:n], pos); err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if p := pos + int64(i); p >= exclude[0] && p < exclude[1] {
+				buf[i] = 0
+			}
+		}
+		h.Write(buf[:n])
+		pos += int64(n)
+	}
+	return nil
+}
+
+// cloneSHA1 returns the SHA-1 of everything written to h plus tail,
+// leaving h untouched.
+func cloneSHA1(h hash.Hash, tail string) []byte {
+	state, _ := h.(encoding.BinaryMarshaler).MarshalBinary()
+	c := sha1.New()
+	c.(encoding.BinaryUnmarshaler).UnmarshalBinary(state)
+	c.Write([]byte(tail))
+	return c.Sum(nil)
+}
+
+// rsaEncrypt applies the public key to a big-endian signature, returning
+// the encoded message padded to the key size. It is done by hand because
+// crypto/rsa refuses keys as small as the weak signature's.
+func rsaEncrypt(key *rsa.PublicKey, sig []byte) []byte {
+	c := new(big.Int).SetBytes(sig)
+	c.Exp(c, big.NewInt(int64(key.E)), key.N)
+	return c.FillBytes(make([]byte, (key.N.BitLen()+7)/8))
+}
+
+func reverse(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i, v := range b {
+		out[len(b)-1-i] = v
+	}
+	return out
+}
+
+func parseKey(encoded []byte) (*rsa.PublicKey, error) {
+	s := strings.TrimSpace(string(encoded))
+	s = strings.TrimPrefix(s, "-----BEGIN PUBLIC KEY-----")
+	s = strings.TrimSuffix(s, "-----END PUBLIC KEY-----")
+	s = strings.Join(strings.Fields(s), "")
+
+	der, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an RSA public key")
+	}
+	return rsaKey, nil
+}
+
+func mustParseKey(encoded string) *rsa.PublicKey {
+	key, err := parseKey([]byte(encoded))
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
@@ -0,0 +1,185 @@
+package mpq
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withStrongKeys replaces the strong key list for the rest of the test.
+func withStrongKeys(t *testing.T, keys ...*rsa.PublicKey) {
+	strongKeysMu.Lock()
+	prev := strongSignatureKeys
+	strongSignatureKeys = keys
+	strongKeysMu.Unlock()
+
+	t.Cleanup(func() {
+		strongKeysMu.Lock()
+		strongSignatureKeys = prev
+		strongKeysMu.Unlock()
+	})
+}
+
+func TestAddStrongSignatureKey(t *testing.T) {
+	withStrongKeys(t)
+
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same key as PEM and as bare base64 DER
+	encodings := [][]byte{
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}),
+		[]byte(base64.StdEncoding.EncodeToString(der)),
+	}
+	for _, enc := range encodings {
+		if err := AddStrongSignatureKey(enc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(strongKeys()); n != 1 {
+		t.Errorf("got %d keys, want 1", n)
+	}
+
+	if err := AddStrongSignatureKey([]byte("not a key")); err == nil {
+		t.Error("expected an error for garbage input")
+	}
+}
+
+func TestBundledStrongKeys(t *testing.T) {
+	keys := strongKeys()
+	if len(keys) != 5 {
+		t.Fatalf("got %d bundled keys, want 5", len(keys))
+	}
+	for i, k := range keys {
+		if k.N.BitLen() != 2048 {
+			t.Errorf("key %d: %d bits", i, k.N.BitLen())
+		}
+	}
+}
+
+// writeSigned writes raw to a temporary file and opens it.
+func writeSigned(t *testing.T, name string, raw []byte) *MPQ {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func TestWeakSignature(t *testing.T) {
+	// Signed by StormLib with Blizzard's published weak private key
+	m := openStorm(t, "storm-signed.mpq")
+	res, err := m.VerifySignature()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Weak != SignatureValid || res.Strong != SignatureNone || !res.Official() {
+		t.Errorf("signed archive: %+v", res)
+	}
+
+	raw, err := os.ReadFile(filepath.Join("testdata", "storm-signed.mpq"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, _ := m.lookup("zlib.txt")
+	raw[m.blockOffset(h.BlockIdx)+20] ^= 0x01
+	res, err = writeSigned(t, "storm-signed.mpq", raw).VerifySignature()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Weak != SignatureInvalid || !res.Tampered() || res.Official() {
+		t.Errorf("modified archive: %+v", res)
+	}
+
+	res, err = openStorm(t, "storm.mpq").VerifySignature()
+	if err != nil || !res.Unsigned() {
+		t.Errorf("unsigned archive: %+v, %v", res, err)
+	}
+}
+
+// strongSign appends a strong signature over raw made with priv, using
+// the given SHA-1 tail.
+func strongSign(priv *rsa.PrivateKey, raw []byte, tail string) []byte {
+	d := sha1.Sum(append(append([]byte(nil), raw...), tail...))
+	em := append([]byte{0x0B}, bytes.Repeat([]byte{0xBB}, strongSignatureSize-1-sha1.Size)...)
+	em = append(em, reverse(d[:])...)
+
+	s := new(big.Int).Exp(new(big.Int).SetBytes(em), priv.D, priv.N)
+	sig := binary.LittleEndian.AppendUint32(nil, strongSignatureID)
+	sig = append(sig, reverse(s.FillBytes(make([]byte, strongSignatureSize)))...)
+	return append(append([]byte(nil), raw...), sig...)
+}
+
+func TestStrongSignature(t *testing.T) {
+	raw, err := os.ReadFile(filepath.Join("testdata", "storm.mpq"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withStrongKeys(t, strongKeys()...)
+	der, _ := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err := AddStrongSignatureKey([]byte(base64.StdEncoding.EncodeToString(der))); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tail := range []string{"", "ARCHIVE", "STORM.MPQ"} {
+		m := writeSigned(t, "storm.mpq", strongSign(priv, raw, tail))
+		res, err := m.VerifySignature()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Strong != SignatureValid || res.Weak != SignatureNone || !res.Official() {
+			t.Errorf("tail %q: %+v", tail, res)
+		}
+	}
+
+	signed := strongSign(priv, raw, "")
+	signed[100] ^= 0x01
+	res, err := writeSigned(t, "storm.mpq", signed).VerifySignature()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Strong != SignatureInvalid || !res.Tampered() {
+		t.Errorf("modified archive: %+v", res)
+	}
+
+	// A signature none of the keys made doesn't verify
+	withStrongKeys(t, strongKeys()[:5]...)
+	res, err = writeSigned(t, "storm.mpq", strongSign(priv, raw, "")).VerifySignature()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Strong != SignatureInvalid {
+		t.Errorf("unknown signer: %+v", res)
+	}
+
+	withStrongKeys(t)
+	res, err = writeSigned(t, "storm.mpq", strongSign(priv, raw, "")).VerifySignature()
+	if err != nil || res.Strong != SignatureUnknownKey {
+		t.Errorf("no keys: %+v, %v", res, err)
+	}
+}
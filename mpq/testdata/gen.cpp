@@ -0,0 +1,169 @@
+// gen builds the MPQ test fixtures in this directory with StormLib, so
+// the reader is checked against archives and sectors it did not write.
+//
+//   g++ -I $STORMLIB/src gen.cpp $STORMLIB/build/libstorm.a -o gen
+//   mkdir -p sectors && ./gen .
+//
+// StormLib only allows MPQ_FILE_SINGLE_UNIT from format version 2 on;
+// GetValidFileFlags in SFileCreateArchive.cpp was changed to always
+// return MPQ_FILE_VALID_FLAGS so storm.mpq carries single-unit files the
+// way WoW 1.x archives do. Everything else is stock StormLib.
+//
+// sectors/NAME.bin is one compressed sector (mask byte first) and
+// sectors/NAME.out what SCompDecompress makes of it. storm-hetbet.mpq is
+// storm-v3.mpq with the hash and block table fields of the header
+// cleared, so only the HET and BET tables remain.
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <string>
+#include <vector>
+#include "StormLib.h"
+
+static std::string outdir;
+
+static std::string text(const char *name, int n) {
+    std::string s; char buf[256];
+    for (int i = 0; i < n; i++) { snprintf(buf, sizeof buf, "%s line %04d\n", name, i); s += buf; }
+    return s;
+}
+
+static void writeFile(const std::string &name, const void *p, size_t n) {
+    std::string path = outdir + "/" + name;
+    FILE *f = fopen(path.c_str(), "wb");
+    if (!f) { perror(path.c_str()); exit(1); }
+    fwrite(p, 1, n, f); fclose(f);
+}
+
+// wave makes 16-bit PCM that is not trivially predictable.
+static std::vector<unsigned char> wave(int samples, int channels) {
+    std::vector<unsigned char> out;
+    for (int i = 0; i < samples; i++) {
+        for (int c = 0; c < channels; c++) {
+            int v = ((i * (37 + c * 11)) % 4000) - 2000 + ((i / 7) % 300) * (c ? -3 : 3);
+            short s = (short)v;
+            out.push_back(s & 0xFF); out.push_back((s >> 8) & 0xFF);
+        }
+    }
+    return out;
+}
+
+// sector writes one compressed sector and what Storm decompresses it to.
+static void sector(const char *name, std::vector<unsigned char> in, unsigned mask, int cmpType, int level) {
+    std::vector<unsigned char> out(in.size() * 2 + 64);
+    int outLen = (int)out.size();
+    if (!SCompCompress(out.data(), &outLen, in.data(), (int)in.size(), mask, cmpType, level)) { fprintf(stderr, "compress %s failed\n", name); exit(1); }
+    if (out[0] != (unsigned char)mask) { fprintf(stderr, "%s: mask %02X, want %02X\n", name, out[0], mask); exit(1); }
+    std::vector<unsigned char> dec(in.size());
+    int decLen = (int)dec.size();
+    if (!(mask == MPQ_COMPRESSION_LZMA ? SCompDecompress2 : SCompDecompress)(dec.data(), &decLen, out.data(), outLen)) { fprintf(stderr, "decompress %s failed\n", name); exit(1); }
+    writeFile(std::string("sectors/") + name + ".bin", out.data(), outLen);
+    writeFile(std::string("sectors/") + name + ".out", dec.data(), decLen);
+}
+
+static void add(HANDLE h, const char *name, const std::string &data, DWORD flags, DWORD cmp, LCID locale = 0) {
+    HANDLE f;
+    if (!SFileCreateFile(h, name, 0x01D0000000000000ULL, (DWORD)data.size(), locale, flags, &f)) { fprintf(stderr, "create %s: %d\n", name, SErrGetLastError()); exit(1); }
+    if (!data.empty() && !SFileWriteFile(f, data.data(), (DWORD)data.size(), cmp)) { fprintf(stderr, "write %s: %d\n", name, SErrGetLastError()); exit(1); }
+    if (!SFileFinishFile(f)) { fprintf(stderr, "finish %s: %d\n", name, SErrGetLastError()); exit(1); }
+}
+
+static HANDLE create(const char *name, DWORD version, bool listfile, bool attrs, DWORD maxFiles) {
+    std::string path = outdir + "/" + name;
+    remove(path.c_str());
+    SFILE_CREATE_MPQ ci;
+    memset(&ci, 0, sizeof ci);
+    ci.cbSize = sizeof ci;
+    ci.dwMpqVersion = version;
+    ci.dwFileFlags1 = listfile ? MPQ_FILE_DEFAULT_INTERNAL : 0;
+    ci.dwFileFlags2 = attrs ? MPQ_FILE_DEFAULT_INTERNAL : 0;
+    ci.dwAttrFlags = attrs ? (MPQ_ATTRIBUTE_CRC32 | MPQ_ATTRIBUTE_FILETIME | MPQ_ATTRIBUTE_MD5) : 0;
+    ci.dwSectorSize = 0x1000;
+    ci.dwRawChunkSize = version >= MPQ_FORMAT_VERSION_4 ? 0x4000 : 0;
+    ci.dwMaxFileCount = maxFiles;
+    HANDLE h;
+    if (!SFileCreateArchive2(path.c_str(), &ci, &h)) { fprintf(stderr, "create archive %s: %d\n", name, SErrGetLastError()); exit(1); }
+    return h;
+}
+
+int main(int argc, char **argv) {
+    outdir = argv[1];
+
+    // Raw sectors
+    std::string t = text("sector", 200);
+    std::vector<unsigned char> tv(t.begin(), t.end());
+    std::vector<unsigned char> sparse(tv);
+    for (size_t i = 0; i < sparse.size(); i++) if ((i / 512) % 2 == 1) sparse[i] = 0;
+    sector("huffman", tv, MPQ_COMPRESSION_HUFFMANN, 0, 0);
+    sector("pkware", tv, MPQ_COMPRESSION_PKWARE, 0, 0);
+    sector("bzip2", tv, MPQ_COMPRESSION_BZIP2, 0, 0);
+    sector("lzma", tv, MPQ_COMPRESSION_LZMA, 0, 0);
+    sector("sparse", sparse, MPQ_COMPRESSION_SPARSE, 0, 0);
+    sector("sparse-zlib", sparse, MPQ_COMPRESSION_SPARSE | MPQ_COMPRESSION_ZLIB, 0, 0);
+    sector("sparse-bzip2", sparse, MPQ_COMPRESSION_SPARSE | MPQ_COMPRESSION_BZIP2, 0, 0);
+    sector("zlib-pkware", tv, MPQ_COMPRESSION_ZLIB | MPQ_COMPRESSION_PKWARE, 0, 0);
+    sector("adpcm-mono", wave(2048, 1), MPQ_COMPRESSION_ADPCM_MONO, 0, 0);
+    sector("adpcm-stereo", wave(1024, 2), MPQ_COMPRESSION_ADPCM_STEREO, 0, 0);
+    sector("adpcm-mono-huffman", wave(2048, 1), MPQ_COMPRESSION_ADPCM_MONO | MPQ_COMPRESSION_HUFFMANN, 0, 1);
+    sector("adpcm-stereo-huffman", wave(1024, 2), MPQ_COMPRESSION_ADPCM_STEREO | MPQ_COMPRESSION_HUFFMANN, 0, 3);
+
+    // Format version 1 archive with every file layout the reader handles
+    HANDLE h = create("storm.mpq", MPQ_FORMAT_VERSION_1, true, true, 32);
+    add(h, "implode.txt", text("implode.txt", 500), MPQ_FILE_IMPLODE, 0);
+    add(h, "implode-single.txt", text("implode-single.txt", 100), MPQ_FILE_IMPLODE | MPQ_FILE_SINGLE_UNIT, 0);
+    add(h, "pkware.txt", text("pkware.txt", 500), MPQ_FILE_COMPRESS, MPQ_COMPRESSION_PKWARE);
+    add(h, "bzip2.txt", text("bzip2.txt", 500), MPQ_FILE_COMPRESS, MPQ_COMPRESSION_BZIP2);
+    add(h, "lzma.txt", text("lzma.txt", 500), MPQ_FILE_COMPRESS, MPQ_COMPRESSION_LZMA);
+    add(h, "zlib.txt", text("zlib.txt", 500), MPQ_FILE_COMPRESS, MPQ_COMPRESSION_ZLIB);
+    add(h, "crc.txt", text("crc.txt", 500), MPQ_FILE_COMPRESS | MPQ_FILE_SECTOR_CRC, MPQ_COMPRESSION_ZLIB);
+    add(h, "encrypted.txt", text("encrypted.txt", 500), MPQ_FILE_COMPRESS | MPQ_FILE_ENCRYPTED, MPQ_COMPRESSION_ZLIB);
+    add(h, "fixkey.txt", text("fixkey.txt", 500), MPQ_FILE_COMPRESS | MPQ_FILE_ENCRYPTED | MPQ_FILE_KEY_V2, MPQ_COMPRESSION_ZLIB);
+    add(h, "single.txt", text("single.txt", 500), MPQ_FILE_COMPRESS | MPQ_FILE_SINGLE_UNIT, MPQ_COMPRESSION_ZLIB);
+    add(h, "stored.txt", text("stored.txt", 500), 0, 0);
+    add(h, "empty.txt", "", 0, 0);
+    add(h, "locale.txt", text("locale.txt", 10), MPQ_FILE_COMPRESS, MPQ_COMPRESSION_ZLIB, 0);
+    add(h, "locale.txt", text("locale.txt deDE", 10), MPQ_FILE_COMPRESS, MPQ_COMPRESSION_ZLIB, 0x407);
+    add(h, "locale.txt", text("locale.txt frFR", 10), MPQ_FILE_COMPRESS, MPQ_COMPRESSION_ZLIB, 0x40C);
+    add(h, "Textures\\Minimap\\Azeroth\\map30_31.blp", text("map30_31.blp", 5), MPQ_FILE_COMPRESS, MPQ_COMPRESSION_ZLIB);
+    add(h, "Textures\\Minimap\\Azeroth\\map30_32.blp", text("map30_32.blp", 5), MPQ_FILE_COMPRESS, MPQ_COMPRESSION_ZLIB);
+    add(h, "World\\Maps\\Azeroth\\Azeroth.wdt", text("Azeroth.wdt", 5), MPQ_FILE_COMPRESS, MPQ_COMPRESSION_ZLIB);
+    SFileCloseArchive(h);
+
+    // Later format versions
+    const char *names[] = {"storm-v2.mpq", "storm-v3.mpq", "storm-v4.mpq"};
+    DWORD versions[] = {MPQ_FORMAT_VERSION_2, MPQ_FORMAT_VERSION_3, MPQ_FORMAT_VERSION_4};
+    for (int i = 0; i < 3; i++) {
+        h = create(names[i], versions[i], true, true, 16);
+        add(h, "zlib.txt", text("zlib.txt", 500), MPQ_FILE_COMPRESS, MPQ_COMPRESSION_ZLIB);
+        add(h, "encrypted.txt", text("encrypted.txt", 500), MPQ_FILE_COMPRESS | MPQ_FILE_ENCRYPTED, MPQ_COMPRESSION_ZLIB);
+        add(h, "World\\Maps\\Azeroth\\Azeroth.wdt", text("Azeroth.wdt", 5), MPQ_FILE_COMPRESS, MPQ_COMPRESSION_ZLIB);
+        SFileCloseArchive(h);
+    }
+
+    // No listfile: names and keys have to be recovered
+    h = create("storm-unnamed.mpq", MPQ_FORMAT_VERSION_1, false, true, 16);
+    add(h, "World\\Maps\\Azeroth\\Azeroth_30_31.adt", text("Azeroth_30_31.adt", 50), MPQ_FILE_COMPRESS, MPQ_COMPRESSION_ZLIB);
+    add(h, "Textures\\Minimap\\0123456789abcdef0123456789abcdef.blp", text("minimap", 50), MPQ_FILE_COMPRESS, MPQ_COMPRESSION_ZLIB);
+    add(h, "DBFilesClient\\Map.dbc", text("Map.dbc", 500), MPQ_FILE_COMPRESS | MPQ_FILE_ENCRYPTED, MPQ_COMPRESSION_ZLIB);
+    add(h, "Interface\\secret.lua", text("secret.lua", 500), MPQ_FILE_COMPRESS | MPQ_FILE_ENCRYPTED | MPQ_FILE_KEY_V2, MPQ_COMPRESSION_ZLIB);
+    add(h, "readme.txt", text("readme.txt", 5), MPQ_FILE_COMPRESS, MPQ_COMPRESSION_ZLIB);
+    SFileCloseArchive(h);
+
+    // HET/BET only
+    {
+        std::string src = outdir + "/storm-v3.mpq", dst = outdir + "/storm-hetbet.mpq";
+        FILE *f = fopen(src.c_str(), "rb");
+        std::vector<unsigned char> b(1 << 20);
+        b.resize(fread(b.data(), 1, b.size(), f));
+        fclose(f);
+        memset(&b[16], 0, 16); // HashTablePos, BlockTablePos, HashTableSize, BlockTableSize
+        writeFile("storm-hetbet.mpq", b.data(), b.size());
+    }
+
+    // Weak signature
+    h = create("storm-signed.mpq", MPQ_FORMAT_VERSION_1, true, true, 16);
+    add(h, "zlib.txt", text("zlib.txt", 500), MPQ_FILE_COMPRESS, MPQ_COMPRESSION_ZLIB);
+    if (!SFileSignArchive(h, SIGNATURE_TYPE_WEAK)) { fprintf(stderr, "sign: %d\n", SErrGetLastError()); exit(1); }
+    SFileCloseArchive(h);
+    return 0;
+}
@@ -0,0 +1,526 @@
+package mpq
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+/* =========================
+   Writer
+   ========================= */
+
+// Writer builds format version 1 archives. Files are held in memory
+// until Save or WriteTo. A "(listfile)" and an "(attributes)" file are
+// generated on every write.
+type Writer struct {
+	sectorShift uint16
+	entries     []*writerEntry
+
+	// Hash table layout inherited from an edited archive that contains
+	// files of unknown name (see Edit)
+	baseHash []HashEntry
+}
+
+type writerEntry struct {
+	name     string // empty for carried-over files of unknown name
+	locale   uint16
+	platform uint16
+	hashA    uint32
+	hashB    uint32
+	slot     int // fixed hash slot for unnamed entries
+
+	// New content...
+	data  []byte
+	flags uint32
+
+	// ...or a block copied unchanged from the edited archive
+	raw   []byte
+	block BlockEntry
+
+	attrs FileAttributes
+}
+
+// writerFlags are the block flags Add accepts.
+const writerFlags = MPQ_FILE_COMPRESS | MPQ_FILE_ENCRYPTED | MPQ_FILE_FIX_KEY | MPQ_FILE_SINGLE_UNIT
+
+// NewWriter returns an empty archive writer using 4 KB sectors.
+func NewWriter() *Writer {
+	return &Writer{sectorShift: 3}
+}
+
+// Edit loads an existing archive into a Writer so files can be added,
+// replaced or deleted. Files are carried over as stored; files whose
+// name is unknown keep their hash table slot, so the hash table keeps
+// its size. The source archive is closed before Edit returns, so the
+// result may be saved over it.
+func Edit(path string) (*Writer, error) {
+	m, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+
+	if m.header.HashTableEntries == 0 {
+		return nil, fmt.Errorf("%w: editing archives without a hash table", ErrUnsupported)
+	}
+
+	w := &Writer{sectorShift: m.header.SectorSizeShift}
+
+	// Map name hashes back to names from the listfiles
+	type nameKey struct{ a, b uint32 }
+	names := make(map[nameKey]string)
+	for _, fi := range m.List() {
+		names[nameKey{mpqHashString(fi.Name, MPQ_HASH_NAME_A), mpqHashString(fi.Name, MPQ_HASH_NAME_B)}] = fi.Name
+	}
+	skip := make(map[nameKey]bool)
+	for _, n := range specialNames {
+		skip[nameKey{mpqHashString(n, MPQ_HASH_NAME_A), mpqHashString(n, MPQ_HASH_NAME_B)}] = true
+	}
+
+	// A missing "(attributes)" just means there are no checksums to carry
+	attrs, err := m.Attributes()
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	for slot, h := range m.hashTable {
+		if h.BlockIdx >= uint32(len(m.blockTable)) {
+			continue
+		}
+		k := nameKey{h.NameA, h.NameB}
+		if skip[k] {
+			continue
+		}
+		block := m.blockTable[h.BlockIdx]
+		if block.Flags&MPQ_FILE_EXISTS == 0 {
+			continue
+		}
+
+		e := &writerEntry{
+			name:     names[k],
+			locale:   h.Locale,
+			platform: h.Platform,
+			hashA:    h.NameA,
+			hashB:    h.NameB,
+			slot:     slot,
+		}
+		if int(h.BlockIdx) < len(attrs) {
+			e.attrs = attrs[h.BlockIdx]
+		}
+
+		// FIX_KEY files are keyed by position, so they can't be moved
+		// as stored
+		if block.Flags&MPQ_FILE_FIX_KEY != 0 {
+			if e.name == "" {
+				return nil, fmt.Errorf("mpq: block %d uses a position-dependent key and its name is unknown", h.BlockIdx)
+			}
+			data, err := m.readBlock(e.name, h.BlockIdx)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", e.name, err)
+			}
+			e.data = data
+			e.flags = block.Flags & (MPQ_FILE_ENCRYPTED | MPQ_FILE_FIX_KEY | MPQ_FILE_SINGLE_UNIT)
+			if block.Flags&MPQ_FILE_COMPRESS_MASK != 0 {
+				e.flags |= MPQ_FILE_COMPRESS
+			}
+			e.attrs = FileAttributes{}
+		} else {
+			if _, err := m.checkBlock(h.BlockIdx); err != nil {
+				return nil, fmt.Errorf("block %d: %w", h.BlockIdx, err)
+			}
+			e.raw = make([]byte, block.CompressedSize)
+			if err := m.readAt(e.raw, m.blockOffset(h.BlockIdx)); err != nil {
+				return nil, err
+			}
+			e.block = block
+		}
+
+		if e.name == "" && w.baseHash == nil {
+			w.baseHash = m.hashTable
+		}
+		w.entries = append(w.entries, e)
+	}
+
+	return w, nil
+}
+
+// Add stores data under name, replacing any file with the same name and
+// locale. flags may combine MPQ_FILE_COMPRESS (zlib), MPQ_FILE_ENCRYPTED,
+// MPQ_FILE_FIX_KEY and MPQ_FILE_SINGLE_UNIT.
+func (w *Writer) Add(name string, data []byte, flags uint32, locale uint16) error {
+	name = strings.ReplaceAll(name, "/", "\\")
+	if name == "" {
+		return errors.New("mpq: empty file name")
+	}
+	if flags&^writerFlags != 0 {
+		return fmt.Errorf("mpq: unsupported file flags 0x%08X", flags&^writerFlags)
+	}
+	if flags&MPQ_FILE_FIX_KEY != 0 && flags&MPQ_FILE_ENCRYPTED == 0 {
+		return errors.New("mpq: MPQ_FILE_FIX_KEY requires MPQ_FILE_ENCRYPTED")
+	}
+	if uint64(len(data)) > 0xFFFFFFFF {
+		return errors.New("mpq: file too large")
+	}
+
+	w.Delete(name, locale)
+	w.entries = append(w.entries, &writerEntry{
+		name:   name,
+		locale: locale,
+		hashA:  mpqHashString(name, MPQ_HASH_NAME_A),
+		hashB:  mpqHashString(name, MPQ_HASH_NAME_B),
+		slot:   -1,
+		data:   append([]byte(nil), data...),
+		flags:  flags,
+	})
+	return nil
+}
+
+// Delete removes the file with the given name and locale. It reports
+// whether a file was removed.
+func (w *Writer) Delete(name string, locale uint16) bool {
+	name = strings.ReplaceAll(name, "/", "\\")
+	hashA := mpqHashString(name, MPQ_HASH_NAME_A)
+	hashB := mpqHashString(name, MPQ_HASH_NAME_B)
+
+	for i, e := range w.entries {
+		if e.hashA == hashA && e.hashB == hashB && e.locale == locale {
+			w.entries = append(w.entries[:i], w.entries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Save writes the archive to path through a temporary file in the same
+// directory.
+func (w *Writer) Save(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".mpq-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if _, err := w.WriteTo(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// WriteTo writes the complete archive to dst.
+func (w *Writer) WriteTo(dst io.Writer) (int64, error) {
+	const headerSize = 32
+
+	entries := append([]*writerEntry(nil), w.entries...)
+
+	listfile := w.listfile()
+	entries = append(entries, &writerEntry{
+		name:  "(listfile)",
+		hashA: mpqHashString("(listfile)", MPQ_HASH_NAME_A),
+		hashB: mpqHashString("(listfile)", MPQ_HASH_NAME_B),
+		slot:  -1,
+		data:  listfile,
+		flags: MPQ_FILE_COMPRESS,
+	})
+	attrEntry := &writerEntry{
+		name:  "(attributes)",
+		hashA: mpqHashString("(attributes)", MPQ_HASH_NAME_A),
+		hashB: mpqHashString("(attributes)", MPQ_HASH_NAME_B),
+		slot:  -1,
+		flags: MPQ_FILE_COMPRESS,
+	}
+	entries = append(entries, attrEntry)
+
+	hashTable, err := w.hashTable(entries)
+	if err != nil {
+		return 0, err
+	}
+
+	now := timeToFileTime(time.Now())
+	body := make([]byte, headerSize)
+	blocks := make([]BlockEntry, len(entries))
+	attrs := make([]FileAttributes, len(entries))
+
+	for i, e := range entries {
+		if e == attrEntry {
+			e.data = buildAttributes(attrs)
+		}
+
+		offset := uint32(len(body))
+		if e.raw != nil {
+			body = append(body, e.raw...)
+			blocks[i] = e.block
+			blocks[i].Offset = offset
+			attrs[i] = e.attrs
+			continue
+		}
+
+		stored, block, err := w.encodeFile(e, offset)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", e.name, err)
+		}
+		body = append(body, stored...)
+		blocks[i] = block
+
+		if e != attrEntry {
+			attrs[i] = FileAttributes{
+				CRC32:    crc32.ChecksumIEEE(e.data),
+				FileTime: now,
+				MD5:      md5.Sum(e.data),
+			}
+		}
+	}
+
+	if uint64(len(body))+uint64(len(hashTable))*16+uint64(len(blocks))*16 > 0xFFFFFFFF {
+		return 0, errors.New("mpq: archive too large for format version 1")
+	}
+
+	var hashBuf, blockBuf bytes.Buffer
+	binary.Write(&hashBuf, binary.LittleEndian, hashTable)
+	binary.Write(&blockBuf, binary.LittleEndian, blocks)
+	hashRaw, blockRaw := hashBuf.Bytes(), blockBuf.Bytes()
+	mpqEncrypt(hashRaw, mpqHashString("(hash table)", MPQ_HASH_FILE_KEY))
+	mpqEncrypt(blockRaw, mpqHashString("(block table)", MPQ_HASH_FILE_KEY))
+
+	hdr := Header{
+		ID:                mpqSignature,
+		HeaderSize:        headerSize,
+		SectorSizeShift:   w.sectorShift,
+		HashTableOffset:   uint32(len(body)),
+		BlockTableOffset:  uint32(len(body) + len(hashRaw)),
+		HashTableEntries:  uint32(len(hashTable)),
+		BlockTableEntries: uint32(len(blocks)),
+	}
+	body = append(body, hashRaw...)
+	body = append(body, blockRaw...)
+	hdr.ArchiveSize = uint32(len(body))
+
+	var hdrBuf bytes.Buffer
+	binary.Write(&hdrBuf, binary.LittleEndian, hdr)
+	copy(body, hdrBuf.Bytes()[:headerSize])
+
+	n, err := dst.Write(body)
+	return int64(n), err
+}
+
+// hashTable places every entry, giving each the index of its block.
+// Entries of unknown name keep their slot in the inherited table; the
+// slots other files vacated become deleted markers so the probe chains
+// leading to them stay intact.
+func (w *Writer) hashTable(entries []*writerEntry) ([]HashEntry, error) {
+	empty := HashEntry{0xFFFFFFFF, 0xFFFFFFFF, 0xFFFF, 0xFFFF, 0xFFFFFFFF}
+
+	var table []HashEntry
+	if w.baseHash != nil {
+		if len(entries) > len(w.baseHash) {
+			return nil, errors.New("mpq: hash table full")
+		}
+		table = make([]HashEntry, len(w.baseHash))
+		for i, h := range w.baseHash {
+			table[i] = empty
+			if h.BlockIdx != 0xFFFFFFFF {
+				table[i].BlockIdx = 0xFFFFFFFE
+			}
+		}
+	} else {
+		size := 16
+		for size < len(entries)*4/3+1 {
+			size *= 2
+		}
+		table = make([]HashEntry, size)
+		for i := range table {
+			table[i] = empty
+		}
+	}
+
+	entryFor := func(e *writerEntry, blockIdx int) HashEntry {
+		return HashEntry{e.hashA, e.hashB, e.locale, e.platform, uint32(blockIdx)}
+	}
+
+	for i, e := range entries {
+		if e.name == "" {
+			table[e.slot] = entryFor(e, i)
+		}
+	}
+
+	size := uint32(len(table))
+	for i, e := range entries {
+		if e.name == "" {
+			continue
+		}
+		start := mpqHashString(e.name, MPQ_HASH_TABLE_OFFSET) % size
+		placed := false
+		for j := uint32(0); j < size; j++ {
+			slot := &table[(start+j)%size]
+			if slot.BlockIdx == 0xFFFFFFFF || slot.BlockIdx == 0xFFFFFFFE {
+				*slot = entryFor(e, i)
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			return nil, errors.New("mpq: hash table full")
+		}
+	}
+
+	return table, nil
+}
+
+// encodeFile compresses and encrypts new content for the block at
+// offset.
+func (w *Writer) encodeFile(e *writerEntry, offset uint32) ([]byte, BlockEntry, error) {
+	block := BlockEntry{
+		Offset:           offset,
+		UncompressedSize: uint32(len(e.data)),
+		Flags:            MPQ_FILE_EXISTS | e.flags,
+	}
+	if len(e.data) == 0 {
+		block.Flags &^= MPQ_FILE_COMPRESS | MPQ_FILE_ENCRYPTED | MPQ_FILE_FIX_KEY
+		return nil, block, nil
+	}
+
+	key := mpqHashString(baseNameForKey(e.name), MPQ_HASH_FILE_KEY)
+	if block.Flags&MPQ_FILE_FIX_KEY != 0 {
+		key = (key + offset) ^ block.UncompressedSize
+	}
+	encrypted := block.Flags&MPQ_FILE_ENCRYPTED != 0
+	compressed := block.Flags&MPQ_FILE_COMPRESS != 0
+
+	var stored []byte
+	switch {
+	case block.Flags&MPQ_FILE_SINGLE_UNIT != 0:
+		stored = e.data
+		if compressed {
+			c, err := compressZlib(e.data)
+			if err != nil {
+				return nil, block, err
+			}
+			if len(c) < len(e.data) {
+				stored = c
+			}
+		}
+		stored = append([]byte(nil), stored...)
+		if encrypted {
+			mpqEncrypt(stored, key)
+		}
+
+	case compressed:
+		sectorSize := 512 << w.sectorShift
+		count := (len(e.data) + sectorSize - 1) / sectorSize
+		table := make([]byte, (count+1)*4)
+		var sectors []byte
+		for i := 0; i < count; i++ {
+			binary.LittleEndian.PutUint32(table[i*4:], uint32(len(table)+len(sectors)))
+			raw := e.data[i*sectorSize : min((i+1)*sectorSize, len(e.data))]
+			sector, err := compressZlib(raw)
+			if err != nil {
+				return nil, block, err
+			}
+			// The reader takes a sector of full size as stored
+			if len(sector) >= len(raw) {
+				sector = append([]byte(nil), raw...)
+			}
+			if encrypted {
+				mpqEncrypt(sector, key+uint32(i))
+			}
+			sectors = append(sectors, sector...)
+		}
+		binary.LittleEndian.PutUint32(table[count*4:], uint32(len(table)+len(sectors)))
+		if encrypted {
+			mpqEncrypt(table, key-1)
+		}
+		stored = append(table, sectors...)
+
+	default:
+		stored = append([]byte(nil), e.data...)
+		if encrypted {
+			sectorSize := 512 << w.sectorShift
+			for i := 0; i*sectorSize < len(stored); i++ {
+				mpqEncrypt(stored[i*sectorSize:min((i+1)*sectorSize, len(stored))], key+uint32(i))
+			}
+		}
+	}
+
+	if block.Flags&MPQ_FILE_SINGLE_UNIT != 0 && len(stored) == len(e.data) {
+		block.Flags &^= MPQ_FILE_COMPRESS
+	}
+	block.CompressedSize = uint32(len(stored))
+	return stored, block, nil
+}
+
+// listfile lists the named files, sorted and CRLF-separated.
+func (w *Writer) listfile() []byte {
+	seen := make(map[string]bool)
+	var names []string
+	for _, e := range w.entries {
+		key := strings.ToUpper(e.name)
+		if e.name == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		names = append(names, e.name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, n := range names {
+		buf.WriteString(n)
+		buf.WriteString("\r\n")
+	}
+	return buf.Bytes()
+}
+
+// buildAttributes encodes an "(attributes)" file with CRC32, FILETIME
+// and MD5 arrays.
+func buildAttributes(attrs []FileAttributes) []byte {
+	buf := binary.LittleEndian.AppendUint32(nil, attributesVersion)
+	buf = binary.LittleEndian.AppendUint32(buf, MPQ_ATTRIBUTE_CRC32|MPQ_ATTRIBUTE_FILETIME|MPQ_ATTRIBUTE_MD5)
+	for _, a := range attrs {
+		buf = binary.LittleEndian.AppendUint32(buf, a.CRC32)
+	}
+	for _, a := range attrs {
+		buf = binary.LittleEndian.AppendUint64(buf, a.FileTime)
+	}
+	for _, a := range attrs {
+		buf = append(buf, a.MD5[:]...)
+	}
+	return buf
+}
+
+// compressZlib returns data zlib-compressed behind the compression mask
+// byte.
+func compressZlib(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(MPQ_COMPRESSION_ZLIB)
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func timeToFileTime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/100) + fileTimeEpoch
+}
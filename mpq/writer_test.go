@@ -0,0 +1,242 @@
+package mpq
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// saveArchive writes w to a temporary file and opens it.
+func saveArchive(t *testing.T, w *Writer) (string, *MPQ) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.mpq")
+	if err := w.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	m, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return path, m
+}
+
+var writerFiles = []struct {
+	name  string
+	flags uint32
+	data  []byte
+}{
+	{"stored.txt", 0, stormText("stored.txt", 500)},
+	{"zlib.txt", MPQ_FILE_COMPRESS, stormText("zlib.txt", 500)},
+	{"encrypted.txt", MPQ_FILE_COMPRESS | MPQ_FILE_ENCRYPTED, stormText("encrypted.txt", 500)},
+	{"fixkey.txt", MPQ_FILE_COMPRESS | MPQ_FILE_ENCRYPTED | MPQ_FILE_FIX_KEY, stormText("fixkey.txt", 500)},
+	{"single.txt", MPQ_FILE_COMPRESS | MPQ_FILE_SINGLE_UNIT, stormText("single.txt", 500)},
+	{"single-encrypted.txt", MPQ_FILE_COMPRESS | MPQ_FILE_SINGLE_UNIT | MPQ_FILE_ENCRYPTED | MPQ_FILE_FIX_KEY, stormText("single-encrypted.txt", 50)},
+	{"World\\Maps\\Azeroth\\Azeroth.wdt", MPQ_FILE_COMPRESS, stormText("Azeroth.wdt", 5)},
+	{"empty.txt", MPQ_FILE_COMPRESS, nil},
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	w := NewWriter()
+	for _, f := range writerFiles {
+		if err := w.Add(f.name, f.data, f.flags, 0); err != nil {
+			t.Fatalf("%s: %v", f.name, err)
+		}
+	}
+	_, m := saveArchive(t, w)
+
+	for _, f := range writerFiles {
+		got, err := m.ReadFile(f.name)
+		if err != nil {
+			t.Errorf("%s: %v", f.name, err)
+			continue
+		}
+		if !bytes.Equal(got, f.data) {
+			t.Errorf("%s: content differs", f.name)
+		}
+
+		h, _ := m.lookup(f.name)
+		flags := m.blockTable[h.BlockIdx].Flags
+		if want := f.flags &^ MPQ_FILE_COMPRESS; flags&want != want {
+			t.Errorf("%s: block flags 0x%08X lack 0x%08X", f.name, flags, want)
+		}
+	}
+
+	// The generated listfile names itself but not "(attributes)"
+	if got := len(m.List()); got != len(writerFiles)+1 {
+		t.Errorf("listed %q", names(m.List()))
+	}
+	for _, res := range m.VerifyAll() {
+		if res.Status != VerifyOK && !(res.Name == "(attributes)" && res.Status == VerifyNoChecksum) {
+			t.Errorf("%s: %v (%v)", res.Name, res.Status, res.Err)
+		}
+	}
+}
+
+func TestWriterLocales(t *testing.T) {
+	w := NewWriter()
+	w.Add("a.txt", []byte("neutral"), MPQ_FILE_COMPRESS, 0)
+	w.Add("a.txt", []byte("deDE"), MPQ_FILE_COMPRESS, 0x407)
+	w.Add("a.txt", []byte("deDE again"), MPQ_FILE_COMPRESS, 0x407)
+	_, m := saveArchive(t, w)
+
+	if vs := m.Variants("a.txt"); len(vs) != 2 {
+		t.Fatalf("got %d variants, want 2", len(vs))
+	}
+	for locale, want := range map[uint16]string{0: "neutral", 0x407: "deDE again"} {
+		if got, err := m.ReadFileLocale("a.txt", locale); err != nil || string(got) != want {
+			t.Errorf("locale %#x: %q, %v", locale, got, err)
+		}
+	}
+}
+
+func TestWriterAddErrors(t *testing.T) {
+	w := NewWriter()
+	if err := w.Add("", []byte("x"), 0, 0); err == nil {
+		t.Error("empty name: no error")
+	}
+	if err := w.Add("a.txt", []byte("x"), MPQ_FILE_IMPLODE, 0); err == nil {
+		t.Error("implode: no error")
+	}
+	if err := w.Add("a.txt", []byte("x"), MPQ_FILE_FIX_KEY, 0); err == nil {
+		t.Error("FIX_KEY without ENCRYPTED: no error")
+	}
+}
+
+func TestWriterDelete(t *testing.T) {
+	w := NewWriter()
+	w.Add("World/Maps/a.adt", []byte("a"), 0, 0)
+	w.Add("World/Maps/b.adt", []byte("b"), 0, 0)
+
+	if !w.Delete("world/maps/a.adt", 0) {
+		t.Fatal("Delete with '/' separators found nothing")
+	}
+	if w.Delete("World\\Maps\\a.adt", 0) {
+		t.Error("file deleted twice")
+	}
+	if w.Delete("World\\Maps\\b.adt", 0x409) {
+		t.Error("Delete ignored the locale")
+	}
+
+	_, m := saveArchive(t, w)
+	if _, err := m.ReadFile("World\\Maps\\a.adt"); err == nil {
+		t.Error("deleted file still in the archive")
+	}
+	if data, err := m.ReadFile("World\\Maps\\b.adt"); err != nil || string(data) != "b" {
+		t.Errorf("kept file: got %q, %v", data, err)
+	}
+}
+
+func TestEdit(t *testing.T) {
+	w := NewWriter()
+	for _, f := range writerFiles {
+		w.Add(f.name, f.data, f.flags, 0)
+	}
+	path, m := saveArchive(t, w)
+	m.Close()
+
+	e, err := Edit(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Add("new.txt", []byte("added"), MPQ_FILE_COMPRESS, 0)
+	e.Add("zlib.txt", []byte("replaced"), MPQ_FILE_COMPRESS|MPQ_FILE_ENCRYPTED, 0)
+	if !e.Delete("stored.txt", 0) {
+		t.Fatal("stored.txt not carried over")
+	}
+	// Saving over the source works because Edit closed it
+	if err := e.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	m, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	want := map[string]string{"new.txt": "added", "zlib.txt": "replaced"}
+	for _, f := range writerFiles {
+		if _, ok := want[f.name]; !ok && f.name != "stored.txt" {
+			want[f.name] = string(f.data)
+		}
+	}
+	for name, data := range want {
+		if got, err := m.ReadFile(name); err != nil || string(got) != data {
+			t.Errorf("%s: %q, %v", name, got, err)
+		}
+	}
+	if _, err := m.ReadFile("stored.txt"); err == nil {
+		t.Error("deleted file still in the archive")
+	}
+	for _, res := range m.VerifyAll() {
+		if res.Status != VerifyOK && res.Status != VerifyNoChecksum {
+			t.Errorf("%s: %v (%v)", res.Name, res.Status, res.Err)
+		}
+	}
+}
+
+func TestEditStormArchive(t *testing.T) {
+	raw, err := os.ReadFile(filepath.Join("testdata", "storm.mpq"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "storm.mpq")
+	if err := os.WriteFile(path, raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := Edit(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Add("new.txt", []byte("added"), MPQ_FILE_COMPRESS, 0)
+	if err := e.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	m, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	// Every StormLib-compressed file survives, including FIX_KEY ones
+	for _, tc := range []struct {
+		name  string
+		lines int
+	}{
+		{"implode.txt", 500}, {"pkware.txt", 500}, {"bzip2.txt", 500}, {"lzma.txt", 500},
+		{"crc.txt", 500}, {"encrypted.txt", 500}, {"fixkey.txt", 500}, {"single.txt", 500},
+	} {
+		if got, err := m.ReadFile(tc.name); err != nil || !bytes.Equal(got, stormText(tc.name, tc.lines)) {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+	}
+	if got, err := m.ReadFileLocale("locale.txt", 0x40C); err != nil || !bytes.Equal(got, stormText("locale.txt frFR", 10)) {
+		t.Errorf("locale.txt frFR: %v", err)
+	}
+	if got, err := m.ReadFile("new.txt"); err != nil || string(got) != "added" {
+		t.Errorf("new.txt: %q, %v", got, err)
+	}
+}
+
+func TestEditDamagedAttributes(t *testing.T) {
+	raw, err := os.ReadFile(filepath.Join("testdata", "storm.mpq"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := openStorm(t, "storm.mpq")
+	h, _ := m.lookup("(attributes)")
+	off := m.blockOffset(h.BlockIdx)
+	for i := range 12 {
+		raw[off+int64(i)] = 0xFF
+	}
+	path := filepath.Join(t.TempDir(), "storm.mpq")
+	if err := os.WriteFile(path, raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Edit(path); err == nil {
+		t.Error("damaged (attributes) accepted")
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"wowmap/casc"
+	"wowmap/vfs"
+)
+
+/* =======================
+   Client profiles
+   ======================= */
+
+// ClientProfile describes the data layout of one client version.
+type ClientProfile struct {
+	Name string
+
+	// Archives in load order, lowest priority first. Names are relative
+	// to the data dir; {locale} stands for the locale code, and '*', '?'
+	// and [...] patterns load every match in name order.
+	LoadOrder []string
+
+	// Patch archives, loaded after LoadOrder in the same way. Their
+	// empty files delete the file from the archives below.
+	Patches []string
+
+	// Path of md5translate.trs inside the archives. Without one, tiles
+	// are found by name under MinimapDir instead.
+	MD5Translate string
+	MinimapDir   string
+
+	// Data is CASC storage rather than MPQs; LoadOrder and Patches are
+	// unused
+	CASC bool
+
+	// Archives whose presence identifies the version
+	markers []string
+}
+
+// md5TranslatePath is where every MPQ client keeps md5translate.trs. Only
+// the archive holding it differs between versions, and the load order
+// takes care of that.
+const md5TranslatePath = "Textures/Minimap/md5translate.trs"
+
+// clientProfiles are tried newest first, since later clients still ship
+// some of the older archive names.
+var clientProfiles = []*ClientProfile{
+	{
+		Name:       "casc",
+		MinimapDir: "World/Minimaps",
+		CASC:       true,
+	},
+	{
+		Name: "3.3.5",
+		LoadOrder: []string{
+			"base.MPQ",
+			"common.MPQ",
+			"common-2.MPQ",
+			"expansion.MPQ",
+			"lichking.MPQ",
+			"{locale}/locale-{locale}.MPQ",
+			"{locale}/speech-{locale}.MPQ",
+			"{locale}/expansion-locale-{locale}.MPQ",
+			"{locale}/expansion-speech-{locale}.MPQ",
+			"{locale}/lichking-locale-{locale}.MPQ",
+			"{locale}/lichking-speech-{locale}.MPQ",
+		},
+		Patches: []string{
+			"patch.MPQ",
+			"patch-2.MPQ",
+			"patch-3.MPQ",
+			"patch-[4-9].MPQ",
+			"patch-[a-z].MPQ",
+			"{locale}/patch-{locale}.MPQ",
+			"{locale}/patch-{locale}-2.MPQ",
+			"{locale}/patch-{locale}-3.MPQ",
+			"{locale}/patch-{locale}-[4-9].MPQ",
+			"{locale}/patch-{locale}-[a-z].MPQ",
+		},
+		MD5Translate: md5TranslatePath,
+		markers:      []string{"lichking.MPQ", "common-2.MPQ"},
+	},
+	{
+		Name: "2.4.3",
+		LoadOrder: []string{
+			"common.MPQ",
+			"expansion.MPQ",
+			"{locale}/locale-{locale}.MPQ",
+			"{locale}/speech-{locale}.MPQ",
+			"{locale}/expansion-locale-{locale}.MPQ",
+			"{locale}/expansion-speech-{locale}.MPQ",
+		},
+		Patches: []string{
+			"patch.MPQ",
+			"patch-2.MPQ",
+			"patch-[3-9].MPQ",
+			"patch-[a-z].MPQ",
+			"{locale}/patch-{locale}.MPQ",
+			"{locale}/patch-{locale}-2.MPQ",
+			"{locale}/patch-{locale}-[3-9].MPQ",
+			"{locale}/patch-{locale}-[a-z].MPQ",
+		},
+		MD5Translate: md5TranslatePath,
+		markers:      []string{"expansion.MPQ"},
+	},
+	{
+		// Content is split by type and there are no locale archives
+		Name: "1.12",
+		LoadOrder: []string{
+			"base.MPQ",
+			"backup.MPQ",
+			"dbc.MPQ",
+			"fonts.MPQ",
+			"interface.MPQ",
+			"misc.MPQ",
+			"model.MPQ",
+			"sound.MPQ",
+			"speech.MPQ",
+			"terrain.MPQ",
+			"texture.MPQ",
+			"wmo.MPQ",
+		},
+		Patches: []string{
+			"patch.MPQ",
+			"patch-2.MPQ",
+			"patch-[3-9].MPQ",
+			"patch-[a-z].MPQ",
+		},
+		MD5Translate: md5TranslatePath,
+		markers:      []string{"dbc.MPQ", "terrain.MPQ", "texture.MPQ"},
+	},
+}
+
+// defaultProfile is assumed when detection fails.
+const defaultProfile = "3.3.5"
+
+// selectProfile returns the profile named in config.json, or the one
+// detected from the data dir.
+func selectProfile(cfg *Config) (*ClientProfile, error) {
+	if cfg.ClientVersion != "" {
+		if p := findProfile(cfg.ClientVersion); p != nil {
+			return p, nil
+		}
+		return nil, fmt.Errorf("unknown client_version %q", cfg.ClientVersion)
+	}
+
+	for _, p := range clientProfiles {
+		if p.matches(cfg.WowDataPath) {
+			return p, nil
+		}
+	}
+
+	log.Printf("could not detect the client version, assuming %s", defaultProfile)
+	return findProfile(defaultProfile), nil
+}
+
+func findProfile(name string) *ClientProfile {
+	for _, p := range clientProfiles {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// matches reports whether a data dir looks like it belongs to p.
+func (p *ClientProfile) matches(dataDir string) bool {
+	if p.CASC {
+		return !isRemote(dataDir) && casc.IsStorage(dataDir)
+	}
+	for _, m := range p.markers {
+		if archiveExists(dataDir, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveExists reports whether a data dir, local or remote, has an
+// archive of the given name.
+func archiveExists(dataDir, name string) bool {
+	if isRemote(dataDir) {
+		_, err := vfs.OpenHTTP(strings.TrimSuffix(dataDir, "/") + "/" + name)
+		return err == nil
+	}
+	return len(findArchives(dataDir, name)) > 0
+}
+
+// isRemote reports whether the data dir is an HTTP(S) URL served with
+// range support.
+func isRemote(dataDir string) bool {
+	return strings.HasPrefix(dataDir, "http://") || strings.HasPrefix(dataDir, "https://")
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSelectProfile(t *testing.T) {
+	tests := []struct {
+		archives []string
+		want     string
+	}{
+		{[]string{"common.MPQ", "common-2.MPQ", "expansion.MPQ", "lichking.MPQ"}, "3.3.5"},
+		{[]string{"common.MPQ", "expansion.MPQ"}, "2.4.3"},
+		{[]string{"base.MPQ", "dbc.MPQ", "Terrain.MPQ"}, "1.12"},
+		{nil, "casc"},                    // .build.info only
+		{[]string{"patch.MPQ"}, "3.3.5"}, // unrecognised: the default
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		if tt.archives == nil {
+			os.WriteFile(filepath.Join(dir, ".build.info"), nil, 0644)
+		}
+		for _, name := range tt.archives {
+			writeArchive(t, dir, name)
+		}
+		p, err := selectProfile(&Config{WowDataPath: dir})
+		if err != nil {
+			t.Errorf("%v: %v", tt.archives, err)
+			continue
+		}
+		if p.Name != tt.want {
+			t.Errorf("%v: got %s, want %s", tt.archives, p.Name, tt.want)
+		}
+	}
+}
+
+func TestSelectProfileFromConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeArchive(t, dir, "lichking.MPQ")
+
+	p, err := selectProfile(&Config{WowDataPath: dir, ClientVersion: "1.12"})
+	if err != nil || p.Name != "1.12" {
+		t.Errorf("client_version 1.12: got %v, %v", p, err)
+	}
+	if _, err := selectProfile(&Config{WowDataPath: dir, ClientVersion: "4.0"}); err == nil {
+		t.Error("unknown client_version: expected an error")
+	}
+}
+
+func TestProfileMatches(t *testing.T) {
+	mpqDir := t.TempDir()
+	writeArchive(t, mpqDir, "Expansion.mpq")
+
+	// A CASC install, opened from its root or a client folder
+	cascDir := t.TempDir()
+	os.WriteFile(filepath.Join(cascDir, ".build.info"), nil, 0644)
+	os.Mkdir(filepath.Join(cascDir, "_classic_"), 0755)
+
+	tests := []struct {
+		profile, dir string
+		want         bool
+	}{
+		{"2.4.3", mpqDir, true},
+		{"3.3.5", mpqDir, false},
+		{"casc", mpqDir, false},
+		{"casc", cascDir, true},
+		{"casc", filepath.Join(cascDir, "_classic_"), true},
+		{"casc", "https://example.com/Data", false},
+		{"3.3.5", cascDir, false},
+	}
+	for _, tt := range tests {
+		if got := findProfile(tt.profile).matches(tt.dir); got != tt.want {
+			t.Errorf("%s matches %s = %v, want %v", tt.profile, tt.dir, got, tt.want)
+		}
+	}
+}
+
+func TestProfileLayouts(t *testing.T) {
+	tests := []struct {
+		profile      string
+		has, lacks   []string
+		md5Translate bool
+	}{
+		{
+			"1.12",
+			[]string{"dbc.MPQ", "terrain.MPQ", "texture.MPQ", "patch-2.MPQ"},
+			[]string{"common.MPQ", "expansion.MPQ", "{locale}/locale-{locale}.MPQ"},
+			true,
+		},
+		{
+			"2.4.3",
+			[]string{"common.MPQ", "expansion.MPQ", "{locale}/expansion-locale-{locale}.MPQ"},
+			[]string{"texture.MPQ", "lichking.MPQ", "{locale}/lichking-locale-{locale}.MPQ"},
+			true,
+		},
+		{
+			"3.3.5",
+			[]string{"common-2.MPQ", "lichking.MPQ", "{locale}/lichking-locale-{locale}.MPQ", "patch-3.MPQ"},
+			[]string{"texture.MPQ", "patch-[3-9].MPQ"},
+			true,
+		},
+		{"casc", nil, []string{"common.MPQ"}, false},
+	}
+	for _, tt := range tests {
+		p := findProfile(tt.profile)
+		archives := append(slices.Clone(p.LoadOrder), p.Patches...)
+		for _, name := range tt.has {
+			if !slices.Contains(archives, name) {
+				t.Errorf("%s: %s not loaded", tt.profile, name)
+			}
+		}
+		for _, name := range tt.lacks {
+			if slices.Contains(archives, name) {
+				t.Errorf("%s: %s loaded", tt.profile, name)
+			}
+		}
+
+		// Tiles come from md5translate.trs in MPQ clients, and are
+		// found by name in CASC storage
+		if got := p.MD5Translate != ""; got != tt.md5Translate {
+			t.Errorf("%s: MD5Translate %q", tt.profile, p.MD5Translate)
+		}
+		if !tt.md5Translate && p.MinimapDir == "" {
+			t.Errorf("%s: no MinimapDir", tt.profile)
+		}
+	}
+}
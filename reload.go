@@ -0,0 +1,228 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+
+	"wowmap/casc"
+	"wowmap/vfs"
+)
+
+/* =======================
+   Hot reload
+   ======================= */
+
+const defaultReloadPoll = 2 * time.Second
+
+// dataReload is data rebuilt after the data dir changed, waiting for the
+// game loop to swap it in.
+type dataReload struct {
+	ctx     *AppContext
+	changed map[string]bool // OS paths added, removed or rewritten
+}
+
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// watchData polls the data dir and loose-file dirs and sends a rebuilt
+// context on out whenever they change. A change is only acted on once
+// two polls in a row agree, so archives still being written are not
+// opened half done.
+func watchData(cfg *Config, profile *ClientProfile, out chan<- *dataReload) {
+	interval := defaultReloadPoll
+	switch {
+	case cfg.ReloadPollSeconds < 0:
+		return
+	case cfg.ReloadPollSeconds > 0:
+		interval = time.Duration(cfg.ReloadPollSeconds) * time.Second
+	}
+
+	roots := watchRoots(cfg, profile)
+	if len(roots) == 0 {
+		return
+	}
+
+	loaded := snapshotFiles(roots)
+	var pending, failed map[string]fileStamp
+
+	for range time.Tick(interval) {
+		cur := snapshotFiles(roots)
+		if sameFiles(cur, loaded) || (failed != nil && sameFiles(cur, failed)) {
+			pending = nil
+			continue
+		}
+		if pending == nil || !sameFiles(cur, pending) {
+			pending = cur
+			continue
+		}
+		pending = nil
+
+		next := &AppContext{Cfg: cfg, Reload: true}
+		if err := initVFS(next); err != nil {
+			log.Printf("reload: %v", err)
+			failed = cur
+			continue
+		}
+
+		out <- &dataReload{ctx: next, changed: changedFiles(loaded, cur)}
+		loaded, failed = cur, nil
+	}
+}
+
+// watchRoots returns the files and dirs whose changes trigger a reload.
+// Of CASC storage only .build.info and the data and config dirs count;
+// Data/data holds the .idx files read. The rest of an install, CDN
+// indices included, changes as the client runs and can be large.
+func watchRoots(cfg *Config, profile *ClientProfile) []string {
+	var roots []string
+	switch {
+	case isRemote(cfg.WowDataPath):
+		// Remote archives are not watched
+	case profile.CASC:
+		root, ok := casc.Root(cfg.WowDataPath)
+		if !ok {
+			break
+		}
+		roots = append(roots,
+			filepath.Join(root, ".build.info"),
+			filepath.Join(root, "Data", "data"),
+			filepath.Join(root, "Data", "config"),
+		)
+		if cfg.Listfile != "" {
+			roots = append(roots, cfg.Listfile)
+		}
+	default:
+		roots = append(roots, cfg.WowDataPath)
+	}
+	for _, l := range cfg.LooseFiles {
+		roots = append(roots, l.Path)
+	}
+	return roots
+}
+
+// snapshotFiles stamps every file below roots. Unreadable parts are
+// skipped; they show up as removed files.
+func snapshotFiles(roots []string) map[string]fileStamp {
+	files := make(map[string]fileStamp)
+	for _, root := range roots {
+		filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				files[p] = fileStamp{size: info.Size(), modTime: info.ModTime()}
+			}
+			return nil
+		})
+	}
+	return files
+}
+
+func sameFiles(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for p, s := range a {
+		if t, ok := b[p]; !ok || s.size != t.size || !s.modTime.Equal(t.modTime) {
+			return false
+		}
+	}
+	return true
+}
+
+func changedFiles(old, cur map[string]fileStamp) map[string]bool {
+	changed := make(map[string]bool)
+	for p, s := range cur {
+		if t, ok := old[p]; !ok || s.size != t.size || !s.modTime.Equal(t.modTime) {
+			changed[p] = true
+		}
+	}
+	for p := range old {
+		if _, ok := cur[p]; !ok {
+			changed[p] = true
+		}
+	}
+	return changed
+}
+
+// fileOrigin returns the archive or loose file a file is read from.
+// It fails for filesystems that can't tell, such as CASC storage.
+func fileOrigin(fsys fs.FS, name string) (string, bool) {
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return "", false
+	}
+	res, ok := info.Sys().(vfs.Resolution)
+	if !ok {
+		return "", false
+	}
+	if res.Dir != nil {
+		rel := filepath.FromSlash(strings.ReplaceAll(res.Info.Name, "\\", "/"))
+		return filepath.Join(res.Dir.Path(), rel), true
+	}
+	return res.Path, true
+}
+
+// applyReload swaps in rebuilt data. Cached tiles stay unless they now
+// come from another file or theirs was rewritten, and the current map
+// is reloaded in place, keeping the camera.
+func (g *Game) applyReload(r *dataReload) {
+	old := g.ctx
+
+	dropped := g.cache.Invalidate(func(path string) bool {
+		before, ok1 := fileOrigin(old.FS, path)
+		after, ok2 := fileOrigin(r.ctx.FS, path)
+		return !ok1 || !ok2 || before != after || r.changed[after]
+	})
+
+	current := ""
+	if g.current >= 0 {
+		current = g.mapNames[g.current]
+	}
+
+	names := make([]string, 0, len(r.ctx.Minimaps))
+	for k := range r.ctx.Minimaps {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	// Signatures are only checked at startup
+	r.ctx.Tampered = old.Tampered
+
+	g.ctx = r.ctx
+	g.mapNames = names
+	active := g.selector.IsActive()
+	g.selector = NewMapSelector(names)
+	if active {
+		g.selector.Open()
+	}
+
+	g.current = -1
+	g.tiles = make(map[tileKey]*ebiten.Image)
+	for i, n := range names {
+		if n == current {
+			camX, camY, zoom := g.camX, g.camY, g.zoom
+			g.loadMap(i)
+			g.camX, g.camY, g.zoom = camX, camY, zoom
+			break
+		}
+	}
+
+	// Nothing reads from the old data once the game loop has moved on
+	if c, ok := old.FS.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Printf("reload: closing old data: %v", err)
+		}
+	}
+
+	log.Printf("reloaded data: %d files changed, %d cached tiles dropped", len(r.changed), dropped)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"wowmap/casc"
+	"wowmap/mpq"
+	"wowmap/vfs"
+)
+
+func TestSameAndChangedFiles(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	old := map[string]fileStamp{
+		"a.MPQ":    {size: 10, modTime: t0},
+		"b.MPQ":    {size: 20, modTime: t0},
+		"c.MPQ":    {size: 30, modTime: t0},
+		"same.MPQ": {size: 40, modTime: t0},
+	}
+	cur := map[string]fileStamp{
+		"a.MPQ":    {size: 11, modTime: t0},                  // grew
+		"b.MPQ":    {size: 20, modTime: t0.Add(time.Second)}, // rewritten
+		"d.MPQ":    {size: 50, modTime: t0},                  // added
+		"same.MPQ": {size: 40, modTime: t0.In(time.Local)},   // same instant
+	}
+
+	if !sameFiles(old, old) {
+		t.Error("a snapshot differs from itself")
+	}
+	if sameFiles(old, cur) || sameFiles(cur, old) {
+		t.Error("changed snapshots compare equal")
+	}
+
+	changed := changedFiles(old, cur)
+	for _, p := range []string{"a.MPQ", "b.MPQ", "c.MPQ", "d.MPQ"} {
+		if !changed[p] {
+			t.Errorf("%s not reported changed", p)
+		}
+	}
+	if changed["same.MPQ"] || len(changed) != 4 {
+		t.Errorf("changed = %v", changed)
+	}
+}
+
+func TestSnapshotFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeArchive(t, dir, "common.MPQ")
+	writeArchive(t, dir, "enUS/locale-enUS.MPQ")
+
+	snap := snapshotFiles([]string{dir, filepath.Join(dir, "missing")})
+	if len(snap) != 2 {
+		t.Fatalf("snapshot %v, want 2 files", snap)
+	}
+	if !sameFiles(snap, snapshotFiles([]string{dir})) {
+		t.Error("unchanged dir: snapshots differ")
+	}
+
+	os.Remove(filepath.Join(dir, "common.MPQ"))
+	changed := changedFiles(snap, snapshotFiles([]string{dir}))
+	if len(changed) != 1 || !changed[filepath.Join(dir, "common.MPQ")] {
+		t.Errorf("after removing common.MPQ: changed %v", changed)
+	}
+}
+
+// openTestCASC opens the casc package's fixture install.
+func openTestCASC(t *testing.T) *casc.Storage {
+	t.Helper()
+	dir := filepath.Join("casc", "testdata")
+	s, err := casc.Open(filepath.Join(dir, "wow"), casc.Options{Listfile: filepath.Join(dir, "listfile.csv")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestFileOrigin(t *testing.T) {
+	dir := t.TempDir()
+	writeArchive(t, dir, "common.MPQ")
+	loose := filepath.Join(dir, "loose")
+	os.MkdirAll(filepath.Join(loose, "Textures"), 0755)
+	os.WriteFile(filepath.Join(loose, "Textures", "Tile.blp"), []byte("tile"), 0644)
+
+	a, err := mpq.Open(filepath.Join(dir, "common.MPQ"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	d, err := vfs.OpenDir(loose)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stack := vfs.New()
+	stack.Add(a)
+	stack.AddDir(d)
+	fsys := vfs.NewFS(stack)
+
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"who.txt", filepath.Join(dir, "common.MPQ"), true},
+		{"textures/tile.blp", filepath.Join(loose, "Textures", "Tile.blp"), true},
+		{"missing.txt", "", false},
+		{"Textures", "", false}, // a directory
+	}
+	for _, tt := range tests {
+		got, ok := fileOrigin(fsys, tt.name)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("fileOrigin(%s) = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	// CASC storage can't tell
+	cascFS := vfs.NewCASCFS(openTestCASC(t))
+	if _, ok := fileOrigin(cascFS, "Interface/Readme.txt"); ok {
+		t.Error("fileOrigin on CASC storage: ok")
+	}
+}
+
+func TestWatchRoots(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &Config{
+		WowDataPath: dir,
+		LooseFiles:  []LooseFileDir{{Path: "/loose"}},
+	}
+	if roots := watchRoots(cfg, findProfile("3.3.5")); !slices.Equal(roots, []string{dir, "/loose"}) {
+		t.Errorf("MPQ data: roots %q", roots)
+	}
+
+	cfg.WowDataPath = "https://example.com/Data"
+	if roots := watchRoots(cfg, findProfile("3.3.5")); !slices.Equal(roots, []string{"/loose"}) {
+		t.Errorf("remote data: roots %q", roots)
+	}
+
+	// Of CASC storage, only what a build update rewrites
+	os.WriteFile(filepath.Join(dir, ".build.info"), nil, 0644)
+	cfg = &Config{WowDataPath: filepath.Join(dir, "_classic_"), Listfile: "/listfile.csv"}
+	want := []string{
+		filepath.Join(dir, ".build.info"),
+		filepath.Join(dir, "Data", "data"),
+		filepath.Join(dir, "Data", "config"),
+		"/listfile.csv",
+	}
+	if roots := watchRoots(cfg, findProfile("casc")); !slices.Equal(roots, want) {
+		t.Errorf("CASC: roots %q, want %q", roots, want)
+	}
+}
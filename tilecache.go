@@ -30,6 +30,22 @@ func (c *TileCache) Put(path string, img *ebiten.Image) {
 	c.tiles[path] = img
 }
 
+// Invalidate drops the tiles stale reports true for and returns how
+// many were dropped.
+func (c *TileCache) Invalidate(stale func(path string) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for path := range c.tiles {
+		if stale(path) {
+			delete(c.tiles, path)
+			n++
+		}
+	}
+	return n
+}
+
 func (c *TileCache) Size() int {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -0,0 +1,152 @@
+package vfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"wowmap/mpq"
+)
+
+// The lookup benchmarks run against a real data dir when WOWMAP_DATA_DIR
+// names one; every MPQ below it is loaded in path order. Otherwise they
+// use a synthetic stack shaped like a client's Data directory.
+const benchDataEnv = "WOWMAP_DATA_DIR"
+
+const (
+	benchArchives = 24
+	benchFiles    = 4000 // per archive
+)
+
+func benchStack(b *testing.B) (*MPQStack, []string) {
+	b.Helper()
+	paths := benchArchivePaths(b)
+
+	s := New()
+	seen := make(map[string]bool)
+	var names []string
+	for _, p := range paths {
+		a, err := mpq.Open(p)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Cleanup(func() { a.Close() })
+		s.Add(a)
+
+		for _, fi := range a.List() {
+			if key := strings.ToUpper(fi.Name); !seen[key] {
+				seen[key] = true
+				names = append(names, fi.Name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		b.Skip("no listfiles")
+	}
+	return s, names
+}
+
+func benchArchivePaths(b *testing.B) []string {
+	dir := os.Getenv(benchDataEnv)
+	if dir == "" {
+		return synthArchives(b)
+	}
+
+	var paths []string
+	filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && strings.EqualFold(filepath.Ext(p), ".mpq") {
+			paths = append(paths, p)
+		}
+		return nil
+	})
+	sort.Strings(paths)
+	if len(paths) == 0 {
+		b.Skipf("no MPQs in %s", dir)
+	}
+	return paths
+}
+
+// synthArchives writes benchArchives archives in which each archive
+// overrides a quarter of the previous one's files and adds its own.
+func synthArchives(b *testing.B) []string {
+	dir := b.TempDir()
+	var paths []string
+	for i := 0; i < benchArchives; i++ {
+		w := mpq.NewWriter()
+		for j := 0; j < benchFiles; j++ {
+			n := i*benchFiles*3/4 + j
+			name := fmt.Sprintf("World\\Maps\\Map%03d\\Map%03d_%d_%d.adt", n/1024, n/1024, n/32%32, n%32)
+			if err := w.Add(name, []byte(name), 0, 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+		p := filepath.Join(dir, fmt.Sprintf("bench-%02d.MPQ", i))
+		if err := w.Save(p); err != nil {
+			b.Fatal(err)
+		}
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+// dropIndex makes every lookup probe the hash tables of the archives
+// newest first, as the index falls back to for archives without one.
+func dropIndex(s *MPQStack) {
+	s.index = nil
+	s.unindexed = s.unindexed[:0]
+	for i := range s.archives {
+		s.unindexed = append(s.unindexed, i)
+	}
+}
+
+// readFileLookup finds a file the way the stack did before it kept an
+// index: by reading it from each archive, newest first, until one read
+// succeeds.
+func readFileLookup(s *MPQStack, name string) bool {
+	mpqPath := strings.ReplaceAll(name, "/", "\\")
+	for i := len(s.archives) - 1; i >= 0; i-- {
+		if _, err := s.archives[i].ReadFile(mpqPath); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+type lookupMode int
+
+const (
+	lookupIndexed  lookupMode = iota // HasFile
+	lookupProbe                      // HasFile after dropIndex
+	lookupReadFile                   // readFileLookup
+)
+
+func benchLookups(b *testing.B, mode lookupMode, miss bool) {
+	s, names := benchStack(b)
+	lookup := s.HasFile
+	switch mode {
+	case lookupProbe:
+		dropIndex(s)
+	case lookupReadFile:
+		lookup = func(name string) bool { return readFileLookup(s, name) }
+	}
+	if miss {
+		for i, n := range names {
+			names[i] = n + ".missing"
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lookup(names[i%len(names)])
+	}
+}
+
+func BenchmarkLookupIndexed(b *testing.B)       { benchLookups(b, lookupIndexed, false) }
+func BenchmarkLookupUnindexed(b *testing.B)     { benchLookups(b, lookupProbe, false) }
+func BenchmarkLookupReadFile(b *testing.B)      { benchLookups(b, lookupReadFile, false) }
+func BenchmarkLookupMissIndexed(b *testing.B)   { benchLookups(b, lookupIndexed, true) }
+func BenchmarkLookupMissUnindexed(b *testing.B) { benchLookups(b, lookupProbe, true) }
+func BenchmarkLookupMissReadFile(b *testing.B)  { benchLookups(b, lookupReadFile, true) }
@@ -0,0 +1,121 @@
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+
+	"wowmap/casc"
+)
+
+/* =========================
+   CASC storage
+   ========================= */
+
+// CASCFS exposes local CASC storage as an io/fs filesystem. Like FS it
+// matches names ignoring case. Directories come from the storage's
+// listfile.
+type CASCFS struct {
+	storage *casc.Storage
+
+	treeOnce sync.Once
+	tree     *dirNode
+}
+
+// NewCASCFS creates a filesystem view of CASC storage.
+func NewCASCFS(s *casc.Storage) *CASCFS {
+	return &CASCFS{storage: s}
+}
+
+// Storage returns the underlying storage.
+func (f *CASCFS) Storage() *casc.Storage {
+	return f.storage
+}
+
+// Close closes the storage.
+func (f *CASCFS) Close() error {
+	return f.storage.Close()
+}
+
+// Open implements fs.FS.
+func (f *CASCFS) Open(name string) (fs.File, error) {
+	if !validPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	// One lookup for both the data and its description
+	file, err := f.storage.Open(name)
+	if err == nil {
+		return &stackFile{File: file, info: cascFileInfo(name, file.Info())}, nil
+	}
+	if !errors.Is(err, casc.ErrNotFound) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	if d, ok := f.dirTree().find(name); ok {
+		return &dirFile{path: cleanPath(name), dir: d, stat: f.statFile}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// Stat implements fs.StatFS. Sizes come from the encoding file, so
+// nothing is read.
+func (f *CASCFS) Stat(name string) (fs.FileInfo, error) {
+	if !validPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	if info, ok := f.statFile(name); ok {
+		return info, nil
+	}
+	if d, ok := f.dirTree().find(name); ok {
+		return dirInfo{name: d.name}, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir implements fs.ReadDirFS. Entries are sorted by name.
+func (f *CASCFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !validPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	d, ok := f.dirTree().find(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	return d.readDir(cleanPath(name), f.statFile), nil
+}
+
+// Glob implements fs.GlobFS. Patterns match the names as listed, with
+// their case, like path.Match.
+func (f *CASCFS) Glob(pattern string) ([]string, error) {
+	return f.dirTree().glob(pattern)
+}
+
+func (f *CASCFS) dirTree() *dirNode {
+	f.treeOnce.Do(func() {
+		names := f.storage.List()
+		for i, n := range names {
+			names[i] = strings.ReplaceAll(n, "/", "\\")
+		}
+		f.tree = buildTree(names)
+	})
+	return f.tree
+}
+
+// statFile describes a file by its fs.FS path.
+func (f *CASCFS) statFile(name string) (fileInfo, bool) {
+	res, ok := f.storage.Stat(name)
+	if !ok {
+		return fileInfo{}, false
+	}
+	return cascFileInfo(name, res), true
+}
+
+func cascFileInfo(name string, res casc.FileInfo) fileInfo {
+	return fileInfo{
+		name: path.Base(name),
+		size: res.Size,
+		sys:  res,
+	}
+}
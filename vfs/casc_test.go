@@ -0,0 +1,63 @@
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"wowmap/casc"
+)
+
+// testCASCFS opens the casc package's fixture install.
+func testCASCFS(t *testing.T) *CASCFS {
+	t.Helper()
+	dir := filepath.Join("..", "casc", "testdata")
+	s, err := casc.Open(filepath.Join(dir, "wow"), casc.Options{Listfile: filepath.Join(dir, "listfile.csv")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return NewCASCFS(s)
+}
+
+func TestCASCFS(t *testing.T) {
+	fsys := testCASCFS(t)
+	err := fstest.TestFS(fsys,
+		"Interface/Readme.txt",
+		"Interface/GlueXML/Strings.lua",
+		"DBFilesClient/Map.db2",
+		"World/Minimaps/Azeroth/map30_31.blp",
+		"World/Minimaps/Kalimdor/map40_12.blp",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := fs.ReadFile(fsys, "textures/NESTED.txt")
+	if err != nil || string(data) != "nested frames" {
+		t.Errorf("case-insensitive read: got %q, %v", data, err)
+	}
+
+	// Listed, but not in the build
+	if _, err := fsys.Open("Interface/Removed.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open(Interface/Removed.txt): got %v, want ErrNotExist", err)
+	}
+	for _, name := range []string{"/Interface", "Interface\\Readme.txt", "Interface/../Interface"} {
+		if _, err := fsys.Stat(name); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("Stat(%q): got %v, want ErrInvalid", name, err)
+		}
+	}
+
+	got, err := fsys.Glob("World/Minimaps/*/map*.blp")
+	want := []string{
+		"World/Minimaps/Azeroth/map30_31.blp",
+		"World/Minimaps/Azeroth/map31_31.blp",
+		"World/Minimaps/Kalimdor/map40_12.blp",
+	}
+	if err != nil || !slices.Equal(got, want) {
+		t.Errorf("Glob = %q, %v", got, err)
+	}
+}
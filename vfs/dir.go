@@ -0,0 +1,150 @@
+package vfs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"wowmap/mpq"
+)
+
+/* =========================
+   Loose-file layer
+   ========================= */
+
+// File is an open file from any layer of a stack. *mpq.File implements
+// it.
+type File interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+	Size() int64
+}
+
+// Dir is a directory of loose files used as a stack layer, the way the
+// client and map editors such as Noggit use them. Paths match ignoring
+// case. The directory is scanned once, when opened; MPQ files in it are
+// skipped, as they are layers of their own.
+type Dir struct {
+	root  string
+	files map[string]looseEntry // by upper-cased archive-style name
+}
+
+type looseEntry struct {
+	name    string // archive-style name with on-disk casing
+	size    int64
+	modTime time.Time
+}
+
+// OpenDir scans root for loose files.
+func OpenDir(root string) (*Dir, error) {
+	d := &Dir{root: root, files: make(map[string]looseEntry)}
+
+	err := filepath.WalkDir(root, func(p string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if de.IsDir() || strings.EqualFold(filepath.Ext(p), ".mpq") {
+			return nil
+		}
+
+		info, err := de.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+
+		name := strings.ReplaceAll(filepath.ToSlash(rel), "/", "\\")
+		d.files[strings.ToUpper(name)] = looseEntry{
+			name:    name,
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Path returns the directory the layer was opened from.
+func (d *Dir) Path() string {
+	return d.root
+}
+
+// List returns the names of all files in the layer, sorted.
+func (d *Dir) List() []string {
+	out := make([]string, 0, len(d.files))
+	for _, e := range d.files {
+		out = append(out, e.name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ReadFile reads a file of the layer.
+func (d *Dir) ReadFile(name string) ([]byte, error) {
+	e, ok := d.entry(name)
+	if !ok {
+		return nil, mpq.ErrNotFound
+	}
+	return os.ReadFile(d.osPath(e))
+}
+
+// Open opens a file of the layer.
+func (d *Dir) Open(name string) (File, error) {
+	e, ok := d.entry(name)
+	if !ok {
+		return nil, mpq.ErrNotFound
+	}
+	f, err := os.Open(d.osPath(e))
+	if err != nil {
+		return nil, err
+	}
+	// The file may have changed since the scan
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &looseFile{File: f, size: info.Size()}, nil
+}
+
+func (d *Dir) entry(name string) (looseEntry, bool) {
+	e, ok := d.files[strings.ToUpper(strings.ReplaceAll(name, "/", "\\"))]
+	return e, ok
+}
+
+// stat describes a loose file the way archives describe their files.
+func (d *Dir) stat(name string) (mpq.FileInfo, bool) {
+	e, ok := d.entry(name)
+	if !ok {
+		return mpq.FileInfo{}, false
+	}
+	return mpq.FileInfo{
+		Name:             e.name,
+		CompressedSize:   uint32(e.size),
+		UncompressedSize: uint32(e.size),
+	}, true
+}
+
+func (d *Dir) osPath(e looseEntry) string {
+	return filepath.Join(d.root, filepath.FromSlash(strings.ReplaceAll(e.name, "\\", "/")))
+}
+
+// looseFile adds Size to *os.File so it satisfies File.
+type looseFile struct {
+	*os.File
+	size int64
+}
+
+func (f *looseFile) Size() int64 {
+	return f.size
+}
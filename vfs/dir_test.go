@@ -0,0 +1,38 @@
+package vfs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirOpenSize(t *testing.T) {
+	root := t.TempDir()
+	p := filepath.Join(root, "file.txt")
+	if err := os.WriteFile(p, []byte("short"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := OpenDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rewritten after the scan: Size follows the file, not the scan
+	if err := os.WriteFile(p, []byte("a longer file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := d.Open("FILE.TXT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Size() != int64(len(data)) {
+		t.Errorf("Size() = %d, read %d bytes", f.Size(), len(data))
+	}
+}
@@ -0,0 +1,45 @@
+package vfs
+
+import (
+	"io/fs"
+	"time"
+)
+
+// stackFile implements fs.File over a file from any stack layer. It
+// also satisfies io.Seeker and io.ReaderAt.
+type stackFile struct {
+	File
+	info fileInfo
+}
+
+func (f *stackFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// fileInfo describes an archived file. Sys returns the Resolution the
+// file was read from, or its casc.FileInfo for CASC storage.
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	sys     interface{}
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) Mode() fs.FileMode  { return 0444 }
+func (fi fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi fileInfo) IsDir() bool        { return false }
+func (fi fileInfo) Sys() interface{}   { return fi.sys }
+
+// dirInfo describes a directory of the listfile tree.
+type dirInfo struct {
+	name string
+}
+
+func (di dirInfo) Name() string       { return di.name }
+func (di dirInfo) Size() int64        { return 0 }
+func (di dirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0555 }
+func (di dirInfo) ModTime() time.Time { return time.Time{} }
+func (di dirInfo) IsDir() bool        { return true }
+func (di dirInfo) Sys() interface{}   { return nil }
@@ -1,10 +1,12 @@
 package vfs
 
 import (
-	"bytes"
+	"errors"
+	"io"
 	"io/fs"
 	"path"
 	"strings"
+	"sync"
 )
 
 // FileSourceProvider allows querying the source MPQ for a file.
@@ -12,9 +14,20 @@ type FileSourceProvider interface {
 	SourceOf(path string) (*FileSource, bool)
 }
 
-// FS exposes MPQStack as an io/fs filesystem.
+// FS exposes MPQStack as an io/fs filesystem. Directories come from the
+// archives' listfiles and loose-file layers; files missing from every
+// listfile can still be opened by name.
+//
+// Names must be valid by fs.ValidPath: '/'-separated, with no leading
+// '/'. Archive-style names such as "Textures\Minimap\x.blp" fail with
+// fs.ErrInvalid; MPQStack takes those.
 type FS struct {
 	stack *MPQStack
+
+	// Directory tree, rebuilt when archives are added
+	mu      sync.Mutex
+	tree    *dirNode
+	treeGen int
 }
 
 // NewFS creates a filesystem view of an MPQStack.
@@ -24,23 +37,160 @@ func NewFS(stack *MPQStack) *FS {
 
 // Open implements fs.FS.
 func (f *FS) Open(name string) (fs.File, error) {
-	// fs.FS paths are slash-separated and may start with '/'
-	mpqPath := strings.TrimPrefix(name, "/")
-	mpqPath = strings.ReplaceAll(mpqPath, "/", "\\")
+	if !validPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if file, err := f.stack.Open(toMPQPath(name)); err == nil {
+		info, _ := f.statFile(name)
+		return &stackFile{File: file, info: info}, nil
+	}
 
-	data, err := f.stack.ReadFile(mpqPath)
-	if err != nil {
-		return nil, fs.ErrNotExist
+	if d, ok := f.dirTree().find(name); ok {
+		return &dirFile{path: cleanPath(name), dir: d, stat: f.statFile}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// Stat implements fs.StatFS. File sizes come from the block tables, so
+// nothing is read or decompressed.
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	if !validPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	if info, ok := f.statFile(name); ok {
+		return info, nil
 	}
+	if d, ok := f.dirTree().find(name); ok {
+		return dirInfo{name: d.name}, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir implements fs.ReadDirFS. Entries are sorted by name.
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !validPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	d, ok := f.dirTree().find(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	return d.readDir(cleanPath(name), f.statFile), nil
+}
 
-	return &memFile{
-		r:    bytes.NewReader(data),
-		size: int64(len(data)),
-		name: path.Base(mpqPath),
-	}, nil
+// Glob implements fs.GlobFS. Patterns match the names as listed, with
+// their case, like path.Match.
+func (f *FS) Glob(pattern string) ([]string, error) {
+	return f.dirTree().glob(pattern)
+}
+
+// Close closes the archives of the stack.
+func (f *FS) Close() error {
+	return f.stack.Close()
 }
 
 // SourceOf reports which MPQ supplies the given file.
 func (f *FS) SourceOf(name string) (*FileSource, bool) {
 	return f.stack.SourceOf(name)
-}
\ No newline at end of file
+}
+
+func (f *FS) dirTree() *dirNode {
+	gen := f.stack.generation()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.tree == nil || f.treeGen != gen {
+		f.tree = buildTree(f.stack.List())
+		f.treeGen = gen
+	}
+	return f.tree
+}
+
+// statFile describes a file by its fs.FS path.
+func (f *FS) statFile(name string) (fileInfo, bool) {
+	mpqPath := toMPQPath(name)
+	res, ok := f.stack.Stat(mpqPath)
+	if !ok {
+		return fileInfo{}, false
+	}
+
+	info := fileInfo{
+		name: path.Base(name),
+		size: int64(res.Info.UncompressedSize),
+		sys:  res,
+	}
+	if res.Dir != nil {
+		if e, ok := res.Dir.entry(mpqPath); ok {
+			info.modTime = e.modTime
+		}
+	} else if attrs, err := res.Archive.Attributes(); err == nil && int(res.Info.BlockIdx) < len(attrs) {
+		info.modTime = attrs[res.Info.BlockIdx].ModTime()
+	}
+	return info, true
+}
+
+// validPath is fs.ValidPath, also refusing the '\' separators archives
+// use. MPQStack itself still takes those.
+func validPath(name string) bool {
+	return fs.ValidPath(name) && !strings.Contains(name, "\\")
+}
+
+// toMPQPath converts an fs.FS path to an archive path.
+func toMPQPath(name string) string {
+	return strings.ReplaceAll(name, "/", "\\")
+}
+
+func cleanPath(name string) string {
+	name = strings.Trim(name, "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+/* =========================
+   Directories
+   ========================= */
+
+// dirFile is an open directory. It implements fs.ReadDirFile.
+type dirFile struct {
+	path string
+	dir  *dirNode
+	stat func(name string) (fileInfo, bool)
+
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error) {
+	return dirInfo{name: d.dir.name}, nil
+}
+
+func (d *dirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.path, Err: errors.New("is a directory")}
+}
+
+func (d *dirFile) Close() error {
+	return nil
+}
+
+// ReadDir returns the next n entries, or all remaining ones if n <= 0.
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		d.entries = d.dir.readDir(d.path, d.stat)
+		d.read = true
+	}
+
+	if n <= 0 {
+		out := d.entries
+		d.entries = nil
+		return out, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(d.entries))
+	out := d.entries[:n]
+	d.entries = d.entries[n:]
+	return out, nil
+}
@@ -0,0 +1,114 @@
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func testFS(t *testing.T) *FS {
+	t.Helper()
+	dir := t.TempDir()
+
+	s := New()
+	s.Add(writeMPQ(t, dir, "common.MPQ", map[string]string{
+		"Readme.txt":                            "readme",
+		"World\\Maps\\Azeroth\\Azeroth.wdt":     "wdt",
+		"World\\Maps\\Azeroth\\Azeroth_1_2.adt": "adt",
+		"Textures\\Minimap\\md5translate.trs":   "dir: Azeroth",
+	}))
+	s.Add(writeMPQ(t, dir, "patch.MPQ", map[string]string{
+		"World\\Maps\\Azeroth\\Azeroth_1_2.adt": "patched",
+		"World\\Maps\\Kalimdor\\Kalimdor.wdt":   "wdt",
+	}))
+
+	loose := filepath.Join(dir, "loose")
+	if err := os.MkdirAll(filepath.Join(loose, "Interface"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(loose, "Interface", "Icon.blp"), []byte("icon"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := OpenDir(loose)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.AddDir(d)
+
+	return NewFS(s)
+}
+
+func TestFS(t *testing.T) {
+	fsys := testFS(t)
+	err := fstest.TestFS(fsys,
+		"Readme.txt",
+		"World/Maps/Azeroth/Azeroth.wdt",
+		"World/Maps/Azeroth/Azeroth_1_2.adt",
+		"World/Maps/Kalimdor/Kalimdor.wdt",
+		"Textures/Minimap/md5translate.trs",
+		"Interface/Icon.blp",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := fs.ReadFile(fsys, "World/Maps/Azeroth/Azeroth_1_2.adt")
+	if err != nil || string(data) != "patched" {
+		t.Errorf("patched file: got %q, %v", data, err)
+	}
+}
+
+func TestFSPaths(t *testing.T) {
+	fsys := testFS(t)
+
+	// Lookups still ignore case
+	for _, name := range []string{"readme.txt", "WORLD/MAPS/AZEROTH/AZEROTH.WDT"} {
+		if _, err := fs.Stat(fsys, name); err != nil {
+			t.Errorf("Stat(%q): %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"/Readme.txt", "World/../Readme.txt", "./Readme.txt", "World/", "", "World\\Maps\\Azeroth\\Azeroth.wdt"} {
+		if _, err := fsys.Open(name); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("Open(%q): got %v, want ErrInvalid", name, err)
+		}
+		if _, err := fsys.Stat(name); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("Stat(%q): got %v, want ErrInvalid", name, err)
+		}
+		if _, err := fsys.ReadDir(name); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("ReadDir(%q): got %v, want ErrInvalid", name, err)
+		}
+	}
+}
+
+func TestFSGlob(t *testing.T) {
+	fsys := testFS(t)
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"World/Maps/*/*.wdt", []string{"World/Maps/Azeroth/Azeroth.wdt", "World/Maps/Kalimdor/Kalimdor.wdt"}},
+		{"world/maps/*/*.wdt", nil},
+		{"World/Maps/Azeroth/*_[0-9]_[0-9].adt", []string{"World/Maps/Azeroth/Azeroth_1_2.adt"}},
+		{"[A-Z]*", []string{"Interface", "Readme.txt", "Textures", "World"}},
+	}
+	for _, tt := range tests {
+		got, err := fsys.Glob(tt.pattern)
+		if err != nil {
+			t.Errorf("Glob(%q): %v", tt.pattern, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Glob(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+
+	if _, err := fsys.Glob("[x"); err == nil {
+		t.Error("bad pattern: expected an error")
+	}
+}
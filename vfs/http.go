@@ -0,0 +1,199 @@
+package vfs
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"wowmap/mpq"
+)
+
+/* =========================
+   HTTP range backend
+   ========================= */
+
+const (
+	httpBlockSize  = 256 * 1024
+	httpCacheBytes = 64 * 1024 * 1024
+
+	// httpTimeout bounds each request, body included, so a stalled
+	// server fails the read instead of hanging it
+	httpTimeout = 30 * time.Second
+)
+
+var httpClient = &http.Client{Timeout: httpTimeout}
+
+// ErrHTTPNotFound is returned by OpenHTTP when the server has no file at
+// the URL.
+var ErrHTTPNotFound = errors.New("vfs: remote file not found")
+
+// HTTPReaderAt reads a remote file with HTTP Range requests. Reads are
+// done in fixed-size blocks kept in a shared LRU cache, so the small
+// scattered reads of MPQ table and sector access cost few requests.
+type HTTPReaderAt struct {
+	url    string
+	size   int64
+	client *http.Client
+
+	mu       sync.Mutex
+	blocks   map[int64]*list.Element
+	lru      *list.List // of *httpBlock, most recent first
+	limit    int
+	inflight map[int64]*httpFetch
+}
+
+type httpBlock struct {
+	idx  int64
+	data []byte
+}
+
+// httpFetch is a block request in progress; done is closed once data
+// or err is set.
+type httpFetch struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// OpenHTTP probes url for its size. Range support is checked on the
+// first read.
+func OpenHTTP(url string) (*HTTPReaderAt, error) {
+	r := &HTTPReaderAt{
+		url:      url,
+		client:   httpClient,
+		blocks:   make(map[int64]*list.Element),
+		lru:      list.New(),
+		limit:    httpCacheBytes / httpBlockSize,
+		inflight: make(map[int64]*httpFetch),
+	}
+
+	resp, err := r.client.Head(url)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, ErrHTTPNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("vfs: HEAD %s: %s", url, resp.Status)
+	case resp.ContentLength < 0:
+		return nil, fmt.Errorf("vfs: %s: unknown size", url)
+	}
+
+	r.size = resp.ContentLength
+	return r, nil
+}
+
+// OpenHTTPMPQ opens an archive served over HTTP.
+func OpenHTTPMPQ(url string) (*mpq.MPQ, error) {
+	r, err := OpenHTTP(url)
+	if err != nil {
+		return nil, err
+	}
+	return mpq.OpenReaderAt(r, r.Size())
+}
+
+// Size returns the remote file size.
+func (r *HTTPReaderAt) Size() int64 {
+	return r.size
+}
+
+// Name returns the last path element of the URL.
+func (r *HTTPReaderAt) Name() string {
+	return path.Base(r.url)
+}
+
+// ReadAt implements io.ReaderAt.
+func (r *HTTPReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("vfs: negative offset")
+	}
+
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= r.size {
+			return n, io.EOF
+		}
+
+		data, err := r.block(pos / httpBlockSize)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], data[pos%httpBlockSize:])
+	}
+	return n, nil
+}
+
+// block returns one cached block, fetching it if needed. Concurrent
+// readers of a block being fetched wait for that request instead of
+// making their own; the lock is not held while fetching.
+func (r *HTTPReaderAt) block(idx int64) ([]byte, error) {
+	r.mu.Lock()
+	if el, ok := r.blocks[idx]; ok {
+		r.lru.MoveToFront(el)
+		r.mu.Unlock()
+		return el.Value.(*httpBlock).data, nil
+	}
+	if f, ok := r.inflight[idx]; ok {
+		r.mu.Unlock()
+		<-f.done
+		return f.data, f.err
+	}
+	f := &httpFetch{done: make(chan struct{})}
+	r.inflight[idx] = f
+	r.mu.Unlock()
+
+	f.data, f.err = r.fetch(idx*httpBlockSize, min(r.size, (idx+1)*httpBlockSize))
+
+	r.mu.Lock()
+	delete(r.inflight, idx)
+	if f.err == nil {
+		r.blocks[idx] = r.lru.PushFront(&httpBlock{idx: idx, data: f.data})
+		if r.lru.Len() > r.limit {
+			old := r.lru.Remove(r.lru.Back()).(*httpBlock)
+			delete(r.blocks, old.idx)
+		}
+	}
+	r.mu.Unlock()
+	close(f.done)
+
+	return f.data, f.err
+}
+
+// fetch reads bytes [start, end) with a single range request.
+func (r *HTTPReaderAt) fetch(start, end int64) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", "bytes="+strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(end-1, 10))
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("vfs: GET %s: %s", r.url, resp.Status)
+	}
+	if cr := resp.Header.Get("Content-Range"); !strings.HasPrefix(cr, fmt.Sprintf("bytes %d-", start)) {
+		return nil, fmt.Errorf("vfs: GET %s: unexpected Content-Range %q", r.url, cr)
+	}
+
+	data := make([]byte, end-start)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,96 @@
+package vfs
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wowmap/mpq"
+)
+
+// serveMPQ serves an archive holding files at /name over HTTP.
+func serveMPQ(t *testing.T, name string, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	writeMPQ(t, dir, name, files).Close()
+	raw, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+name {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(raw))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/" + name
+}
+
+func TestOpenHTTPMPQ(t *testing.T) {
+	url := serveMPQ(t, "patch-2.MPQ", map[string]string{
+		"a.txt": "remote a",
+		"b.txt": "",
+	})
+
+	a, err := OpenHTTPMPQ(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	if a.Path() != "patch-2.MPQ" {
+		t.Errorf("Path = %q", a.Path())
+	}
+	if data, err := a.ReadFile("a.txt"); err != nil || string(data) != "remote a" {
+		t.Errorf("a.txt: %q, %v", data, err)
+	}
+
+	// A remote patch layers like a local one
+	s := New()
+	s.Add(writeMPQ(t, t.TempDir(), "common.MPQ", map[string]string{"b.txt": "b"}))
+	s.AddPatch(a)
+	if s.HasFile("b.txt") {
+		t.Error("b.txt not deleted by the remote patch")
+	}
+	if src, ok := s.SourceOf("a.txt"); !ok || src.Path != "patch-2.MPQ" {
+		t.Errorf("SourceOf(a.txt) = %+v, %v", src, ok)
+	}
+
+	if _, err := OpenHTTP(url + ".missing"); !errors.Is(err, ErrHTTPNotFound) {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+func TestHTTPReaderAt(t *testing.T) {
+	url := serveMPQ(t, "common.MPQ", map[string]string{"a.txt": "a"})
+	r, err := OpenHTTP(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := mpq.OpenReaderAt(r, r.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if data, err := m.ReadFile("a.txt"); err != nil || string(data) != "a" {
+		t.Errorf("a.txt: %q, %v", data, err)
+	}
+
+	p := make([]byte, 4)
+	if n, err := r.ReadAt(p, 0); n != 4 || err != nil || string(p) != "MPQ\x1a" {
+		t.Errorf("ReadAt(0) = %q, %v", p[:n], err)
+	}
+	if n, err := r.ReadAt(p, r.Size()-2); n != 2 || err == nil {
+		t.Errorf("ReadAt past the end = %d, %v", n, err)
+	}
+	if _, err := r.ReadAt(p, -1); err == nil {
+		t.Error("negative offset: no error")
+	}
+}
@@ -1,24 +1,46 @@
 package vfs
 
 import (
+	"context"
 	"errors"
+	"io"
+	"sort"
 	"strings"
+	"sync"
 
 	"wowmap/mpq"
 )
 
+// FileSource is one layer of a stack: an archive, or for loose files a
+// Dir (with Archive nil). Patch is set for archives added with AddPatch.
 type FileSource struct {
 	Archive *mpq.MPQ
+	Dir     *Dir
 	Path    string
 	Order   int
+	Patch   bool
 }
 
 // MPQStack represents a layered MPQ filesystem.
 // Later-added archives override earlier ones.
+// It is safe for concurrent reads and adds.
 type MPQStack struct {
+	mu sync.RWMutex
+
+	// One entry per layer; dirs is nil except for loose-file layers,
+	// whose archives entry is nil
 	archives []*mpq.MPQ
+	dirs     []*Dir
 	paths    []string
+	patches  []bool
 	loadOrder int
+	locales  []uint16
+
+	// index maps each name hash to the newest layer holding it, so
+	// lookups only touch the layer that owns a file. Archives without a
+	// hash table can't be indexed and are listed in unindexed.
+	index     map[mpq.NameHash]int
+	unindexed []int
 }
 
 // New creates an empty MPQ stack.
@@ -28,60 +50,465 @@ func New() *MPQStack {
 
 // Add inserts an MPQ into the stack.
 func (s *MPQStack) Add(a *mpq.MPQ) error {
+	return s.add(a, false)
+}
+
+// AddPatch inserts a patch archive into the stack. Unlike with Add, its
+// empty files are placeholders that delete the file from lower layers.
+func (s *MPQStack) AddPatch(a *mpq.MPQ) error {
+	return s.add(a, true)
+}
+
+func (s *MPQStack) add(a *mpq.MPQ, patch bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	s.loadOrder++
 	s.archives = append(s.archives, a)
+	s.dirs = append(s.dirs, nil)
+	s.patches = append(s.patches, patch)
+	if s.locales != nil {
+		a.SetLocales(s.locales...)
+	}
 
-	if p, ok := any(a).(interface{ Path() string }); ok {
-		s.paths = append(s.paths, p.Path())
+	idx := len(s.archives) - 1
+	if hashes, ok := a.NameHashes(); ok {
+		s.indexLayer(idx, hashes)
 	} else {
-		s.paths = append(s.paths, "")
+		s.unindexed = append(s.unindexed, idx)
 	}
 
+	s.paths = append(s.paths, a.Path())
 	return nil
 }
 
+// AddDir inserts a loose-file layer into the stack. Like archives, it
+// overrides everything added before it.
+func (s *MPQStack) AddDir(d *Dir) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.loadOrder++
+	s.archives = append(s.archives, nil)
+	s.dirs = append(s.dirs, d)
+	s.paths = append(s.paths, d.Path())
+	s.patches = append(s.patches, false)
+
+	hashes := make([]mpq.NameHash, 0, len(d.files))
+	for _, e := range d.files {
+		hashes = append(hashes, mpq.HashName(e.name))
+	}
+	s.indexLayer(len(s.archives)-1, hashes)
+	return nil
+}
+
+// Close closes every archive in the stack. The stack must not be used
+// afterwards.
+func (s *MPQStack) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var first error
+	for _, a := range s.archives {
+		if a == nil {
+			continue
+		}
+		if err := a.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (s *MPQStack) indexLayer(idx int, hashes []mpq.NameHash) {
+	if s.index == nil {
+		s.index = make(map[mpq.NameHash]int, len(hashes))
+	}
+	for _, h := range hashes {
+		s.index[h] = idx
+	}
+}
+
 // ReadFile reads the highest-priority version of a file.
 func (s *MPQStack) ReadFile(name string) ([]byte, error) {
-	if len(s.archives) == 0 {
-		return nil, errors.New("no MPQs loaded")
+	src, mpqPath, err := s.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if src.Dir != nil {
+		return src.Dir.ReadFile(mpqPath)
 	}
+	return src.Archive.ReadFile(mpqPath)
+}
 
-    // Search newest to oldest
-	mpqPath := strings.ReplaceAll(name, "/", "\\")
-	for i := len(s.archives) - 1; i >= 0; i-- {
-		data, err := s.archives[i].ReadFile(mpqPath)
-		if err == nil {
-			return data, nil
+// readChunkSize is how much ReadFileContext reads between checks of its
+// context.
+const readChunkSize = 256 * 1024
+
+// ReadFileContext is ReadFile that gives up between chunks once ctx is
+// done, returning ctx.Err().
+func (s *MPQStack) ReadFileContext(ctx context.Context, name string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f, err := s.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Size comes from the archive; let larger files grow as they read
+	out := make([]byte, 0, min(f.Size(), 64*readChunkSize))
+	buf := make([]byte, readChunkSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		n, err := f.Read(buf)
+		out = append(out, buf[:n]...)
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
 		}
 	}
+}
+
+// Open returns a streaming handle to the highest-priority version of a
+// file.
+func (s *MPQStack) Open(name string) (File, error) {
+	src, mpqPath, err := s.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if src.Dir != nil {
+		return src.Dir.Open(mpqPath)
+	}
+	return src.Archive.Open(mpqPath)
+}
+
+// lookup resolves a file under the read lock. Callers read the layer
+// after the lock is released; layers are never removed from a stack.
+func (s *MPQStack) lookup(name string) (FileSource, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.archives) == 0 {
+		return FileSource{}, "", errors.New("no MPQs loaded")
+	}
 
-	return nil, errors.New("file not found")
+	i, mpqPath, ok := s.resolve(name)
+	if !ok {
+		return FileSource{}, "", mpq.ErrNotFound
+	}
+	return s.source(i), mpqPath, nil
 }
 
-// HasFile checks if a file exists in any MPQ.
+// HasFile checks if a file exists in any MPQ and is not deleted by a
+// later one.
 func (s *MPQStack) HasFile(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, _, ok := s.resolve(name)
+	return ok
+}
+
+// SourceOf returns which MPQ supplies a file.
+func (s *MPQStack) SourceOf(name string) (*FileSource, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i, _, ok := s.resolve(name)
+	if !ok {
+		return nil, false
+	}
+	src := s.source(i)
+	return &src, true
+}
+
+// Stat describes the version of a file ReadFile would read, without
+// reading it.
+func (s *MPQStack) Stat(name string) (Resolution, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i, mpqPath, ok := s.resolve(name)
+	if !ok {
+		return Resolution{}, false
+	}
+	fi, _ := s.stat(i, mpqPath)
+	return Resolution{FileSource: s.source(i), Info: fi}, true
+}
+
+// List returns every file named by the archives' listfiles or present
+// in a loose-file layer that the stack resolves, sorted. Names keep the
+// casing of the newest layer listing them.
+func (s *MPQStack) List() []string {
+	s.mu.RLock()
+	archives, dirs := s.archives, s.dirs
+	s.mu.RUnlock()
+
+	// Listfiles are read without holding the lock
+	seen := make(map[string]bool)
+	var names []string
+	add := func(name string) {
+		key := strings.ToUpper(name)
+		if !seen[key] {
+			seen[key] = true
+			names = append(names, name)
+		}
+	}
+	for i := len(archives) - 1; i >= 0; i-- {
+		if dirs[i] != nil {
+			for _, name := range dirs[i].List() {
+				add(name)
+			}
+			continue
+		}
+		for _, fi := range archives[i].List() {
+			add(fi.Name)
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := names[:0]
+	for _, name := range names {
+		if _, _, ok := s.resolve(name); ok {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// generation changes whenever a layer is added.
+func (s *MPQStack) generation() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.loadOrder
+}
+
+// Sources lists the layers in load order.
+func (s *MPQStack) Sources() []FileSource {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]FileSource, len(s.archives))
+	for i := range s.archives {
+		out[i] = s.source(i)
+	}
+	return out
+}
+
+// Resolution is one layer's entry for a file.
+type Resolution struct {
+	FileSource
+	Info mpq.FileInfo
+
+	// Deleted is set for delete markers, and for zero-size placeholders
+	// in patch archives over a file a lower layer has. Either hides the
+	// file in all lower layers.
+	Deleted bool
+}
+
+// Chain returns every layer's entry for a file, newest first. The first
+// entry decides the result: if it is Deleted the file does not exist.
+func (s *MPQStack) Chain(name string) []Resolution {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	mpqPath := strings.ReplaceAll(name, "/", "\\")
+
+	var out []Resolution
 	for i := len(s.archives) - 1; i >= 0; i-- {
-		if _, err := s.archives[i].ReadFile(mpqPath); err == nil {
+		fi, ok := s.stat(i, mpqPath)
+		if !ok {
+			continue
+		}
+		out = append(out, Resolution{
+			FileSource: s.source(i),
+			Info:       fi,
+			Deleted:    s.deletes(i, mpqPath, fi),
+		})
+	}
+	return out
+}
+
+// resolve finds the layer that supplies a file: the newest one with an
+// entry for it, which wins even when it deletes the file. The caller
+// holds mu.
+func (s *MPQStack) resolve(name string) (int, string, bool) {
+	mpqPath := strings.ReplaceAll(name, "/", "\\")
+
+	i, ok := s.index[mpq.HashName(mpqPath)]
+	if !ok {
+		i = -1
+	}
+	for j := len(s.unindexed) - 1; j >= 0 && s.unindexed[j] > i; j-- {
+		if _, ok := s.archives[s.unindexed[j]].Stat(mpqPath); ok {
+			i = s.unindexed[j]
+			break
+		}
+	}
+	if i < 0 {
+		return 0, "", false
+	}
+
+	fi, ok := s.stat(i, mpqPath)
+	if !ok || s.deletes(i, mpqPath, fi) {
+		return 0, "", false
+	}
+	return i, mpqPath, true
+}
+
+// stat looks a file up in layer i.
+func (s *MPQStack) stat(i int, mpqPath string) (mpq.FileInfo, bool) {
+	if s.dirs[i] != nil {
+		return s.dirs[i].stat(mpqPath)
+	}
+	return s.archives[i].Stat(mpqPath)
+}
+
+func (s *MPQStack) source(i int) FileSource {
+	return FileSource{
+		Archive: s.archives[i],
+		Dir:     s.dirs[i],
+		Path:    s.paths[i],
+		Order:   i + 1,
+		Patch:   s.patches[i],
+	}
+}
+
+// deletes reports whether layer i's entry for a file removes it from
+// lower layers. Archives do this with delete markers; patch archives
+// also leave empty blocks in place of files they drop. An empty file
+// anywhere else is just an empty file. The caller holds mu.
+func (s *MPQStack) deletes(i int, mpqPath string, fi mpq.FileInfo) bool {
+	if s.dirs[i] != nil {
+		return false
+	}
+	if fi.IsDeleteMarker() {
+		return true
+	}
+	if fi.UncompressedSize != 0 || !s.patches[i] {
+		return false
+	}
+	for j := i - 1; j >= 0; j-- {
+		if _, ok := s.stat(j, mpqPath); ok {
 			return true
 		}
 	}
 	return false
 }
 
-// SourceOf returns which MPQ supplies a file.
-func (s *MPQStack) SourceOf(name string) (*FileSource, bool) {
+// SetLocales sets the locale preference of every archive in the stack,
+// including ones added later. See mpq.MPQ.SetLocales.
+func (s *MPQStack) SetLocales(locales ...uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.locales = append([]uint16{}, locales...)
+	for _, a := range s.archives {
+		if a != nil {
+			a.SetLocales(s.locales...)
+		}
+	}
+}
+
+// Variant is one locale version of a file in one layer. Loose files are
+// locale neutral.
+type Variant struct {
+	FileSource
+	Locale uint16
+	Size   uint32
+}
+
+// Variants lists every locale version of a file in every layer,
+// highest-priority layer first and by LCID within an archive. An entry
+// that deletes the file is left out and hides that locale in all lower
+// layers.
+func (s *MPQStack) Variants(name string) []Variant {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	mpqPath := strings.ReplaceAll(name, "/", "\\")
 
+	var out []Variant
+	deleted := make(map[uint16]bool)
 	for i := len(s.archives) - 1; i >= 0; i-- {
-		if _, err := s.archives[i].ReadFile(mpqPath); err == nil {
-			return &FileSource{
-				Archive: s.archives[i],
-				Path:    s.paths[i],
-				Order:   i + 1,
-			}, true
+		if s.dirs[i] != nil {
+			if fi, ok := s.dirs[i].stat(mpqPath); ok && !deleted[mpq.LocaleNeutral] {
+				out = append(out, Variant{
+					FileSource: s.source(i),
+					Locale:     mpq.LocaleNeutral,
+					Size:       fi.UncompressedSize,
+				})
+			}
+			continue
+		}
+		for _, fi := range s.archives[i].Variants(mpqPath) {
+			if deleted[fi.Locale] {
+				continue
+			}
+			if s.deletes(i, mpqPath, fi) {
+				deleted[fi.Locale] = true
+				continue
+			}
+			out = append(out, Variant{
+				FileSource: s.source(i),
+				Locale:     fi.Locale,
+				Size:       fi.UncompressedSize,
+			})
 		}
 	}
+	return out
+}
+
+// ReadFileLocale reads the highest-priority version of a file tagged
+// with exactly the given locale. The newest entry for that locale wins
+// even when it deletes the file.
+func (s *MPQStack) ReadFileLocale(name string, locale uint16) ([]byte, error) {
+	src, mpqPath, err := s.lookupLocale(name, locale)
+	if err != nil {
+		return nil, err
+	}
+	if src.Dir != nil {
+		return src.Dir.ReadFile(mpqPath)
+	}
+	return src.Archive.ReadFileLocale(mpqPath, locale)
+}
+
+// lookupLocale is lookup for the entries tagged with exactly locale.
+// Loose files are locale neutral.
+func (s *MPQStack) lookupLocale(name string, locale uint16) (FileSource, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	return nil, false
-}
\ No newline at end of file
+	mpqPath := strings.ReplaceAll(name, "/", "\\")
+	for i := len(s.archives) - 1; i >= 0; i-- {
+		if s.dirs[i] != nil {
+			if locale != mpq.LocaleNeutral {
+				continue
+			}
+			if _, ok := s.dirs[i].stat(mpqPath); ok {
+				return s.source(i), mpqPath, nil
+			}
+			continue
+		}
+		for _, fi := range s.archives[i].Variants(mpqPath) {
+			if fi.Locale != locale {
+				continue
+			}
+			if s.deletes(i, mpqPath, fi) {
+				return FileSource{}, "", mpq.ErrNotFound
+			}
+			return s.source(i), mpqPath, nil
+		}
+	}
+	return FileSource{}, "", mpq.ErrNotFound
+}
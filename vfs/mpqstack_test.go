@@ -0,0 +1,248 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"wowmap/mpq"
+)
+
+// writeMPQ saves an archive holding files under dir and opens it.
+func writeMPQ(t testing.TB, dir, name string, files map[string]string) *mpq.MPQ {
+	t.Helper()
+	w := mpq.NewWriter()
+	for n, data := range files {
+		if err := w.Add(n, []byte(data), mpq.MPQ_FILE_COMPRESS, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := filepath.Join(dir, name)
+	if err := w.Save(p); err != nil {
+		t.Fatal(err)
+	}
+	a, err := mpq.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.Close() })
+	return a
+}
+
+// cancelAfter is a context that turns canceled once Err has been called
+// n times, to cancel a read at a known point.
+type cancelAfter struct {
+	context.Context
+	n int
+}
+
+func (c *cancelAfter) Err() error {
+	if c.n--; c.n < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestReadFileContext(t *testing.T) {
+	big := bytes.Repeat([]byte("0123456789abcdef"), (3*readChunkSize+100)/16)
+	s := New()
+	s.Add(writeMPQ(t, t.TempDir(), "common.MPQ", map[string]string{
+		"big.bin": string(big),
+	}))
+
+	if data, err := s.ReadFileContext(context.Background(), "big.bin"); err != nil || !bytes.Equal(data, big) {
+		t.Errorf("ReadFileContext: %d bytes, %v", len(data), err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.ReadFileContext(ctx, "big.bin"); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled before the call: got %v", err)
+	}
+
+	// Canceled after the first chunk: once for the call, once per chunk
+	if _, err := s.ReadFileContext(&cancelAfter{Context: context.Background(), n: 2}, "big.bin"); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled between chunks: got %v", err)
+	}
+
+	if _, err := s.ReadFileContext(context.Background(), "missing.bin"); err == nil {
+		t.Error("missing.bin: expected an error")
+	}
+}
+
+func TestEmptyFilesAndPlaceholders(t *testing.T) {
+	dir := t.TempDir()
+	s := New()
+	s.Add(writeMPQ(t, dir, "common.MPQ", map[string]string{
+		"a.txt": "a",
+		"b.txt": "b",
+	}))
+	s.Add(writeMPQ(t, dir, "expansion.MPQ", map[string]string{
+		"a.txt":     "",
+		"empty.txt": "",
+	}))
+	s.AddPatch(writeMPQ(t, dir, "patch-2.MPQ", map[string]string{
+		"b.txt":     "",
+		"new.txt":   "",
+		"empty.txt": "",
+	}))
+
+	tests := []struct {
+		name    string
+		exists  bool
+		deleted bool
+	}{
+		{"a.txt", true, false},     // empty outside a patch archive
+		{"b.txt", false, true},     // patch placeholder over a lower file
+		{"new.txt", true, false},   // patch adds an empty file
+		{"empty.txt", false, true}, // lower layer has it, even though empty
+	}
+	for _, tt := range tests {
+		if got := s.HasFile(tt.name); got != tt.exists {
+			t.Errorf("HasFile(%q) = %v, want %v", tt.name, got, tt.exists)
+		}
+		chain := s.Chain(tt.name)
+		if len(chain) == 0 || chain[0].Deleted != tt.deleted {
+			t.Errorf("Chain(%q): got %+v, want Deleted %v", tt.name, chain, tt.deleted)
+		}
+	}
+
+	if data, err := s.ReadFile("a.txt"); err != nil || len(data) != 0 {
+		t.Errorf("ReadFile(a.txt): got %q, %v", data, err)
+	}
+}
+
+func TestPatchFlag(t *testing.T) {
+	// Only archives added as patches delete with empty files, whatever
+	// their names
+	dir := t.TempDir()
+	s := New()
+	s.Add(writeMPQ(t, dir, "common.MPQ", map[string]string{
+		"a.txt": "a",
+		"b.txt": "b",
+	}))
+	s.Add(writeMPQ(t, dir, "patch-3.MPQ", map[string]string{
+		"a.txt": "",
+	}))
+	s.AddPatch(writeMPQ(t, dir, "z-override.MPQ", map[string]string{
+		"b.txt": "",
+	}))
+
+	if data, err := s.ReadFile("a.txt"); err != nil || len(data) != 0 {
+		t.Errorf("ReadFile(a.txt) = %q, %v, want it empty", data, err)
+	}
+	if s.HasFile("b.txt") {
+		t.Error("b.txt: deleted by a patch, but still exists")
+	}
+
+	var patches []bool
+	for _, src := range s.Sources() {
+		patches = append(patches, src.Patch)
+	}
+	if !slices.Equal(patches, []bool{false, false, true}) {
+		t.Errorf("Sources: Patch flags %v", patches)
+	}
+}
+
+func TestLocaleLookupsHonorDeletes(t *testing.T) {
+	dir := t.TempDir()
+	s := New()
+	s.Add(writeMPQ(t, dir, "common.MPQ", map[string]string{
+		"a.txt": "a",
+		"b.txt": "b",
+	}))
+	s.AddPatch(writeMPQ(t, dir, "patch-2.MPQ", map[string]string{
+		"b.txt": "",
+	}))
+
+	if data, err := s.ReadFileLocale("b.txt", mpq.LocaleNeutral); err == nil {
+		t.Errorf("ReadFileLocale(b.txt) = %q, want an error", data)
+	}
+	if vs := s.Variants("b.txt"); len(vs) != 0 {
+		t.Errorf("Variants(b.txt) = %+v, want none", vs)
+	}
+
+	if data, err := s.ReadFileLocale("a.txt", mpq.LocaleNeutral); err != nil || string(data) != "a" {
+		t.Errorf("ReadFileLocale(a.txt) = %q, %v", data, err)
+	}
+	if vs := s.Variants("a.txt"); len(vs) != 1 || vs[0].Order != 1 {
+		t.Errorf("Variants(a.txt) = %+v", vs)
+	}
+}
+
+func TestLooseFileLayers(t *testing.T) {
+	dir := t.TempDir()
+	loose := filepath.Join(dir, "loose")
+	if err := os.MkdirAll(loose, 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(loose, "A.TXT"), []byte("loose"), 0644)
+	os.WriteFile(filepath.Join(loose, "c.txt"), []byte("c"), 0644)
+	os.WriteFile(filepath.Join(loose, "empty.txt"), nil, 0644)
+	os.MkdirAll(filepath.Join(loose, "World", "Maps"), 0755)
+	os.WriteFile(filepath.Join(loose, "World", "Maps", "x.adt"), []byte("adt"), 0644)
+	writeMPQ(t, loose, "inner.MPQ", map[string]string{"inner.txt": "inner"})
+	d, err := OpenDir(loose)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := New()
+	s.Add(writeMPQ(t, dir, "common.MPQ", map[string]string{
+		"a.txt":     "a",
+		"empty.txt": "e",
+	}))
+	s.AddDir(d)
+	s.AddPatch(writeMPQ(t, dir, "patch-2.MPQ", map[string]string{
+		"c.txt": "",
+	}))
+
+	// Loose files override archives; an empty one is just empty
+	if data, err := s.ReadFile("a.txt"); err != nil || string(data) != "loose" {
+		t.Errorf("ReadFile(a.txt) = %q, %v", data, err)
+	}
+	if data, err := s.ReadFile("empty.txt"); err != nil || len(data) != 0 {
+		t.Errorf("ReadFile(empty.txt) = %q, %v", data, err)
+	}
+	if data, err := s.ReadFileLocale("a.txt", mpq.LocaleNeutral); err != nil || string(data) != "loose" {
+		t.Errorf("ReadFileLocale(a.txt) = %q, %v", data, err)
+	}
+	if vs := s.Variants("a.txt"); len(vs) != 2 || vs[0].Dir != d {
+		t.Errorf("Variants(a.txt) = %+v", vs)
+	}
+
+	// Nested paths match ignoring case and separators
+	for _, name := range []string{"world/maps/X.ADT", "WORLD\\MAPS\\x.adt", "World\\Maps\\x.adt"} {
+		if data, err := s.ReadFile(name); err != nil || string(data) != "adt" {
+			t.Errorf("ReadFile(%s) = %q, %v", name, data, err)
+		}
+		if src, ok := s.SourceOf(name); !ok || src.Dir != d || src.Archive != nil || src.Path != loose {
+			t.Errorf("SourceOf(%s) = %+v, %v, want the loose layer", name, src, ok)
+		}
+	}
+
+	// Archives in the directory are not loose files
+	for _, name := range []string{"inner.MPQ", "inner.txt"} {
+		if s.HasFile(name) {
+			t.Errorf("%s: found in the loose layer", name)
+		}
+	}
+	if got := d.List(); !slices.Equal(got, []string{"A.TXT", "World\\Maps\\x.adt", "c.txt", "empty.txt"}) {
+		t.Errorf("List = %q", got)
+	}
+
+	// A patch placeholder deletes a loose file below it
+	if s.HasFile("c.txt") {
+		t.Error("c.txt: deleted loose file still exists")
+	}
+	if _, err := s.ReadFileLocale("c.txt", mpq.LocaleNeutral); err == nil {
+		t.Error("ReadFileLocale(c.txt): deleted loose file read")
+	}
+	if vs := s.Variants("c.txt"); len(vs) != 0 {
+		t.Errorf("Variants(c.txt) = %+v, want none", vs)
+	}
+}
@@ -0,0 +1,120 @@
+package vfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"wowmap/mpq"
+)
+
+// TestConcurrentAdd reads through a stack and its FS while archives and
+// loose-file dirs are added. Run it with -race.
+func TestConcurrentAdd(t *testing.T) {
+	const layers = 8
+
+	dir := t.TempDir()
+	base := writeMPQ(t, dir, "base.MPQ", map[string]string{
+		"base.txt":       "base",
+		"shared.txt":     "layer -1",
+		"World\\map.txt": "map",
+	})
+
+	var archives []*mpq.MPQ
+	var dirs []*Dir
+	for i := 0; i < layers; i++ {
+		archives = append(archives, writeMPQ(t, dir, fmt.Sprintf("patch-%d.MPQ", i), map[string]string{
+			"shared.txt":                     fmt.Sprintf("layer %d", i),
+			fmt.Sprintf("World\\a%d.txt", i): "a",
+		}))
+
+		root := filepath.Join(dir, fmt.Sprintf("loose-%d", i))
+		if err := os.MkdirAll(filepath.Join(root, "World"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		os.WriteFile(filepath.Join(root, "shared.txt"), []byte(fmt.Sprintf("loose %d", i)), 0644)
+		os.WriteFile(filepath.Join(root, "World", fmt.Sprintf("l%d.txt", i)), []byte("l"), 0644)
+		d, err := OpenDir(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dirs = append(dirs, d)
+	}
+
+	s := New()
+	s.Add(base)
+	fsys := NewFS(s)
+
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				if data, err := s.ReadFile("base.txt"); err != nil || string(data) != "base" {
+					t.Errorf("ReadFile(base.txt): %q, %v", data, err)
+					return
+				}
+				if data, err := s.ReadFile("shared.txt"); err != nil || !strings.HasPrefix(string(data), "l") {
+					t.Errorf("ReadFile(shared.txt): %q, %v", data, err)
+					return
+				}
+				f, err := s.Open("World/map.txt")
+				if err != nil {
+					t.Errorf("Open: %v", err)
+					return
+				}
+				io.ReadAll(f)
+				f.Close()
+				if _, err := fsys.Glob("World/*.txt"); err != nil {
+					t.Errorf("Glob: %v", err)
+					return
+				}
+				if _, err := fsys.ReadDir("World"); err != nil {
+					t.Errorf("ReadDir: %v", err)
+					return
+				}
+				s.Chain("shared.txt")
+				s.List()
+			}
+		}()
+	}
+
+	var writers sync.WaitGroup
+	writers.Add(2)
+	go func() {
+		defer writers.Done()
+		for _, a := range archives {
+			s.Add(a)
+		}
+	}()
+	go func() {
+		defer writers.Done()
+		for _, d := range dirs {
+			s.AddDir(d)
+			s.SetLocales(0x409)
+		}
+	}()
+	writers.Wait()
+	close(done)
+	readers.Wait()
+
+	if n := len(s.Sources()); n != 1+2*layers {
+		t.Errorf("%d layers, want %d", n, 1+2*layers)
+	}
+	matches, err := fsys.Glob("World/*.txt")
+	if err != nil || len(matches) != 1+2*layers {
+		t.Errorf("Glob after adding: %d matches, %v", len(matches), err)
+	}
+}
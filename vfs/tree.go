@@ -0,0 +1,131 @@
+package vfs
+
+import (
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+/* =========================
+   Directory tree
+   ========================= */
+
+// dirNode is one directory of the tree built from the archives'
+// listfiles. Lookups ignore case like the archives do; names keep the
+// casing they were first listed with.
+type dirNode struct {
+	name  string
+	dirs  map[string]*dirNode // by lower-cased name
+	files map[string]string   // lower-cased name -> name
+}
+
+func newDirNode(name string) *dirNode {
+	return &dirNode{
+		name:  name,
+		dirs:  make(map[string]*dirNode),
+		files: make(map[string]string),
+	}
+}
+
+// buildTree creates the tree for a list of backslash-separated names.
+func buildTree(names []string) *dirNode {
+	root := newDirNode(".")
+	for _, name := range names {
+		parts := strings.Split(name, "\\")
+
+		d := root
+		for _, p := range parts[:len(parts)-1] {
+			if p == "" {
+				continue
+			}
+			key := strings.ToLower(p)
+			sub, ok := d.dirs[key]
+			if !ok {
+				sub = newDirNode(p)
+				d.dirs[key] = sub
+			}
+			d = sub
+		}
+
+		base := parts[len(parts)-1]
+		if key := strings.ToLower(base); base != "" {
+			if _, ok := d.files[key]; !ok {
+				d.files[key] = base
+			}
+		}
+	}
+	return root
+}
+
+// find returns the directory at a slash-separated path; "." and "" are
+// the root.
+func (d *dirNode) find(name string) (*dirNode, bool) {
+	name = strings.Trim(name, "/")
+	if name == "." || name == "" {
+		return d, true
+	}
+
+	for _, p := range strings.Split(name, "/") {
+		sub, ok := d.dirs[strings.ToLower(p)]
+		if !ok {
+			return nil, false
+		}
+		d = sub
+	}
+	return d, true
+}
+
+// walk calls fn with the slash-separated path of every directory and
+// file below d.
+func (d *dirNode) walk(prefix string, fn func(name string)) {
+	for _, sub := range d.dirs {
+		p := joinPath(prefix, sub.name)
+		fn(p)
+		sub.walk(p, fn)
+	}
+	for _, f := range d.files {
+		fn(joinPath(prefix, f))
+	}
+}
+
+// glob returns the paths below d matching pattern. Unlike lookups it
+// compares case, as fs.Glob would on the listed names.
+func (d *dirNode) glob(pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	var out []string
+	d.walk("", func(name string) {
+		if ok, _ := path.Match(pattern, name); ok {
+			out = append(out, name)
+		}
+	})
+	sort.Strings(out)
+	return out, nil
+}
+
+// readDir lists d, found at the slash-separated path dir, sorted by
+// name. stat describes files by path; listed files it doesn't find are
+// left out. FS and CASCFS both list directories this way.
+func (d *dirNode) readDir(dir string, stat func(name string) (fileInfo, bool)) []fs.DirEntry {
+	var out []fs.DirEntry
+	for _, sub := range d.dirs {
+		out = append(out, fs.FileInfoToDirEntry(dirInfo{name: sub.name}))
+	}
+	for _, name := range d.files {
+		if info, ok := stat(joinPath(dir, name)); ok {
+			out = append(out, fs.FileInfoToDirEntry(info))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name() < out[j].Name() })
+	return out
+}
+
+func joinPath(dir, name string) string {
+	if dir == "" || dir == "." {
+		return name
+	}
+	return dir + "/" + name
+}